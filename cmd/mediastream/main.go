@@ -4,34 +4,200 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"strings"
 
 	"github.com/idevakk/mediastream/internal/gui"
 	"github.com/idevakk/mediastream/internal/server"
 )
 
+// stringList is a flag.Value that collects every occurrence of a flag.
+type stringList []string
+
+func (l *stringList) String() string     { return strings.Join(*l, ", ") }
+func (l *stringList) Set(v string) error { *l = append(*l, v); return nil }
+
 func main() {
 	// CLI mode flags — if provided, skip GUI and run headless
-	filePath := flag.String("file", "", "Path to image or video file to stream")
+	var files stringList
+	flag.Var(&files, "file", "Path to image, video, playlist, directory, image sequence (frame_%05d.png), URL, named pipe or - for stdin to stream (repeat to play several in turn)")
 	port := flag.Int("port", 8080, "Port to serve the MJPEG stream on")
-	headless := flag.Bool("headless", false, "Run without GUI (requires --file)")
+	fps := flag.Int("fps", 30, "Default frame rate for every stream")
+	configPath := flag.String("config", "", "JSON config file describing the server and its streams")
+	var streams stringList
+	flag.Var(&streams, "stream", "Named stream as NAME=PATH or NAME@FPS=PATH (repeatable)")
+	hls := flag.Bool("hls", false, "Also serve every stream as HLS (requires FFmpeg)")
+	hlsSegment := flag.String("hls-segment", "mpegts", "HLS segment format: mpegts or fmp4")
+	rtspPort := flag.Int("rtsp-port", 0, "Also serve every stream over RTSP on this port (0 disables)")
+	sourceRoot := flag.String("source-root", "", "Let POST /api/source switch streams to files in this directory (disabled if empty)")
+	start := flag.Float64("start", 0, "Start --file video playback at this many seconds")
+	end := flag.Float64("end", 0, "Loop --file video playback at this many seconds (0 plays to the end)")
+	speed := flag.Float64("speed", 1, "Playback speed for --file videos, 0.25 to 4")
+	matte := flag.String("matte", "", "Background color for transparent GIF pixels, as #RRGGBB (default black)")
+	imageDuration := flag.Float64("image-duration", 5, "Seconds each image is shown when playing several files")
+	gifLoops := flag.Int("gif-loops", 1, "Times each GIF plays when playing several files")
+	shuffle := flag.Bool("shuffle", false, "Play several files in random order")
+	once := flag.Bool("once", false, "Play several files once and hold the last frame instead of repeating")
+	sortBy := flag.String("sort", "name", "Order of a directory slideshow: name or mtime")
+	sequenceCache := flag.Int("sequence-cache", 256, "MB of encoded frames each image sequence keeps in memory")
+	faults := flag.String("faults", "", "Inject camera faults for resilience testing, e.g. seed=1,drop.chance=0.05,outage.every=60,outage.for=5")
+	size := flag.String("size", "", "Resize every frame to WIDTHxHEIGHT, e.g. 640x480 (640x keeps the aspect ratio)")
+	fit := flag.String("fit", "contain", "How frames fit --size: contain, cover, fill or letterbox")
+	crop := flag.String("crop", "", "Crop every frame to WIDTHxHEIGHT+X+Y before other transforms")
+	rotate := flag.Int("rotate", 0, "Rotate every frame clockwise by 90, 180 or 270 degrees")
+	flip := flag.String("flip", "", "Mirror every frame: horizontal, vertical or both")
+	grayscale := flag.Bool("grayscale", false, "Drop the colour from every frame")
+	quality := flag.Int("quality", 0, "JPEG quality of transformed frames, 1 to 100 (default 85)")
+	watermark := flag.String("watermark", "", "PNG logo to composite onto every frame, keeping its transparency")
+	watermarkPosition := flag.String("watermark-position", "bottom-right", "Where the --watermark goes: top-left, top, top-right, left, center, right, bottom-left, bottom or bottom-right")
+	watermarkScale := flag.Float64("watermark-scale", 0, "Width of the --watermark as a fraction of the frame width, e.g. 0.15 (0 keeps its own size)")
+	watermarkMargin := flag.Int("watermark-margin", 16, "Pixels between the --watermark and the frame edges")
+	watermarkOpacity := flag.Float64("watermark-opacity", 1, "Opacity of the --watermark, 0 to 1")
+	var overlays stringList
+	flag.Var(&overlays, "overlay", "Burn text into every frame as TYPE[,KEY=VALUE...], e.g. timestamp,format=%H:%M:%S,timezone=UTC,position=bottom-right,size=24,box (repeatable; types: timestamp, label, counter)")
+	headless := flag.Bool("headless", false, "Run without GUI (requires --file, --stream or --config)")
 	flag.Parse()
 
 	if *headless {
-		if *filePath == "" {
-			fmt.Fprintln(os.Stderr, "error: --file is required in headless mode")
+		cfg := server.Config{
+			Port:            *port,
+			FrameRate:       *fps,
+			HLS:             server.HLSConfig{Enabled: *hls, SegmentType: *hlsSegment},
+			RTSPPort:        *rtspPort,
+			SourceRoot:      *sourceRoot,
+			Matte:           *matte,
+			SequenceCacheMB: *sequenceCache,
+			Playlist: server.PlaylistConfig{
+				ImageDuration: *imageDuration,
+				GIFLoops:      *gifLoops,
+				Shuffle:       *shuffle,
+				Once:          *once,
+				Sort:          *sortBy,
+			},
+		}
+		if *configPath != "" {
+			loaded, err := server.LoadConfig(*configPath)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "error: %v\n", err)
+				os.Exit(1)
+			}
+			// Explicit command-line flags win over the config file.
+			flag.Visit(func(f *flag.Flag) {
+				switch f.Name {
+				case "port":
+					loaded.Port = *port
+				case "fps":
+					loaded.FrameRate = *fps
+				case "hls":
+					loaded.HLS.Enabled = *hls
+				case "hls-segment":
+					loaded.HLS.SegmentType = *hlsSegment
+				case "rtsp-port":
+					loaded.RTSPPort = *rtspPort
+				case "source-root":
+					loaded.SourceRoot = *sourceRoot
+				case "matte":
+					loaded.Matte = *matte
+				case "image-duration":
+					loaded.Playlist.ImageDuration = *imageDuration
+				case "gif-loops":
+					loaded.Playlist.GIFLoops = *gifLoops
+				case "shuffle":
+					loaded.Playlist.Shuffle = *shuffle
+				case "once":
+					loaded.Playlist.Once = *once
+				case "sort":
+					loaded.Playlist.Sort = *sortBy
+				case "sequence-cache":
+					loaded.SequenceCacheMB = *sequenceCache
+				}
+			})
+			if loaded.Port == 0 {
+				loaded.Port = *port
+			}
+			cfg = loaded
+		}
+		switch len(files) {
+		case 0:
+		case 1:
+			cfg.FilePath, cfg.Files = files[0], nil
+		default:
+			cfg.FilePath, cfg.Files = "", files
+		}
+		if len(files) > 0 {
+			cfg.Start, cfg.End, cfg.Speed = *start, *end, *speed
+		}
+		if *faults != "" {
+			fc, err := server.ParseFaults(*faults)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "error: %v\n", err)
+				os.Exit(1)
+			}
+			cfg.Faults = fc
+		}
+		transform := server.TransformConfig{
+			Size:      *size,
+			Crop:      *crop,
+			Rotate:    *rotate,
+			Flip:      *flip,
+			Grayscale: *grayscale,
+			Quality:   *quality,
+		}
+		if transform != (server.TransformConfig{}) {
+			transform.Fit = *fit
+			cfg.Transform = &transform
+		}
+		if *watermark != "" {
+			cfg.Watermark = &server.WatermarkConfig{
+				File:     *watermark,
+				Position: *watermarkPosition,
+				Scale:    *watermarkScale,
+				Margin:   *watermarkMargin,
+				Opacity:  watermarkOpacity,
+			}
+		}
+		if len(overlays) > 0 {
+			cfg.Overlays = nil
+			for _, spec := range overlays {
+				oc, err := server.ParseOverlay(spec)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "error: %v\n", err)
+					os.Exit(1)
+				}
+				cfg.Overlays = append(cfg.Overlays, oc)
+			}
+		}
+		for _, spec := range streams {
+			sc, err := server.ParseStreamSpec(spec)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "error: %v\n", err)
+				os.Exit(1)
+			}
+			cfg.Streams = append(cfg.Streams, sc)
+		}
+
+		if cfg.FilePath == "" && len(cfg.Files) == 0 && len(cfg.Streams) == 0 {
+			fmt.Fprintln(os.Stderr, "error: --file, --stream or --config is required in headless mode")
 			flag.Usage()
 			os.Exit(1)
 		}
-		cfg := server.Config{
-			FilePath: *filePath,
-			Port:     *port,
-		}
+
 		s, err := server.New(cfg)
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "error: %v\n", err)
 			os.Exit(1)
 		}
-		fmt.Printf("Streaming %q on http://localhost:%d/stream\n", *filePath, *port)
+		if len(cfg.Streams) == 0 {
+			fmt.Printf("Streaming %q on %s\n", s.Status()[0].FilePath, s.StreamURL())
+		} else {
+			for _, name := range s.StreamNames() {
+				fmt.Printf("Streaming %q on http://localhost:%d/streams/%s\n", name, cfg.Port, name)
+			}
+		}
+		if cfg.RTSPPort != 0 {
+			for _, name := range s.StreamNames() {
+				fmt.Printf("RTSP %q on %s\n", name, s.RTSPURL(name))
+			}
+		}
 		if err := s.Start(); err != nil {
 			fmt.Fprintf(os.Stderr, "server error: %v\n", err)
 			os.Exit(1)
@@ -6,6 +6,7 @@ import (
 	"net/url"
 	"strconv"
 	"strings"
+	"time"
 
 	"fyne.io/fyne/v2"
 	"fyne.io/fyne/v2/app"
@@ -27,7 +28,7 @@ func Run() {
 
 	w := a.NewWindow("MediaStream")
 	w.SetFixedSize(true)
-	w.Resize(fyne.NewSize(480, 320))
+	w.Resize(fyne.NewSize(480, 800))
 
 	ui := buildUI(w)
 	w.SetContent(ui)
@@ -37,8 +38,9 @@ func Run() {
 
 // state holds mutable UI runtime state.
 type state struct {
-	srv      *server.Server
-	filePath string
+	srv       *server.Server
+	filePath  string
+	watermark string // PNG logo to composite onto the stream, if any
 }
 
 func buildUI(w fyne.Window) fyne.CanvasObject {
@@ -54,6 +56,15 @@ func buildUI(w fyne.Window) fyne.CanvasObject {
 				return
 			}
 			uc.Close()
+
+			// While streaming, switch the running stream to the new file
+			// through the control API; viewers stay connected.
+			if st.srv != nil {
+				if err := st.srv.SetSource("", uc.URI().Path()); err != nil {
+					dialog.ShowError(fmt.Errorf("failed to switch file:\n%v", err), w)
+					return
+				}
+			}
 			st.filePath = uc.URI().Path()
 			fileLabel.SetText(uc.URI().Name())
 		}, w)
@@ -99,11 +110,117 @@ func buildUI(w fyne.Window) fyne.CanvasObject {
 		widget.NewFormItem("Frame Rate (FPS)", fpsEntry),
 	)
 
+	// ── Transforms ──────────────────────────────────────────────────────────
+	sizeEntry := widget.NewEntry()
+	sizeEntry.SetPlaceHolder("e.g. 640x480 (blank keeps the source size)")
+
+	fitSelect := widget.NewSelect([]string{"contain", "cover", "fill", "letterbox"}, nil)
+	fitSelect.SetSelected("contain")
+
+	cropEntry := widget.NewEntry()
+	cropEntry.SetPlaceHolder("e.g. 1280x720+320+180")
+
+	rotations := map[string]int{"None": 0, "90° clockwise": 90, "180°": 180, "90° anticlockwise": 270}
+	rotateSelect := widget.NewSelect([]string{"None", "90° clockwise", "180°", "90° anticlockwise"}, nil)
+	rotateSelect.SetSelected("None")
+
+	flips := map[string]string{"None": "", "Horizontal": "horizontal", "Vertical": "vertical", "Both": "both"}
+	flipSelect := widget.NewSelect([]string{"None", "Horizontal", "Vertical", "Both"}, nil)
+	flipSelect.SetSelected("None")
+
+	grayCheck := widget.NewCheck("Grayscale", nil)
+
+	transformForm := widget.NewForm(
+		widget.NewFormItem("Output Size", sizeEntry),
+		widget.NewFormItem("Fit", fitSelect),
+		widget.NewFormItem("Crop", cropEntry),
+		widget.NewFormItem("Rotate", rotateSelect),
+		widget.NewFormItem("Flip", flipSelect),
+		widget.NewFormItem("", grayCheck),
+	)
+
+	// ── Overlays ────────────────────────────────────────────────────────────
+	labelEntry := widget.NewEntry()
+	labelEntry.SetPlaceHolder("e.g. CAM 01 — Lobby")
+	timestampCheck := widget.NewCheck("Timestamp", nil)
+	counterCheck := widget.NewCheck("Frame counter", nil)
+	boxCheck := widget.NewCheck("Box behind text", nil)
+
+	logoLabel := widget.NewLabel("None")
+	logoLabel.Truncation = fyne.TextTruncateEllipsis
+	logoBtn := widget.NewButtonWithIcon("Logo…", theme.FolderOpenIcon(), func() {
+		fd := dialog.NewFileOpen(func(uc fyne.URIReadCloser, err error) {
+			if err != nil || uc == nil {
+				return
+			}
+			uc.Close()
+			st.watermark = uc.URI().Path()
+			logoLabel.SetText(uc.URI().Name())
+		}, w)
+		fd.SetFilter(storage.NewExtensionFileFilter([]string{".png"}))
+		fd.Show()
+	})
+	logoOpacity := widget.NewSlider(0, 1)
+	logoOpacity.Step = 0.05
+	logoOpacity.SetValue(1)
+
+	overlayForm := widget.NewForm(
+		widget.NewFormItem("Watermark", container.NewBorder(nil, nil, nil, logoBtn, logoLabel)),
+		widget.NewFormItem("Opacity", logoOpacity),
+		widget.NewFormItem("Label", labelEntry),
+		widget.NewFormItem("", container.NewHBox(timestampCheck, counterCheck, boxCheck)),
+	)
+
 	// ── Status & URL ────────────────────────────────────────────────────────
 	statusLabel := widget.NewLabelWithStyle("Stopped", fyne.TextAlignCenter, fyne.TextStyle{Bold: true})
 	urlLabel := widget.NewHyperlink("", nil)
 	urlLabel.Hidden = true
 
+	// ── Playback controls ───────────────────────────────────────────────────
+	var pauseBtn *widget.Button
+	pauseBtn = widget.NewButtonWithIcon("Pause", theme.MediaPauseIcon(), func() {
+		if st.srv == nil {
+			return
+		}
+		if pauseBtn.Text == "Pause" {
+			if err := st.srv.Pause(""); err != nil {
+				dialog.ShowError(err, w)
+				return
+			}
+			pauseBtn.SetText("Resume")
+			pauseBtn.SetIcon(theme.MediaPlayIcon())
+			statusLabel.SetText("❚❚ Paused")
+			return
+		}
+		if err := st.srv.Resume(""); err != nil {
+			dialog.ShowError(err, w)
+			return
+		}
+		pauseBtn.SetText("Pause")
+		pauseBtn.SetIcon(theme.MediaPauseIcon())
+		statusLabel.SetText("● Streaming")
+	})
+	pauseBtn.Disable()
+
+	seekEntry := widget.NewEntry()
+	seekEntry.SetPlaceHolder("Seconds, e.g. 12.5")
+	seekBtn := widget.NewButtonWithIcon("Seek", theme.MediaFastForwardIcon(), func() {
+		if st.srv == nil {
+			return
+		}
+		secs, err := strconv.ParseFloat(seekEntry.Text, 64)
+		if err != nil || secs < 0 {
+			dialog.ShowInformation("Invalid Position", "Enter a non-negative number of seconds.", w)
+			return
+		}
+		if err := st.srv.Seek("", time.Duration(secs*float64(time.Second))); err != nil {
+			dialog.ShowError(err, w)
+		}
+	})
+	seekBtn.Disable()
+
+	controlRow := container.NewBorder(nil, nil, pauseBtn, seekBtn, seekEntry)
+
 	// ── Start / Stop ────────────────────────────────────────────────────────
 	var startBtn, stopBtn *widget.Button
 
@@ -119,6 +236,10 @@ func buildUI(w fyne.Window) fyne.CanvasObject {
 		statusLabel.SetText("Stopped")
 		statusLabel.TextStyle = fyne.TextStyle{Bold: true}
 		urlLabel.Hidden = true
+		pauseBtn.SetText("Pause")
+		pauseBtn.SetIcon(theme.MediaPauseIcon())
+		pauseBtn.Disable()
+		seekBtn.Disable()
 		startBtn.Enable()
 		stopBtn.Disable()
 	})
@@ -143,6 +264,35 @@ func buildUI(w fyne.Window) fyne.CanvasObject {
 			Port:      port,
 			FrameRate: fps,
 		}
+		transform := server.TransformConfig{
+			Size:      strings.TrimSpace(sizeEntry.Text),
+			Crop:      strings.TrimSpace(cropEntry.Text),
+			Rotate:    rotations[rotateSelect.Selected],
+			Flip:      flips[flipSelect.Selected],
+			Grayscale: grayCheck.Checked,
+		}
+		if transform != (server.TransformConfig{}) {
+			transform.Fit = fitSelect.Selected
+			cfg.Transform = &transform
+		}
+		if st.watermark != "" {
+			opacity := logoOpacity.Value
+			cfg.Watermark = &server.WatermarkConfig{
+				File:    st.watermark,
+				Scale:   0.15,
+				Margin:  16,
+				Opacity: &opacity,
+			}
+		}
+		if timestampCheck.Checked {
+			cfg.Overlays = append(cfg.Overlays, server.OverlayConfig{Type: "timestamp", Box: boxCheck.Checked})
+		}
+		if label := strings.TrimSpace(labelEntry.Text); label != "" {
+			cfg.Overlays = append(cfg.Overlays, server.OverlayConfig{Type: "label", Text: label, Box: boxCheck.Checked})
+		}
+		if counterCheck.Checked {
+			cfg.Overlays = append(cfg.Overlays, server.OverlayConfig{Type: "counter", Box: boxCheck.Checked})
+		}
 
 		srv, err := server.New(cfg)
 		if err != nil {
@@ -166,6 +316,8 @@ func buildUI(w fyne.Window) fyne.CanvasObject {
 		statusLabel.SetText("● Streaming")
 		statusLabel.TextStyle = fyne.TextStyle{Bold: true}
 
+		pauseBtn.Enable()
+		seekBtn.Enable()
 		startBtn.Disable()
 		stopBtn.Enable()
 	})
@@ -190,7 +342,14 @@ func buildUI(w fyne.Window) fyne.CanvasObject {
 		widget.NewLabelWithStyle("Settings", fyne.TextAlignLeading, fyne.TextStyle{Bold: true}),
 		form,
 		widget.NewSeparator(),
+		widget.NewLabelWithStyle("Transform", fyne.TextAlignLeading, fyne.TextStyle{Bold: true}),
+		transformForm,
+		widget.NewSeparator(),
+		widget.NewLabelWithStyle("Overlays", fyne.TextAlignLeading, fyne.TextStyle{Bold: true}),
+		overlayForm,
+		widget.NewSeparator(),
 		container.NewGridWithColumns(2, startBtn, stopBtn),
+		controlRow,
 		widget.NewSeparator(),
 		widget.NewLabelWithStyle("Stream URL", fyne.TextAlignLeading, fyne.TextStyle{Bold: true}),
 		urlRow,
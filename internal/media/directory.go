@@ -0,0 +1,167 @@
+package media
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// SortOrder selects how a directory slideshow orders its files.
+type SortOrder int
+
+const (
+	// SortByName plays files in lexical order of their names.
+	SortByName SortOrder = iota
+	// SortByModTime plays the oldest file first, so new files are last.
+	SortByModTime
+)
+
+// WithSort sets the order of files in a directory slideshow.
+func WithSort(order SortOrder) Option {
+	return func(o *options) { o.sort = order }
+}
+
+// dirSettleDelay is how long the directory must be quiet after a change
+// before it is rescanned, so files that are still being copied in are
+// picked up once, complete.
+const dirSettleDelay = 250 * time.Millisecond
+
+// dirSource is a slideshow of every supported file in a directory. It is
+// a playlist whose items are rescanned whenever files are added, removed
+// or replaced, so changes show up without restarting the stream.
+type dirSource struct {
+	*playlistSource
+	dir     string
+	sort    SortOrder
+	watcher *fsnotify.Watcher
+	done    chan struct{}
+	closeMu sync.Once
+}
+
+// newDirSource scans dir and starts watching it for changes. An empty
+// directory is not an error: frames start once a file is added.
+func newDirSource(dir string, frameRate int, o options) (*dirSource, error) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, fmt.Errorf("watching directory %q: %w", dir, err)
+	}
+	if err := watcher.Add(dir); err != nil {
+		watcher.Close()
+		return nil, fmt.Errorf("watching directory %q: %w", dir, err)
+	}
+
+	s := &dirSource{
+		playlistSource: newLivePlaylist(frameRate, o),
+		dir:            dir,
+		sort:           o.sort,
+		watcher:        watcher,
+		done:           make(chan struct{}),
+	}
+	if err := s.rescan(nil); err != nil {
+		watcher.Close()
+		return nil, err
+	}
+	go s.watch()
+	return s, nil
+}
+
+// rescan lists the directory and hands the result to the playlist.
+func (s *dirSource) rescan(changed map[string]bool) error {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return fmt.Errorf("reading directory %q: %w", s.dir, err)
+	}
+
+	type file struct {
+		path    string
+		modTime time.Time
+	}
+	var files []file
+	for _, e := range entries {
+		name := e.Name()
+		path := filepath.Join(s.dir, name)
+		if e.IsDir() || strings.HasPrefix(name, ".") || !isSlideshowFile(path) {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue // removed since ReadDir
+		}
+		files = append(files, file{path, info.ModTime()})
+	}
+
+	if s.sort == SortByModTime {
+		sort.SliceStable(files, func(i, j int) bool {
+			return files[i].modTime.Before(files[j].modTime)
+		})
+	} // ReadDir already sorts by name
+
+	paths := make([]string, len(files))
+	for i, f := range files {
+		paths[i] = f.path
+	}
+	return s.setPaths(paths, changed)
+}
+
+// isSlideshowFile reports whether the file at path is an image or video
+// Open plays. Playlists are excluded. Files whose extension Open doesn't
+// know, such as .jfif or none at all, are identified by their content.
+func isSlideshowFile(path string) bool {
+	ext := strings.ToLower(filepath.Ext(path))
+	if ext == ".m3u" || ext == ".txt" {
+		return false
+	}
+	for _, e := range SupportedExtensions {
+		if ext == e {
+			return true
+		}
+	}
+	format, _ := detectFormat(path)
+	return strings.HasPrefix(format, "image/") || strings.HasPrefix(format, "video/")
+}
+
+// watch collects file system events and rescans once they settle.
+func (s *dirSource) watch() {
+	defer close(s.done)
+
+	settle := time.NewTimer(dirSettleDelay)
+	settle.Stop()
+	changed := make(map[string]bool)
+
+	for {
+		select {
+		case ev, ok := <-s.watcher.Events:
+			if !ok {
+				return
+			}
+			changed[ev.Name] = true
+			settle.Reset(dirSettleDelay)
+
+		case _, ok := <-s.watcher.Errors:
+			if !ok {
+				return
+			}
+			// Events may have been dropped; rescan to catch up.
+			settle.Reset(dirSettleDelay)
+
+		case <-settle.C:
+			s.rescan(changed) //nolint:errcheck // keeps the last listing
+			changed = make(map[string]bool)
+		}
+	}
+}
+
+// Close stops watching the directory and closes the current item.
+func (s *dirSource) Close() error {
+	s.closeMu.Do(func() {
+		s.watcher.Close()
+		<-s.done
+	})
+	return s.playlistSource.Close()
+}
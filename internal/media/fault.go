@@ -0,0 +1,207 @@
+package media
+
+import (
+	"errors"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// Errors a Source returns from NextFrame to simulate camera faults.
+// Consumers treat them differently from ordinary read errors.
+var (
+	// ErrFrameDropped means no frame is available this tick.
+	ErrFrameDropped = errors.New("frame dropped")
+	// ErrConnectionReset asks the consumer to cut off every viewer
+	// abruptly, like a camera dropping its connections.
+	ErrConnectionReset = errors.New("connection reset")
+	// ErrOutage means the source is unreachable: viewers are cut off and
+	// new ones refused until frames flow again.
+	ErrOutage = errors.New("source outage")
+)
+
+// FaultSpec schedules one kind of fault. Every fires it at a fixed
+// interval, Chance fires it at random on each frame, and both may be set.
+// For is how long a freeze, latency spike or outage lasts.
+type FaultSpec struct {
+	Every  time.Duration
+	Chance float64
+	For    time.Duration
+}
+
+func (f FaultSpec) enabled() bool { return f.Every > 0 || f.Chance > 0 }
+
+// FaultConfig describes the faults WithFaults injects. Seed makes random
+// faults reproducible; zero picks a seed from the clock.
+type FaultConfig struct {
+	Seed     int64
+	Freeze   FaultSpec // repeat the current frame
+	Drop     FaultSpec // skip a frame
+	Latency  FaultSpec // stall delivery
+	Corrupt  FaultSpec // garble the JPEG scan data
+	Truncate FaultSpec // cut the JPEG short
+	Reset    FaultSpec // disconnect every viewer
+	Outage   FaultSpec // go dark entirely
+}
+
+// Default fault durations used when FaultSpec.For is zero.
+const (
+	defaultFreezeFor  = 2 * time.Second
+	defaultLatencyFor = 500 * time.Millisecond
+	defaultOutageFor  = 5 * time.Second
+)
+
+// Unwrapper is implemented by sources that wrap another Source.
+type Unwrapper interface {
+	Unwrap() Source
+}
+
+// As finds the first source in src's chain of wrapped sources that
+// implements T, so optional interfaces like Seeker still work through
+// wrappers such as WithFaults.
+func As[T any](src Source) (T, bool) {
+	for src != nil {
+		if t, ok := src.(T); ok {
+			return t, true
+		}
+		u, ok := src.(Unwrapper)
+		if !ok {
+			break
+		}
+		src = u.Unwrap()
+	}
+	var zero T
+	return zero, false
+}
+
+// faultSource wraps a Source and injects the faults in cfg.
+type faultSource struct {
+	Source
+	cfg FaultConfig
+
+	mu          sync.Mutex
+	rng         *rand.Rand
+	next        map[*FaultSpec]time.Time // next scheduled firing per fault
+	freezeUntil time.Time
+	outageUntil time.Time
+	last        []byte
+}
+
+// WithFaults wraps src so that its frames suffer the faults in cfg,
+// emulating unreliable camera hardware.
+func WithFaults(src Source, cfg FaultConfig) Source {
+	if cfg.Freeze.For == 0 {
+		cfg.Freeze.For = defaultFreezeFor
+	}
+	if cfg.Latency.For == 0 {
+		cfg.Latency.For = defaultLatencyFor
+	}
+	if cfg.Outage.For == 0 {
+		cfg.Outage.For = defaultOutageFor
+	}
+	seed := cfg.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+
+	s := &faultSource{
+		Source: src,
+		cfg:    cfg,
+		rng:    rand.New(rand.NewSource(seed)),
+		next:   make(map[*FaultSpec]time.Time),
+	}
+	now := time.Now()
+	for _, f := range s.specs() {
+		if f.Every > 0 {
+			s.next[f] = now.Add(f.Every)
+		}
+	}
+	return s
+}
+
+func (s *faultSource) specs() []*FaultSpec {
+	c := &s.cfg
+	return []*FaultSpec{&c.Freeze, &c.Drop, &c.Latency, &c.Corrupt, &c.Truncate, &c.Reset, &c.Outage}
+}
+
+// fires reports whether fault f triggers now, by schedule or by chance.
+// Called with s.mu held.
+func (s *faultSource) fires(f *FaultSpec, now time.Time) bool {
+	if !f.enabled() {
+		return false
+	}
+	if f.Every > 0 && !now.Before(s.next[f]) {
+		s.next[f] = now.Add(f.Every)
+		return true
+	}
+	return f.Chance > 0 && s.rng.Float64() < f.Chance
+}
+
+// NextFrame reads from the wrapped source unless a fault intervenes.
+func (s *faultSource) NextFrame() ([]byte, error) {
+	s.mu.Lock()
+	now := time.Now()
+	c := &s.cfg
+
+	if now.Before(s.outageUntil) {
+		s.mu.Unlock()
+		return nil, ErrOutage
+	}
+	if s.fires(&c.Outage, now) {
+		s.outageUntil = now.Add(c.Outage.For)
+		s.mu.Unlock()
+		return nil, ErrOutage
+	}
+	if s.fires(&c.Reset, now) {
+		s.mu.Unlock()
+		return nil, ErrConnectionReset
+	}
+	if now.Before(s.freezeUntil) && s.last != nil {
+		last := s.last
+		s.mu.Unlock()
+		return last, nil
+	}
+	s.mu.Unlock()
+
+	frame, err := s.Source.NextFrame()
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.last = frame
+
+	if s.fires(&c.Freeze, now) {
+		s.freezeUntil = now.Add(c.Freeze.For)
+	}
+	if s.fires(&c.Drop, now) {
+		return nil, ErrFrameDropped
+	}
+	if s.fires(&c.Latency, now) {
+		time.Sleep(c.Latency.For)
+	}
+	if s.fires(&c.Truncate, now) && len(frame) > 4 {
+		frame = append([]byte(nil), frame[:len(frame)/2]...)
+	}
+	if s.fires(&c.Corrupt, now) && len(frame) > 4 {
+		frame = s.corrupt(frame)
+	}
+	return frame, nil
+}
+
+// corrupt returns a copy of frame with random bytes overwritten in its
+// second half, where the entropy-coded scan data usually lies, so the
+// headers still parse but the picture decodes as garbage or not at all.
+// Called with s.mu held.
+func (s *faultSource) corrupt(frame []byte) []byte {
+	out := append([]byte(nil), frame...)
+	half := len(out) / 2
+	for i := 0; i < 16; i++ {
+		out[half+s.rng.Intn(len(out)-half-2)] = byte(s.rng.Intn(256))
+	}
+	return out
+}
+
+// Unwrap returns the source the faults are applied to.
+func (s *faultSource) Unwrap() Source { return s.Source }
@@ -3,11 +3,16 @@ package media
 import (
 	"bytes"
 	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
 	"image/gif"
 	"image/jpeg"
 	"os"
 	"sync"
 	"time"
+
+	"github.com/idevakk/mediastream/internal/metrics"
 )
 
 // gifFrame holds a single decoded GIF frame and the delay before the next one.
@@ -22,21 +27,25 @@ type gifSource struct {
 	frames  []gifFrame
 	index   int
 	lastAt  time.Time
+	paused  time.Time // zero unless paused
 }
 
-// newGIFSource opens path, decodes every frame to JPEG, and returns a gifSource.
-// frameRate is used only as a fallback when a GIF frame has zero delay.
-func newGIFSource(path string, frameRate int) (*gifSource, error) {
+// newGIFSource opens path, composites every frame and encodes it to JPEG,
+// and returns a gifSource. frameRate is used only as a fallback when a GIF
+// frame has zero delay.
+func newGIFSource(path string, frameRate int, o options) (*gifSource, error) {
 	f, err := os.Open(path)
 	if err != nil {
 		return nil, fmt.Errorf("opening GIF %q: %w", path, err)
 	}
 	defer f.Close()
 
+	start := time.Now()
 	g, err := gif.DecodeAll(f)
 	if err != nil {
 		return nil, fmt.Errorf("decoding GIF %q: %w", path, err)
 	}
+	metrics.DecodeSeconds.With("gif").ObserveSince(start)
 	if len(g.Image) == 0 {
 		return nil, fmt.Errorf("GIF %q contains no frames", path)
 	}
@@ -44,11 +53,13 @@ func newGIFSource(path string, frameRate int) (*gifSource, error) {
 	fallbackDelay := time.Duration(float64(time.Second) / float64(frameRate))
 
 	frames := make([]gifFrame, 0, len(g.Image))
-	for i, img := range g.Image {
+	err = compositeGIF(g, o.matte, func(i int, img image.Image) error {
 		var buf bytes.Buffer
+		start := time.Now()
 		if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 90}); err != nil {
-			return nil, fmt.Errorf("encoding GIF frame %d: %w", i, err)
+			return fmt.Errorf("encoding GIF frame %d: %w", i, err)
 		}
+		metrics.EncodeSeconds.With("gif").ObserveSince(start)
 
 		// GIF delays are in hundredths of a second
 		delay := time.Duration(g.Delay[i]) * 10 * time.Millisecond
@@ -57,11 +68,78 @@ func newGIFSource(path string, frameRate int) (*gifSource, error) {
 		}
 
 		frames = append(frames, gifFrame{data: buf.Bytes(), delay: delay})
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
 
 	return &gifSource{frames: frames, lastAt: time.Now()}, nil
 }
 
+// compositeGIF renders each frame of g as it appears on screen and passes
+// it to emit. Optimized GIFs store only the part of each frame that
+// changed, so frames are drawn in order onto a logical screen, honouring
+// each frame's disposal method, and the result is flattened onto matte.
+// The image passed to emit is reused for the next frame.
+func compositeGIF(g *gif.GIF, matte color.Color, emit func(i int, img image.Image) error) error {
+	screen := image.Rect(0, 0, g.Config.Width, g.Config.Height)
+	if screen.Empty() {
+		for _, img := range g.Image {
+			screen = screen.Union(img.Bounds())
+		}
+	}
+
+	// The background color is an index into the global color table. When
+	// a frame marks that index transparent, as most encoders do, disposing
+	// to the background clears to transparent, matching browsers.
+	background := func(img *image.Paletted) color.Color {
+		if int(g.BackgroundIndex) < len(img.Palette) {
+			if _, _, _, a := img.Palette[g.BackgroundIndex].RGBA(); a == 0 {
+				return color.Transparent
+			}
+		}
+		if p, ok := g.Config.ColorModel.(color.Palette); ok && int(g.BackgroundIndex) < len(p) {
+			return p[g.BackgroundIndex]
+		}
+		return color.Transparent
+	}
+
+	canvas := image.NewRGBA(screen)
+	draw.Draw(canvas, screen, image.NewUniform(background(g.Image[0])), image.Point{}, draw.Src)
+	out := image.NewRGBA(screen)
+	var previous *image.RGBA
+
+	for i, img := range g.Image {
+		disposal := byte(gif.DisposalNone)
+		if i < len(g.Disposal) {
+			disposal = g.Disposal[i]
+		}
+		if disposal == gif.DisposalPrevious {
+			if previous == nil {
+				previous = image.NewRGBA(screen)
+			}
+			copy(previous.Pix, canvas.Pix)
+		}
+
+		draw.Draw(canvas, img.Bounds(), img, img.Bounds().Min, draw.Over)
+
+		draw.Draw(out, screen, image.NewUniform(matte), image.Point{}, draw.Src)
+		draw.Draw(out, screen, canvas, screen.Min, draw.Over)
+		if err := emit(i, out); err != nil {
+			return err
+		}
+
+		switch disposal {
+		case gif.DisposalBackground:
+			draw.Draw(canvas, img.Bounds(), image.NewUniform(background(img)), image.Point{}, draw.Src)
+		case gif.DisposalPrevious:
+			copy(canvas.Pix, previous.Pix)
+		}
+	}
+	return nil
+}
+
 // NextFrame returns the current frame, advancing to the next one when the
 // frame's delay has elapsed. This makes the GIF play back at its native speed
 // regardless of how often NextFrame is called.
@@ -70,11 +148,67 @@ func (s *gifSource) NextFrame() ([]byte, error) {
 	defer s.mu.Unlock()
 
 	frame := s.frames[s.index]
-	if time.Since(s.lastAt) >= frame.delay {
+	if s.paused.IsZero() && time.Since(s.lastAt) >= frame.delay {
 		s.index = (s.index + 1) % len(s.frames)
 		s.lastAt = time.Now()
 	}
 	return frame.data, nil
 }
 
+// Seek jumps to the frame that is showing pos into the animation. Positions
+// past the end wrap around, as the GIF loops forever.
+func (s *gifSource) Seek(pos time.Duration) error {
+	if pos < 0 {
+		return fmt.Errorf("invalid seek position %v", pos)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	pos %= s.duration()
+
+	now := time.Now()
+	for i, f := range s.frames {
+		if pos < f.delay {
+			s.index = i
+			s.lastAt = now.Add(-pos)
+			break
+		}
+		pos -= f.delay
+	}
+	if !s.paused.IsZero() {
+		s.paused = now
+	}
+	return nil
+}
+
+// duration returns the length of one pass through the animation.
+func (s *gifSource) duration() time.Duration {
+	var total time.Duration
+	for _, f := range s.frames {
+		total += f.delay
+	}
+	return total
+}
+
+// Pause freezes the animation on the current frame.
+func (s *gifSource) Pause() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.paused.IsZero() {
+		s.paused = time.Now()
+	}
+}
+
+// Resume continues the animation, keeping the time already spent on the
+// current frame before the pause.
+func (s *gifSource) Resume() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.paused.IsZero() {
+		s.lastAt = s.lastAt.Add(time.Since(s.paused))
+		s.paused = time.Time{}
+	}
+}
+
 func (s *gifSource) Close() error { return nil }
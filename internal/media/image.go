@@ -8,8 +8,12 @@ import (
 	_ "image/png"  // register PNG decoder
 	_ "golang.org/x/image/webp" // register WebP decoder
 	_ "golang.org/x/image/bmp"  // register BMP decoder
+	_ "golang.org/x/image/tiff" // register TIFF decoder
 	"os"
 	"sync"
+	"time"
+
+	"github.com/idevakk/mediastream/internal/metrics"
 )
 
 // imageSource streams a single static image indefinitely.
@@ -21,23 +25,35 @@ type imageSource struct {
 // newImageSource reads and decodes the image at path, then re-encodes it
 // as a JPEG so that downstream consumers always receive consistent bytes.
 func newImageSource(path string) (*imageSource, error) {
+	frame, err := encodeImageFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return &imageSource{frame: frame}, nil
+}
+
+// encodeImageFile decodes the image at path and re-encodes it as JPEG.
+func encodeImageFile(path string) ([]byte, error) {
 	f, err := os.Open(path)
 	if err != nil {
 		return nil, fmt.Errorf("opening image %q: %w", path, err)
 	}
 	defer f.Close()
 
+	start := time.Now()
 	img, _, err := image.Decode(f)
 	if err != nil {
 		return nil, fmt.Errorf("decoding image %q: %w", path, err)
 	}
+	metrics.DecodeSeconds.With("image").ObserveSince(start)
 
 	var buf bytes.Buffer
+	start = time.Now()
 	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 92}); err != nil {
 		return nil, fmt.Errorf("re-encoding image as JPEG: %w", err)
 	}
-
-	return &imageSource{frame: buf.Bytes()}, nil
+	metrics.EncodeSeconds.With("image").ObserveSince(start)
+	return buf.Bytes(), nil
 }
 
 func (s *imageSource) NextFrame() ([]byte, error) {
@@ -46,4 +62,7 @@ func (s *imageSource) NextFrame() ([]byte, error) {
 	return s.frame, nil
 }
 
+// IsStatic reports true: an image source returns the same frame forever.
+func (s *imageSource) IsStatic() bool { return true }
+
 func (s *imageSource) Close() error { return nil }
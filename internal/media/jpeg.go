@@ -0,0 +1,154 @@
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// maxJPEGFrame bounds how much unframed data readJPEG buffers before it
+// gives up on finding the end of a frame.
+const maxJPEGFrame = 32 << 20
+
+// jpegReadChunk is how much readJPEG asks the reader for at a time.
+const jpegReadChunk = 64 << 10
+
+var jpegSOI = []byte{0xFF, 0xD8}
+
+// errMalformedJPEG reports a byte sequence that starts like a JPEG but
+// breaks the marker structure.
+var errMalformedJPEG = errors.New("malformed JPEG")
+
+// readJPEG returns the next complete JPEG from a stream of back-to-back
+// JPEGs, such as FFmpeg's image2pipe output. buf holds bytes read from r
+// but not yet returned; it must be passed unchanged to the next call so
+// that data past the end of one frame becomes the start of the next.
+//
+// Bytes before a start-of-image marker are skipped, and a candidate that
+// turns out to be malformed is dropped in favour of the next SOI.
+func readJPEG(r io.Reader, buf *bytes.Buffer) ([]byte, error) {
+	for {
+		data := buf.Bytes()
+		if i := bytes.Index(data, jpegSOI); i < 0 {
+			// Keep a trailing 0xFF: it may be the first half of an SOI.
+			keep := 0
+			if len(data) > 0 && data[len(data)-1] == 0xFF {
+				keep = 1
+			}
+			buf.Next(len(data) - keep)
+		} else {
+			buf.Next(i)
+			n, err := scanJPEG(buf.Bytes())
+			switch {
+			case err != nil:
+				buf.Next(len(jpegSOI))
+				continue
+			case n > 0:
+				frame := make([]byte, n)
+				copy(frame, buf.Next(n))
+				return frame, nil
+			}
+		}
+
+		if buf.Len() > maxJPEGFrame {
+			buf.Reset()
+			return nil, fmt.Errorf("%w: no end of image within %d bytes", errMalformedJPEG, maxJPEGFrame)
+		}
+
+		buf.Grow(jpegReadChunk)
+		tail := buf.AvailableBuffer()[:jpegReadChunk]
+		n, err := r.Read(tail)
+		buf.Write(tail[:n])
+		if err != nil && n == 0 {
+			if err == io.EOF && buf.Len() > 0 {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+	}
+}
+
+// scanJPEG walks the marker segments of the JPEG at the start of b and
+// returns its length including the EOI marker. It returns 0 and no error
+// if b holds only the beginning of a JPEG.
+//
+// Segment lengths are followed rather than searching for the first EOI, so
+// JPEGs embedded in metadata (such as EXIF thumbnails) are skipped over.
+func scanJPEG(b []byte) (int, error) {
+	if !bytes.HasPrefix(b, jpegSOI) {
+		return 0, fmt.Errorf("%w: missing SOI marker", errMalformedJPEG)
+	}
+
+	pos := 2
+	for {
+		if pos >= len(b) {
+			return 0, nil
+		}
+		if b[pos] != 0xFF {
+			return 0, fmt.Errorf("%w: expected marker at offset %d", errMalformedJPEG, pos)
+		}
+		for pos+1 < len(b) && b[pos+1] == 0xFF { // fill bytes
+			pos++
+		}
+		if pos+1 >= len(b) {
+			return 0, nil
+		}
+
+		marker := b[pos+1]
+		switch {
+		case marker == 0xD9: // EOI
+			return pos + 2, nil
+		case marker == 0x00 || marker == 0xD8:
+			return 0, fmt.Errorf("%w: unexpected marker 0x%02X at offset %d", errMalformedJPEG, marker, pos)
+		case marker == 0x01 || (marker >= 0xD0 && marker <= 0xD7): // TEM, RSTn
+			pos += 2
+			continue
+		}
+
+		if pos+4 > len(b) {
+			return 0, nil
+		}
+		length := int(binary.BigEndian.Uint16(b[pos+2:]))
+		if length < 2 {
+			return 0, fmt.Errorf("%w: segment 0x%02X has length %d", errMalformedJPEG, marker, length)
+		}
+		pos += 2 + length
+
+		if marker == 0xDA { // SOS: entropy-coded data follows
+			end, ok := scanEntropyData(b, pos)
+			if !ok {
+				return 0, nil
+			}
+			pos = end
+		}
+	}
+}
+
+// scanEntropyData returns the offset of the first marker at or after pos
+// that ends an entropy-coded segment. Stuffed zero bytes and restart
+// markers are part of the data. ok is false if b ends first.
+func scanEntropyData(b []byte, pos int) (end int, ok bool) {
+	for pos < len(b) {
+		i := bytes.IndexByte(b[pos:], 0xFF)
+		if i < 0 {
+			return 0, false
+		}
+		pos += i
+		if pos+1 >= len(b) {
+			return 0, false
+		}
+		next := b[pos+1]
+		if next == 0x00 || (next >= 0xD0 && next <= 0xD7) {
+			pos += 2
+			continue
+		}
+		if next == 0xFF { // fill byte before a marker
+			pos++
+			continue
+		}
+		return pos, true
+	}
+	return 0, false
+}
@@ -0,0 +1,143 @@
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"io"
+	"testing"
+	"testing/iotest"
+)
+
+// encodeTestJPEG returns a small baseline JPEG filled with c.
+func encodeTestJPEG(t testing.TB, size int, c color.Color) []byte {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, size, size))
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			img.Set(x, y, c)
+		}
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, nil); err != nil {
+		t.Fatalf("encoding test JPEG: %v", err)
+	}
+	return buf.Bytes()
+}
+
+// withEXIFThumbnail inserts an APP1 segment carrying thumb after the SOI
+// of j, the way cameras embed EXIF thumbnails.
+func withEXIFThumbnail(j, thumb []byte) []byte {
+	payload := append([]byte("Exif\x00\x00"), thumb...)
+	seg := []byte{0xFF, 0xE1, 0, 0}
+	binary.BigEndian.PutUint16(seg[2:], uint16(len(payload)+2))
+	seg = append(seg, payload...)
+
+	out := append([]byte{}, j[:2]...)
+	out = append(out, seg...)
+	return append(out, j[2:]...)
+}
+
+func TestReadJPEGSplitsBackToBackFrames(t *testing.T) {
+	frames := [][]byte{
+		encodeTestJPEG(t, 16, color.RGBA{R: 255, A: 255}),
+		withEXIFThumbnail(encodeTestJPEG(t, 32, color.RGBA{G: 255, A: 255}), encodeTestJPEG(t, 8, color.White)),
+		encodeTestJPEG(t, 24, color.RGBA{B: 255, A: 255}),
+	}
+	stream := []byte("garbage before the first frame")
+	for _, f := range frames {
+		stream = append(stream, f...)
+	}
+
+	// One byte at a time exercises every split point of every frame.
+	r := iotest.OneByteReader(bytes.NewReader(stream))
+	var buf bytes.Buffer
+	for i, want := range frames {
+		got, err := readJPEG(r, &buf)
+		if err != nil {
+			t.Fatalf("frame %d: %v", i, err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Fatalf("frame %d: got %d bytes, want %d", i, len(got), len(want))
+		}
+	}
+	if _, err := readJPEG(r, &buf); err != io.EOF {
+		t.Fatalf("expected io.EOF after the last frame, got %v", err)
+	}
+}
+
+func TestReadJPEGTruncatedFrame(t *testing.T) {
+	j := encodeTestJPEG(t, 16, color.White)
+	var buf bytes.Buffer
+	if _, err := readJPEG(bytes.NewReader(j[:len(j)-10]), &buf); err != io.ErrUnexpectedEOF {
+		t.Fatalf("expected io.ErrUnexpectedEOF, got %v", err)
+	}
+}
+
+func FuzzScanJPEG(f *testing.F) {
+	j := encodeTestJPEG(f, 16, color.White)
+	f.Add(j)
+	f.Add(withEXIFThumbnail(j, j))
+	f.Add(j[:len(j)/2])
+	f.Add([]byte{0xFF, 0xD8, 0xFF, 0xDA, 0x00, 0x02, 0xFF, 0x00, 0xFF, 0xD0, 0xFF, 0xD9})
+
+	f.Fuzz(func(t *testing.T, b []byte) {
+		n, err := scanJPEG(b)
+		if err != nil {
+			return
+		}
+		if n < 0 || n > len(b) {
+			t.Fatalf("length %d out of range for %d bytes", n, len(b))
+		}
+		if n > 0 && !bytes.Equal(b[n-2:n], []byte{0xFF, 0xD9}) {
+			t.Fatalf("frame of %d bytes does not end with EOI", n)
+		}
+	})
+}
+
+func FuzzReadJPEG(f *testing.F) {
+	j := encodeTestJPEG(f, 16, color.White)
+	f.Add(j, uint8(1))
+	f.Add(append(append([]byte{}, j...), withEXIFThumbnail(j, j)...), uint8(7))
+
+	f.Fuzz(func(t *testing.T, stream []byte, chunk uint8) {
+		r := io.Reader(bytes.NewReader(stream))
+		if chunk > 0 {
+			r = &chunkReader{r: r, n: int(chunk)}
+		}
+
+		// Every frame returned must be a prefix-complete JPEG taken from
+		// the stream in order, and reading must terminate.
+		var buf bytes.Buffer
+		rest := stream
+		for i := 0; ; i++ {
+			frame, err := readJPEG(r, &buf)
+			if err != nil {
+				return
+			}
+			if n, err := scanJPEG(frame); err != nil || n != len(frame) {
+				t.Fatalf("frame %d is not a complete JPEG: n=%d err=%v", i, n, err)
+			}
+			k := bytes.Index(rest, frame)
+			if k < 0 {
+				t.Fatalf("frame %d does not appear in the remaining stream", i)
+			}
+			rest = rest[k+len(frame):]
+		}
+	})
+}
+
+// chunkReader returns at most n bytes per Read.
+type chunkReader struct {
+	r io.Reader
+	n int
+}
+
+func (c *chunkReader) Read(p []byte) (int, error) {
+	if len(p) > c.n {
+		p = p[:c.n]
+	}
+	return c.r.Read(p)
+}
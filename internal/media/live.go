@@ -0,0 +1,184 @@
+package media
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/url"
+	"os/exec"
+	"path"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// LiveSchemes lists the URL schemes Open reads as live network streams
+// through FFmpeg. http and https URLs are live too when they name an HLS
+// playlist (.m3u8).
+var LiveSchemes = []string{"rtsp", "rtsps", "rtmp", "rtmps", "rtp", "udp", "tcp", "srt"}
+
+// isLiveURL reports whether path is a network stream FFmpeg should read
+// as it arrives rather than as a file.
+func isLiveURL(p string) bool {
+	u, err := url.Parse(p)
+	if err != nil || !isURL(p) {
+		return false
+	}
+	scheme := strings.ToLower(u.Scheme)
+	for _, s := range LiveSchemes {
+		if scheme == s {
+			return true
+		}
+	}
+	return (scheme == "http" || scheme == "https") && strings.EqualFold(path.Ext(u.Path), ".m3u8")
+}
+
+// liveSource reads a network stream such as an RTSP camera or a live HLS
+// playlist through FFmpeg. Unlike a file, the input sets its own pace, so
+// FFmpeg runs without -re or -stream_loop and its output is drained in the
+// background. When the stream drops or stalls, FFmpeg is restarted with
+// backoff while the last frame keeps being served.
+type liveSource struct {
+	relayState
+	frameRate int
+	stderr    tailBuffer
+	quit      chan struct{}
+	done      chan struct{}
+
+	procMu sync.Mutex
+	cmd    *exec.Cmd
+	closed bool
+}
+
+// newLiveSource starts FFmpeg reading url. Reaching the stream is left to
+// the background loop, since cameras are often briefly unavailable.
+func newLiveSource(url string, frameRate int) (*liveSource, error) {
+	if _, err := exec.LookPath("ffmpeg"); err != nil {
+		return nil, fmt.Errorf(
+			"ffmpeg not found in PATH — please install FFmpeg to stream network inputs: %w", err,
+		)
+	}
+
+	s := &liveSource{
+		relayState: relayState{input: url},
+		frameRate:  frameRate,
+		quit:       make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+	go func() {
+		defer close(s.done)
+		s.reconnect(s.quit, s.session)
+	}()
+	return s, nil
+}
+
+// args returns the FFmpeg command line for the input.
+func (s *liveSource) args() []string {
+	// Don't buffer input ahead; a live viewer wants the newest frame.
+	args := []string{"-fflags", "nobuffer"}
+	scheme, _, _ := strings.Cut(strings.ToLower(s.input), ":")
+	switch scheme {
+	case "rtsp", "rtsps":
+		// UDP transport loses packets through NAT and firewalls.
+		args = append(args, "-rtsp_transport", "tcp")
+	case "http", "https":
+		args = append(args, "-reconnect", "1", "-reconnect_streamed", "1", "-reconnect_delay_max", "5")
+	}
+	args = append(args, "-i", s.input)
+	return append(args, jpegOutputArgs(fmt.Sprintf("fps=%d", s.frameRate))...)
+}
+
+// session runs one FFmpeg process and relays its frames until it exits
+// or stops producing frames.
+func (s *liveSource) session() (bool, error) {
+	cmd := exec.Command("ffmpeg", s.args()...)
+	cmd.Stderr = &s.stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return false, fmt.Errorf("creating ffmpeg stdout pipe: %w", err)
+	}
+	if err := cmd.Start(); err != nil {
+		return false, fmt.Errorf("starting ffmpeg: %w", err)
+	}
+
+	s.procMu.Lock()
+	if s.closed {
+		s.procMu.Unlock()
+		cmd.Process.Kill() //nolint:errcheck
+		cmd.Wait()         //nolint:errcheck
+		return false, errSourceClosed
+	}
+	s.cmd = cmd
+	s.procMu.Unlock()
+
+	relayed, err := s.relay(cmd, stdout)
+
+	s.procMu.Lock()
+	s.cmd = nil
+	s.procMu.Unlock()
+	if tail := s.stderr.lastLine(); tail != "" {
+		err = fmt.Errorf("%w (ffmpeg: %s)", err, tail)
+	}
+	return relayed, err
+}
+
+// relay reads frames from FFmpeg until it exits, killing it if no frame
+// arrives within liveStallTimeout.
+func (s *liveSource) relay(cmd *exec.Cmd, stdout io.Reader) (bool, error) {
+	var stalled atomic.Bool
+	watchdog := time.AfterFunc(liveStallTimeout, func() {
+		stalled.Store(true)
+		cmd.Process.Kill() //nolint:errcheck
+	})
+	defer watchdog.Stop()
+
+	var buf bytes.Buffer
+	relayed := false
+	for {
+		frame, err := readJPEG(stdout, &buf)
+		if err != nil {
+			if err != io.EOF && err != io.ErrUnexpectedEOF {
+				cmd.Process.Kill() //nolint:errcheck
+			}
+			waitErr := cmd.Wait()
+			switch {
+			case stalled.Load():
+				return relayed, fmt.Errorf("no frame from %s in %v", s.input, liveStallTimeout)
+			case waitErr != nil:
+				return relayed, fmt.Errorf("ffmpeg reading %s: %w", s.input, waitErr)
+			default:
+				return relayed, fmt.Errorf("%s ended the stream", s.input)
+			}
+		}
+
+		watchdog.Reset(liveStallTimeout)
+		s.store(frame)
+		relayed = true
+	}
+}
+
+// Health reports the stream's state and FFmpeg's recent stderr.
+func (s *liveSource) Health() Health {
+	h := s.relayState.Health()
+	h.Stderr = s.stderr.String()
+	return h
+}
+
+// Close stops FFmpeg and the reconnect loop.
+func (s *liveSource) Close() error {
+	s.procMu.Lock()
+	if s.closed {
+		s.procMu.Unlock()
+		return nil
+	}
+	s.closed = true
+	close(s.quit)
+	if s.cmd != nil {
+		s.cmd.Process.Kill() //nolint:errcheck
+	}
+	s.procMu.Unlock()
+
+	<-s.done
+	return nil
+}
@@ -4,8 +4,13 @@ package media
 
 import (
 	"fmt"
+	"image/color"
+	"math"
+	"net/url"
+	"os"
 	"path/filepath"
 	"strings"
+	"time"
 )
 
 // Source is the common interface for all media types.
@@ -20,30 +25,255 @@ type Source interface {
 	Close() error
 }
 
+// Static is implemented by sources whose frames never change, such as a
+// single image. Consumers use it to enable HTTP caching of frames.
+type Static interface {
+	IsStatic() bool
+}
+
+// Seeker is implemented by sources that can jump to a playback position,
+// measured from the start of the media.
+type Seeker interface {
+	Seek(pos time.Duration) error
+}
+
+// Pauser is implemented by sources whose playback advances with wall-clock
+// time rather than with calls to NextFrame. Pause freezes that clock and
+// Resume restarts it where it stopped.
+type Pauser interface {
+	Pause()
+	Resume()
+}
+
+// Player is implemented by sources whose clip bounds and playback speed can
+// change while streaming.
+type Player interface {
+	// SetClip restricts playback to [start, end) and restarts at start.
+	// An end of zero plays to the end of the media.
+	SetClip(start, end time.Duration) error
+	// SetSpeed changes the playback speed, keeping the current position.
+	SetSpeed(speed float64) error
+}
+
+// Monitor is implemented by sources backed by an external process that
+// can fail and be restarted while streaming.
+type Monitor interface {
+	Health() Health
+}
+
+// Health describes the state of a monitored source.
+type Health struct {
+	// Running is false while the source is waiting to restart after a
+	// failure; consumers keep receiving the last good frame meanwhile.
+	Running   bool   `json:"running"`
+	Restarts  int    `json:"restarts"`
+	LastError string `json:"last_error,omitempty"`
+	// Stderr holds the last lines the process wrote to standard error.
+	Stderr string `json:"stderr,omitempty"`
+}
+
+// Playback speed limits accepted by WithSpeed and Player.SetSpeed.
+const (
+	MinSpeed = 0.25
+	MaxSpeed = 4.0
+)
+
+// Option configures a source opened by Open.
+type Option func(*options)
+
+// options holds the settings collected from Option values.
+type options struct {
+	start, end time.Duration
+	speed      float64
+	matte      color.Color
+
+	// Playlist settings.
+	imageDuration time.Duration
+	gifLoops      int
+	shuffle       bool
+	repeat        bool
+	sort          SortOrder // directory slideshows only
+	cacheBudget   int64     // image sequences only
+
+	once bool // play videos to the end once instead of looping
+}
+
+// defaultOptions returns the settings used when no Option overrides them.
+func defaultOptions() options {
+	return options{
+		speed:         1,
+		matte:         color.Black,
+		imageDuration: 5 * time.Second,
+		gifLoops:      1,
+		repeat:        true,
+		cacheBudget:   DefaultCacheBudget,
+	}
+}
+
+// WithStart starts video playback at d, and loops back to d at the end.
+func WithStart(d time.Duration) Option {
+	return func(o *options) { o.start = d }
+}
+
+// WithEnd stops video playback at d and loops back to the start, so that
+// together with WithStart a sub-clip plays on repeat.
+func WithEnd(d time.Duration) Option {
+	return func(o *options) { o.end = d }
+}
+
+// WithSpeed sets the video playback speed, from MinSpeed to MaxSpeed.
+func WithSpeed(speed float64) Option {
+	return func(o *options) { o.speed = speed }
+}
+
+// WithMatte sets the color that transparent GIF pixels are flattened onto
+// before JPEG encoding, which has no alpha channel. The default is black.
+func WithMatte(c color.Color) Option {
+	return func(o *options) { o.matte = c }
+}
+
+// WithImageDuration sets how long a playlist shows each still image.
+// The default is five seconds.
+func WithImageDuration(d time.Duration) Option {
+	return func(o *options) { o.imageDuration = d }
+}
+
+// WithGIFLoops sets how many times a playlist plays each animated GIF
+// before moving on. The default is once.
+func WithGIFLoops(n int) Option {
+	return func(o *options) { o.gifLoops = n }
+}
+
+// WithShuffle plays playlist items in random order, reshuffled on every
+// pass through the list.
+func WithShuffle(shuffle bool) Option {
+	return func(o *options) { o.shuffle = shuffle }
+}
+
+// WithRepeat controls whether a playlist starts over after its last item
+// (the default) or holds the last frame.
+func WithRepeat(repeat bool) Option {
+	return func(o *options) { o.repeat = repeat }
+}
+
+// validateClip checks clip bounds shared by Open and Player.SetClip.
+func validateClip(start, end time.Duration) error {
+	if start < 0 || end < 0 {
+		return fmt.Errorf("clip bounds must not be negative")
+	}
+	if end != 0 && end <= start {
+		return fmt.Errorf("clip end %v must be after start %v", end, start)
+	}
+	return nil
+}
+
+// validateSpeed checks a playback speed shared by Open and Player.SetSpeed.
+func validateSpeed(speed float64) error {
+	if math.IsNaN(speed) || speed < MinSpeed || speed > MaxSpeed {
+		return fmt.Errorf("speed %g out of range [%g, %g]", speed, MinSpeed, MaxSpeed)
+	}
+	return nil
+}
+
 // SupportedExtensions lists every file extension Open can handle.
 var SupportedExtensions = []string{
 	".jpg", ".jpeg", ".png", ".webp", ".bmp", // static images
+	".tif", ".tiff",                      // TIFF images
 	".gif",                               // animated GIF
 	".mp4", ".mkv", ".mov", ".avi",       // common video containers
 	".webm", ".flv", ".ts", ".m4v",       // additional video formats
+	".m3u", ".txt",                       // playlists
 }
 
-// Open inspects the file extension and returns the appropriate Source.
-// frameRate is only used for video sources; it is ignored for images.
-func Open(path string, frameRate int) (Source, error) {
-	ext := strings.ToLower(filepath.Ext(path))
+// Open detects the file's format from its content, falling back to its
+// extension, and returns the appropriate Source. A file it can't play
+// yields an *UnsupportedFormatError.
+// A directory is played as a slideshow that follows changes on disk, and a
+// printf-style pattern (frame_%05d.png) or a glob matching some files
+// (frames/*.jpg) as an image sequence. Paths starting with PatternScheme generate test
+// patterns and need no file at all. Network streams with a scheme in
+// LiveSchemes, and http(s) HLS playlists, are read live through FFmpeg;
+// http(s) URLs of video files play like local ones, and any other http(s)
+// URL relays an upstream MJPEG stream such as an IP camera. StdinPath or
+// the path of a named pipe reads back-to-back JPEGs written by another
+// program.
+// frameRate paces videos, live streams, test patterns, image sequences,
+// playlists and directory slideshows, and GIF frames without a delay of
+// their own. Still images, MJPEG relays and pipes ignore it.
+// The playback options (WithStart, WithEnd, WithSpeed) apply to videos,
+// and the playlist options to .m3u and .txt playlists and directories.
+func Open(path string, frameRate int, opts ...Option) (Source, error) {
+	o, err := collectOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+	return open(path, frameRate, o)
+}
+
+// collectOptions applies opts over the defaults and validates the result.
+func collectOptions(opts []Option) (options, error) {
+	o := defaultOptions()
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if err := validateClip(o.start, o.end); err != nil {
+		return options{}, err
+	}
+	if err := validateSpeed(o.speed); err != nil {
+		return options{}, err
+	}
+	if o.imageDuration <= 0 {
+		return options{}, fmt.Errorf("image duration must be positive, got %v", o.imageDuration)
+	}
+	if o.cacheBudget < 0 {
+		return options{}, fmt.Errorf("cache budget must not be negative, got %d", o.cacheBudget)
+	}
+	if o.gifLoops < 1 {
+		return options{}, fmt.Errorf("GIF loops must be at least 1, got %d", o.gifLoops)
+	}
+	return o, nil
+}
+
+// open dispatches on the detected format with already validated options.
+// A directory opens as a slideshow of the files in it.
+func open(path string, frameRate int, o options) (Source, error) {
+	if strings.HasPrefix(path, PatternScheme) {
+		return newPatternSource(path, frameRate)
+	}
+	if isLiveURL(path) {
+		return newLiveSource(path, frameRate)
+	}
+	if isHTTPURL(path) {
+		if u, err := url.Parse(path); err == nil && strings.HasPrefix(extFormats[strings.ToLower(filepath.Ext(u.Path))], "video/") {
+			return newVideoSource(path, frameRate, o)
+		}
+		return newMJPEGSource(path)
+	}
+	if isPipe(path) {
+		return newPipeSource(path), nil
+	}
+	if info, err := os.Stat(path); err == nil && info.IsDir() {
+		return newDirSource(path, frameRate, o)
+	}
+	if isSequencePattern(path) {
+		return newSequenceSource(path, frameRate, o)
+	}
 
-	switch ext {
-	case ".jpg", ".jpeg", ".png", ".webp", ".bmp":
+	format, head := detectFormat(path)
+	switch {
+	case format == "image/gif":
+		return newGIFSource(path, frameRate, o)
+	case strings.HasPrefix(format, "image/"):
 		return newImageSource(path)
-	case ".gif":
-		return newGIFSource(path, frameRate)
-	case ".mp4", ".mkv", ".mov", ".avi", ".webm", ".flv", ".ts", ".m4v":
-		return newVideoSource(path, frameRate)
+	case strings.HasPrefix(format, "video/"):
+		return newVideoSource(path, frameRate, o)
+	case format == "audio/x-mpegurl", format == "text/plain":
+		paths, err := readPlaylist(path)
+		if err != nil {
+			return nil, err
+		}
+		return newPlaylistSource(paths, frameRate, o)
 	default:
-		return nil, fmt.Errorf(
-			"unsupported file type %q — supported formats: %s",
-			ext, strings.Join(SupportedExtensions, ", "),
-		)
+		return nil, &UnsupportedFormatError{Path: path, MIMEType: contentType(head)}
 	}
 }
@@ -1,12 +1,28 @@
 package media_test
 
 import (
+	"bytes"
+	"errors"
+	"fmt"
 	"image"
 	"image/color"
+	"image/gif"
 	"image/jpeg"
+	"io/fs"
+	"math"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
 	"os"
 	"path/filepath"
+	"runtime"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
+
+	"golang.org/x/image/tiff"
 
 	"github.com/idevakk/mediastream/internal/media"
 )
@@ -23,6 +39,29 @@ func TestOpenMissingFile(t *testing.T) {
 	if err == nil {
 		t.Fatal("expected error for missing file, got nil")
 	}
+	// Glob characters in a name that matches nothing aren't a sequence.
+	if _, err := media.Open("/nonexistent/path/shot[1].jpg", 30); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("expected a not-found error, got %v", err)
+	}
+}
+
+func TestOpenRejectsInvalidPlayback(t *testing.T) {
+	path := writeMinimalJPEG(t)
+	cases := map[string][]media.Option{
+		"speed too low":  {media.WithSpeed(0.1)},
+		"speed too high": {media.WithSpeed(8)},
+		"speed NaN":      {media.WithSpeed(math.NaN())},
+		"negative start": {media.WithStart(-time.Second)},
+		"end before start": {
+			media.WithStart(5 * time.Second),
+			media.WithEnd(2 * time.Second),
+		},
+	}
+	for name, opts := range cases {
+		if _, err := media.Open(path, 30, opts...); err == nil {
+			t.Errorf("%s: expected error, got nil", name)
+		}
+	}
 }
 
 // writeMinimalJPEG creates a valid JPEG in dir and returns the path.
@@ -71,3 +110,833 @@ func TestImageSourceNextFrame(t *testing.T) {
 		t.Fatal("static image returned different frame sizes")
 	}
 }
+
+// writeTwoFrameGIF creates a GIF whose frames are black then white, each
+// shown for one second.
+func writeTwoFrameGIF(t *testing.T) string {
+	t.Helper()
+	palette := color.Palette{color.Black, color.White}
+	g := &gif.GIF{}
+	for i := 0; i < 2; i++ {
+		frame := image.NewPaletted(image.Rect(0, 0, 8, 8), palette)
+		for j := range frame.Pix {
+			frame.Pix[j] = uint8(i)
+		}
+		g.Image = append(g.Image, frame)
+		g.Delay = append(g.Delay, 100)
+	}
+
+	path := filepath.Join(t.TempDir(), "test.gif")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("creating test GIF: %v", err)
+	}
+	defer f.Close()
+
+	if err := gif.EncodeAll(f, g); err != nil {
+		t.Fatalf("encoding test GIF: %v", err)
+	}
+	return path
+}
+
+// writeOptimizedGIF writes a 16x16 GIF whose later frames only store a
+// changed 8x8 corner, as GIF optimizers do. The background is transparent.
+func writeOptimizedGIF(t *testing.T) string {
+	t.Helper()
+	red, blue := color.RGBA{R: 255, A: 255}, color.RGBA{B: 255, A: 255}
+	palette := color.Palette{color.Transparent, red, blue}
+	fill := func(r image.Rectangle, index uint8) *image.Paletted {
+		img := image.NewPaletted(r, palette)
+		for j := range img.Pix {
+			img.Pix[j] = index
+		}
+		return img
+	}
+
+	g := &gif.GIF{
+		Image: []*image.Paletted{
+			fill(image.Rect(0, 0, 16, 16), 1), // red everywhere
+			fill(image.Rect(0, 0, 8, 8), 2),   // blue top-left, then cleared
+			fill(image.Rect(8, 8, 16, 16), 0), // transparent patch
+		},
+		Delay:    []int{100, 100, 100},
+		Disposal: []byte{gif.DisposalNone, gif.DisposalBackground, gif.DisposalNone},
+		Config:   image.Config{ColorModel: palette, Width: 16, Height: 16},
+	}
+
+	path := filepath.Join(t.TempDir(), "optimized.gif")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("creating test GIF: %v", err)
+	}
+	defer f.Close()
+
+	if err := gif.EncodeAll(f, g); err != nil {
+		t.Fatalf("encoding test GIF: %v", err)
+	}
+	return path
+}
+
+// pixelAt decodes a JPEG frame and returns the 8-bit RGB color at (x, y).
+func pixelAt(t *testing.T, frame []byte, x, y int) [3]uint8 {
+	t.Helper()
+	img, err := jpeg.Decode(bytes.NewReader(frame))
+	if err != nil {
+		t.Fatalf("decoding frame: %v", err)
+	}
+	r, g, b, _ := img.At(x, y).RGBA()
+	return [3]uint8{uint8(r >> 8), uint8(g >> 8), uint8(b >> 8)}
+}
+
+func TestGIFSourceCompositesFrames(t *testing.T) {
+	src, err := media.Open(writeOptimizedGIF(t), 30, media.WithMatte(color.White))
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer src.Close()
+	seeker := src.(media.Seeker)
+
+	near := func(got [3]uint8, want [3]uint8) bool {
+		for i := range got {
+			if d := int(got[i]) - int(want[i]); d < -40 || d > 40 {
+				return false
+			}
+		}
+		return true
+	}
+	red, blue, white := [3]uint8{255, 0, 0}, [3]uint8{0, 0, 255}, [3]uint8{255, 255, 255}
+
+	checks := []struct {
+		frame       int
+		topLeft     [3]uint8
+		bottomRight [3]uint8
+	}{
+		{0, red, red},
+		{1, blue, red},  // the partial frame keeps the rest of the screen
+		{2, white, red}, // background disposal exposes the matte
+	}
+	for _, c := range checks {
+		if err := seeker.Seek(time.Duration(c.frame) * time.Second); err != nil {
+			t.Fatalf("Seek: %v", err)
+		}
+		frame, _ := src.NextFrame()
+		if got := pixelAt(t, frame, 4, 4); !near(got, c.topLeft) {
+			t.Errorf("frame %d top-left: got %v, want %v", c.frame, got, c.topLeft)
+		}
+		if got := pixelAt(t, frame, 12, 12); !near(got, c.bottomRight) {
+			t.Errorf("frame %d bottom-right: got %v, want %v", c.frame, got, c.bottomRight)
+		}
+	}
+}
+
+func TestGIFSourceSeekAndPause(t *testing.T) {
+	src, err := media.Open(writeTwoFrameGIF(t), 30)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer src.Close()
+
+	first, _ := src.NextFrame()
+
+	seeker, ok := src.(media.Seeker)
+	if !ok {
+		t.Fatal("GIF source should implement media.Seeker")
+	}
+	pauser, ok := src.(media.Pauser)
+	if !ok {
+		t.Fatal("GIF source should implement media.Pauser")
+	}
+
+	pauser.Pause()
+	if err := seeker.Seek(1500 * time.Millisecond); err != nil {
+		t.Fatalf("Seek: %v", err)
+	}
+	second, _ := src.NextFrame()
+	if bytes.Equal(first, second) {
+		t.Fatal("expected the second frame after seeking 1.5s")
+	}
+
+	time.Sleep(600 * time.Millisecond) // past the frame's remaining 0.5s
+	if frame, _ := src.NextFrame(); !bytes.Equal(frame, second) {
+		t.Fatal("paused GIF advanced")
+	}
+
+	pauser.Resume()
+	time.Sleep(600 * time.Millisecond)
+	src.NextFrame() //nolint:errcheck
+	if frame, _ := src.NextFrame(); !bytes.Equal(frame, first) {
+		t.Fatal("resumed GIF did not advance to the next frame")
+	}
+}
+
+// installFakeFFmpeg puts a shell script named ffmpeg first in PATH. It
+// writes the JPEG at jpegPath once, then fails with a message on stderr.
+func installFakeFFmpeg(t *testing.T, jpegPath string) {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("fake ffmpeg is a shell script")
+	}
+	dir := t.TempDir()
+	script := "#!/bin/sh\ncat '" + jpegPath + "'\necho 'decoder exploded' >&2\nexit 1\n"
+	if err := os.WriteFile(filepath.Join(dir, "ffmpeg"), []byte(script), 0o755); err != nil {
+		t.Fatalf("writing fake ffmpeg: %v", err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+}
+
+func TestVideoSourceRestartsAfterCrash(t *testing.T) {
+	jpegPath := writeMinimalJPEG(t)
+	want, err := os.ReadFile(jpegPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	installFakeFFmpeg(t, jpegPath)
+
+	src, err := media.Open(filepath.Join(t.TempDir(), "clip.mp4"), 30)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer src.Close()
+	mon, ok := src.(media.Monitor)
+	if !ok {
+		t.Fatal("video source should implement media.Monitor")
+	}
+
+	if frame, err := src.NextFrame(); err != nil || !bytes.Equal(frame, want) {
+		t.Fatalf("first frame: %v", err)
+	}
+
+	// FFmpeg has exited: the last good frame keeps being served.
+	if frame, err := src.NextFrame(); err != nil || !bytes.Equal(frame, want) {
+		t.Fatalf("expected the last good frame after a crash, got err %v", err)
+	}
+	h := mon.Health()
+	if h.Running || !strings.Contains(h.LastError, "decoder exploded") {
+		t.Fatalf("unexpected health after crash: %+v", h)
+	}
+
+	time.Sleep(600 * time.Millisecond) // past the first backoff delay
+	src.NextFrame()                    //nolint:errcheck
+	if h := mon.Health(); h.Restarts != 1 {
+		t.Fatalf("expected 1 restart, got %+v", h)
+	}
+}
+
+// installLoopingFFmpeg puts a fake ffmpeg first in PATH that reports a
+// one-second input, logs its arguments to the returned file and sends
+// frames JPEGs before idling. Started with -ss it sends nothing and exits
+// cleanly, like FFmpeg seeking to the very end of a file.
+func installLoopingFFmpeg(t *testing.T, jpegPath string, frames int) string {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("fake ffmpeg is a shell script")
+	}
+	dir := t.TempDir()
+	argsLog := filepath.Join(dir, "args")
+	script := "#!/bin/sh\necho \"$@\" >> '" + argsLog + "'\n" +
+		"echo '  Duration: 00:00:01.00, start: 0.000000, bitrate: 1 kb/s' >&2\n" +
+		"case \"$*\" in *-ss*) exit 0;; esac\n" +
+		"i=0\nwhile [ $i -lt " + fmt.Sprint(frames) + " ]; do cat '" + jpegPath + "'; i=$((i+1)); done\n" +
+		"exec sleep 5\n"
+	if err := os.WriteFile(filepath.Join(dir, "ffmpeg"), []byte(script), 0o755); err != nil {
+		t.Fatalf("writing fake ffmpeg: %v", err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	return argsLog
+}
+
+func TestVideoSourceWrapsLoopedPosition(t *testing.T) {
+	argsLog := installLoopingFFmpeg(t, writeMinimalJPEG(t), 45)
+
+	src, err := media.Open(filepath.Join(t.TempDir(), "clip.mp4"), 30)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer src.Close()
+
+	// 45 frames at 30fps is 1.5s into a 1s file that FFmpeg loops itself.
+	for i := 0; i < 45; i++ {
+		if _, err := src.NextFrame(); err != nil {
+			t.Fatalf("frame %d: %v", i, err)
+		}
+	}
+	player, _ := media.As[media.Player](src)
+	if err := player.SetSpeed(2); err != nil {
+		t.Fatalf("SetSpeed: %v", err)
+	}
+	var args []byte
+	for deadline := time.Now().Add(2 * time.Second); time.Now().Before(deadline); {
+		if args, _ = os.ReadFile(argsLog); bytes.Count(args, []byte("\n")) >= 2 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if !strings.Contains(string(args), "-ss 0.500") {
+		t.Fatalf("expected a restart half a second in, got arguments %q", args)
+	}
+
+	seeker, _ := media.As[media.Seeker](src)
+	if err := seeker.Seek(1500 * time.Millisecond); err == nil {
+		t.Fatal("expected seeking past the end of the file to fail")
+	}
+}
+
+func TestVideoSourceLoopsAfterEmptyExit(t *testing.T) {
+	installLoopingFFmpeg(t, writeMinimalJPEG(t), 1)
+
+	src, err := media.Open(filepath.Join(t.TempDir(), "clip.mp4"), 30)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer src.Close()
+	src.NextFrame() //nolint:errcheck
+
+	// FFmpeg exits without output when started at the very end, which
+	// should loop back to the start rather than count as a crash.
+	seeker, _ := media.As[media.Seeker](src)
+	if err := seeker.Seek(990 * time.Millisecond); err != nil {
+		t.Fatalf("Seek: %v", err)
+	}
+	if _, err := src.NextFrame(); err != nil {
+		t.Fatalf("NextFrame after seek: %v", err)
+	}
+	h := src.(media.Monitor).Health()
+	if !h.Running || h.Restarts != 0 || h.LastError != "" {
+		t.Fatalf("expected a clean loop, got %+v", h)
+	}
+}
+
+// writeColorJPEG writes a 1x1 JPEG of color c into dir and returns its path.
+func writeColorJPEG(t *testing.T, dir, name string, c color.Color) string {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, 1, 1))
+	img.Set(0, 0, c)
+
+	path := filepath.Join(dir, name)
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("creating test JPEG: %v", err)
+	}
+	defer f.Close()
+	if err := jpeg.Encode(f, img, nil); err != nil {
+		t.Fatalf("encoding test JPEG: %v", err)
+	}
+	return path
+}
+
+func TestPlaylistFile(t *testing.T) {
+	dir := t.TempDir()
+	writeColorJPEG(t, dir, "red.jpg", color.RGBA{R: 255, A: 255})
+	writeColorJPEG(t, dir, "blue.jpg", color.RGBA{B: 255, A: 255})
+	list := filepath.Join(dir, "scenes.m3u")
+	if err := os.WriteFile(list, []byte("#EXTM3U\nred.jpg\n\n# comment\nblue.jpg\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	src, err := media.Open(list, 30, media.WithImageDuration(100*time.Millisecond))
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer src.Close()
+
+	first, _ := src.NextFrame()
+	time.Sleep(150 * time.Millisecond)
+	second, _ := src.NextFrame()
+	time.Sleep(150 * time.Millisecond)
+	third, _ := src.NextFrame()
+
+	if bytes.Equal(first, second) {
+		t.Fatal("playlist did not move to the second image")
+	}
+	if !bytes.Equal(first, third) {
+		t.Fatal("playlist did not repeat from the first image")
+	}
+}
+
+func TestPlaylistOnceHoldsLastFrame(t *testing.T) {
+	dir := t.TempDir()
+	paths := []string{
+		writeColorJPEG(t, dir, "red.jpg", color.RGBA{R: 255, A: 255}),
+		writeColorJPEG(t, dir, "missing.jpg", color.Black),
+		writeColorJPEG(t, dir, "blue.jpg", color.RGBA{B: 255, A: 255}),
+	}
+	os.Remove(paths[1]) // unplayable items are skipped
+
+	src, err := media.OpenPlaylist(paths, 30,
+		media.WithImageDuration(50*time.Millisecond), media.WithRepeat(false))
+	if err != nil {
+		t.Fatalf("OpenPlaylist: %v", err)
+	}
+	defer src.Close()
+
+	first, _ := src.NextFrame()
+	time.Sleep(80 * time.Millisecond)
+	second, _ := src.NextFrame()
+	time.Sleep(80 * time.Millisecond)
+	third, _ := src.NextFrame()
+
+	if bytes.Equal(first, second) || !bytes.Equal(second, third) {
+		t.Fatal("expected the playlist to stop on its last image")
+	}
+}
+
+func TestOpenPlaylistRejectsEmpty(t *testing.T) {
+	if _, err := media.OpenPlaylist(nil, 30); err == nil {
+		t.Fatal("expected error for an empty playlist")
+	}
+}
+
+func TestDirectorySlideshowFollowsChanges(t *testing.T) {
+	dir := t.TempDir()
+	src, err := media.Open(dir, 30, media.WithImageDuration(time.Hour))
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer src.Close()
+
+	if _, err := src.NextFrame(); err == nil {
+		t.Fatal("expected an error while the directory is empty")
+	}
+
+	// A file dropped into the directory starts the slideshow.
+	writeColorJPEG(t, dir, "shot.jpg", color.RGBA{R: 255, A: 255})
+	time.Sleep(500 * time.Millisecond)
+	red, err := src.NextFrame()
+	if err != nil {
+		t.Fatalf("NextFrame after adding a file: %v", err)
+	}
+
+	// Replacing the file on disk shows the new contents.
+	writeColorJPEG(t, dir, "shot.jpg", color.RGBA{B: 255, A: 255})
+	time.Sleep(500 * time.Millisecond)
+	blue, err := src.NextFrame()
+	if err != nil {
+		t.Fatalf("NextFrame after replacing the file: %v", err)
+	}
+	if bytes.Equal(red, blue) {
+		t.Fatal("slideshow kept showing the replaced file")
+	}
+}
+
+func TestDirectorySlideshowSniffsUnknownExtensions(t *testing.T) {
+	dir := t.TempDir()
+	writeColorJPEG(t, dir, "a.jfif", color.RGBA{R: 255, A: 255})
+	writeColorJPEG(t, dir, "b", color.RGBA{B: 255, A: 255})
+	if err := os.WriteFile(filepath.Join(dir, "c.bin"), []byte("not an image"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	src, err := media.Open(dir, 30, media.WithImageDuration(50*time.Millisecond))
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer src.Close()
+
+	seen := make(map[string]bool)
+	for i := 0; i < 10; i++ {
+		frame, err := src.NextFrame()
+		if err != nil {
+			t.Fatalf("NextFrame: %v", err)
+		}
+		seen[string(frame)] = true
+		time.Sleep(30 * time.Millisecond)
+	}
+	if len(seen) != 2 {
+		t.Fatalf("expected the .jfif and extensionless JPEGs, saw %d distinct frames", len(seen))
+	}
+}
+
+func TestImageSequence(t *testing.T) {
+	dir := t.TempDir()
+	colors := []color.Color{
+		color.RGBA{R: 255, A: 255},
+		color.RGBA{G: 255, A: 255},
+		color.RGBA{B: 255, A: 255},
+	}
+	for i, c := range colors {
+		writeColorJPEG(t, dir, fmt.Sprintf("frame_%03d.jpg", i+1), c)
+	}
+	writeColorJPEG(t, dir, "frame_999_unrelated.jpg", color.White)
+
+	for _, pattern := range []string{"frame_%03d.jpg", "frame_[0-9][0-9][0-9].jpg"} {
+		t.Run(pattern, func(t *testing.T) {
+			// A one-byte budget forces every frame out of the cache
+			// after use, exercising re-decoding on the next loop.
+			src, err := media.Open(filepath.Join(dir, pattern), 10, media.WithCacheBudget(1))
+			if err != nil {
+				t.Fatalf("Open: %v", err)
+			}
+			defer src.Close()
+
+			seen := make(map[string]bool)
+			for i := 0; i < 8; i++ {
+				frame, err := src.NextFrame()
+				if err != nil {
+					t.Fatalf("NextFrame: %v", err)
+				}
+				seen[string(frame)] = true
+				time.Sleep(100 * time.Millisecond)
+			}
+			if len(seen) != len(colors) {
+				t.Fatalf("expected %d distinct frames, saw %d", len(colors), len(seen))
+			}
+		})
+	}
+}
+
+func TestImageSequenceNoMatches(t *testing.T) {
+	if _, err := media.Open(filepath.Join(t.TempDir(), "frame_%05d.png"), 30); err == nil {
+		t.Fatal("expected error for a pattern matching no files")
+	}
+}
+
+func TestPatternSources(t *testing.T) {
+	for _, kind := range media.PatternKinds {
+		t.Run(kind, func(t *testing.T) {
+			src, err := media.Open(media.PatternScheme+kind+"?w=320&h=180&fps=50", 30)
+			if err != nil {
+				t.Fatalf("Open: %v", err)
+			}
+			defer src.Close()
+
+			first, err := src.NextFrame()
+			if err != nil {
+				t.Fatalf("NextFrame: %v", err)
+			}
+			cfg, err := jpeg.DecodeConfig(bytes.NewReader(first))
+			if err != nil || cfg.Width != 320 || cfg.Height != 180 {
+				t.Fatalf("expected a 320x180 JPEG, got %+v (%v)", cfg, err)
+			}
+
+			time.Sleep(30 * time.Millisecond) // past one 50 fps interval
+			second, _ := src.NextFrame()
+			if static := kind == "bars"; bytes.Equal(first, second) != static {
+				t.Fatalf("frames changed = %v, want %v", !bytes.Equal(first, second), !static)
+			}
+		})
+	}
+}
+
+func TestPatternRejectsBadParameters(t *testing.T) {
+	for _, path := range []string{"pattern:nope", "pattern:bars?w=1", "pattern:clock?fps=x"} {
+		if _, err := media.Open(path, 30); err == nil {
+			t.Errorf("%s: expected error, got nil", path)
+		}
+	}
+}
+
+func TestFaultSourceIsReproducible(t *testing.T) {
+	open := func() media.Source {
+		src, err := media.Open("pattern:bars?w=64&h=64", 30)
+		if err != nil {
+			t.Fatalf("Open: %v", err)
+		}
+		return media.WithFaults(src, media.FaultConfig{
+			Seed:     7,
+			Drop:     media.FaultSpec{Chance: 0.3},
+			Truncate: media.FaultSpec{Chance: 0.3},
+			Reset:    media.FaultSpec{Chance: 0.1},
+		})
+	}
+	a, b := open(), open()
+	defer a.Close()
+	defer b.Close()
+
+	// outcome classifies a frame so runs can be compared.
+	outcome := func(frame []byte, err error) string {
+		if err != nil {
+			return err.Error()
+		}
+		return fmt.Sprint(len(frame))
+	}
+	seen := make(map[string]bool)
+	for i := 0; i < 100; i++ {
+		fa, errA := a.NextFrame()
+		fb, errB := b.NextFrame()
+		oa, ob := outcome(fa, errA), outcome(fb, errB)
+		if oa != ob {
+			t.Fatalf("frame %d: runs with the same seed differ: %s vs %s", i, oa, ob)
+		}
+		seen[oa] = true
+	}
+	if len(seen) != 4 {
+		t.Fatalf("expected whole, truncated, dropped and reset frames, got %v", seen)
+	}
+
+	if _, ok := media.As[media.Static](a); !ok {
+		t.Fatal("expected As to find the wrapped source's Static")
+	}
+}
+
+func TestPatternCounterKeepsPaceWithCaller(t *testing.T) {
+	src, err := media.Open("pattern:counter?w=64&h=64&fps=50", 50)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer src.Close()
+
+	// Poll at the pattern's own rate, as a hub does: every tick should
+	// get a new frame despite timer jitter.
+	ticker := time.NewTicker(20 * time.Millisecond)
+	defer ticker.Stop()
+	var prev []byte
+	repeats := 0
+	for i := 0; i < 60; i++ {
+		frame, err := src.NextFrame()
+		if err != nil {
+			t.Fatalf("NextFrame: %v", err)
+		}
+		if prev != nil && bytes.Equal(frame, prev) {
+			repeats++
+		}
+		prev = frame
+		<-ticker.C
+	}
+	// A heavily loaded machine may delay a tick past the next one; allow
+	// for that, but not the steady repeats of a drifting schedule.
+	if repeats > 2 {
+		t.Errorf("%d of 60 ticks repeated the previous frame", repeats)
+	}
+}
+
+func TestFaultSourceOutageAndFreeze(t *testing.T) {
+	src, err := media.Open("pattern:counter?w=64&h=64&fps=100", 30)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	fs := media.WithFaults(src, media.FaultConfig{
+		Freeze: media.FaultSpec{Every: 50 * time.Millisecond, For: 100 * time.Millisecond},
+		Outage: media.FaultSpec{Every: 400 * time.Millisecond, For: 100 * time.Millisecond},
+	})
+	defer fs.Close()
+
+	time.Sleep(60 * time.Millisecond)
+	frozen, err := fs.NextFrame()
+	if err != nil {
+		t.Fatalf("NextFrame: %v", err)
+	}
+	time.Sleep(30 * time.Millisecond)
+	if again, _ := fs.NextFrame(); !bytes.Equal(again, frozen) {
+		t.Fatal("expected the frame to stay frozen")
+	}
+
+	time.Sleep(350 * time.Millisecond)
+	if _, err := fs.NextFrame(); err != media.ErrOutage {
+		t.Fatalf("expected ErrOutage, got %v", err)
+	}
+	time.Sleep(120 * time.Millisecond)
+	if _, err := fs.NextFrame(); err != nil {
+		t.Fatalf("expected frames after the outage, got %v", err)
+	}
+}
+
+func TestMJPEGSourceRelaysAndReconnects(t *testing.T) {
+	dir := t.TempDir()
+	red, _ := os.ReadFile(writeColorJPEG(t, dir, "red.jpg", color.RGBA{R: 255, A: 255}))
+	blue, _ := os.ReadFile(writeColorJPEG(t, dir, "blue.jpg", color.RGBA{B: 255, A: 255}))
+
+	// Each connection sends a few frames and then drops; the first sends
+	// red and later ones blue, so a reconnect is visible in the frames.
+	var conns atomic.Int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		frame := red
+		if conns.Add(1) > 1 {
+			frame = blue
+		}
+		mw := multipart.NewWriter(w)
+		w.Header().Set("Content-Type", "multipart/x-mixed-replace; boundary="+mw.Boundary())
+		for i := 0; i < 3; i++ {
+			pw, _ := mw.CreatePart(textproto.MIMEHeader{"Content-Type": {"image/jpeg"}})
+			pw.Write(frame)
+			w.(http.Flusher).Flush()
+			time.Sleep(20 * time.Millisecond)
+		}
+	}))
+	defer upstream.Close()
+
+	src, err := media.Open(upstream.URL+"/stream", 30)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer src.Close()
+
+	waitFor := func(want []byte, name string) {
+		t.Helper()
+		deadline := time.Now().Add(3 * time.Second)
+		for time.Now().Before(deadline) {
+			if frame, err := src.NextFrame(); err == nil && bytes.Equal(frame, want) {
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		t.Fatalf("never received the %s frame", name)
+	}
+	waitFor(red, "first")
+	waitFor(blue, "reconnected")
+
+	m, ok := src.(media.Monitor)
+	if !ok {
+		t.Fatal("expected the MJPEG source to report its health")
+	}
+	if h := m.Health(); h.Restarts < 1 || h.LastError == "" {
+		t.Fatalf("expected a recorded reconnect, got %+v", h)
+	}
+}
+
+func TestMJPEGSourceRejectsNonMultipart(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte("<html></html>"))
+	}))
+	defer upstream.Close()
+
+	if _, err := media.Open(upstream.URL, 30); err == nil {
+		t.Fatal("expected an error for a URL that isn't an MJPEG stream")
+	}
+}
+
+func TestLiveSourceReconnects(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("fake ffmpeg is a shell script")
+	}
+	jpegPath := writeMinimalJPEG(t)
+	want, _ := os.ReadFile(jpegPath)
+
+	// The fake FFmpeg logs its arguments, sends one frame and drops.
+	dir := t.TempDir()
+	argsLog := filepath.Join(dir, "args")
+	script := "#!/bin/sh\necho \"$@\" >> '" + argsLog + "'\ncat '" + jpegPath + "'\n" +
+		"echo 'connection refused' >&2\nexit 1\n"
+	if err := os.WriteFile(filepath.Join(dir, "ffmpeg"), []byte(script), 0o755); err != nil {
+		t.Fatalf("writing fake ffmpeg: %v", err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	for _, tc := range []struct{ url, arg string }{
+		{"rtsp://camera.local/stream1", "-rtsp_transport tcp"},
+		{"https://cdn.example.com/live/index.m3u8", "-reconnect 1"},
+	} {
+		os.Remove(argsLog)
+		src, err := media.Open(tc.url, 30)
+		if err != nil {
+			t.Fatalf("Open(%s): %v", tc.url, err)
+		}
+		if _, ok := media.As[media.Seeker](src); ok {
+			t.Fatalf("%s: a live stream should not be seekable", tc.url)
+		}
+
+		deadline := time.Now().Add(3 * time.Second)
+		var h media.Health
+		for time.Now().Before(deadline) {
+			h = src.(media.Monitor).Health()
+			if h.Restarts >= 1 {
+				break
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+		frame, err := src.NextFrame()
+		src.Close()
+
+		if err != nil || !bytes.Equal(frame, want) {
+			t.Fatalf("%s: expected the relayed frame, got err %v", tc.url, err)
+		}
+		if h.Restarts < 1 || !strings.Contains(h.LastError, "connection refused") {
+			t.Fatalf("%s: expected a reconnect after the drop, got %+v", tc.url, h)
+		}
+		args, _ := os.ReadFile(argsLog)
+		if !strings.Contains(string(args), tc.arg) || strings.Contains(string(args), "-re ") ||
+			strings.Contains(string(args), "-stream_loop") {
+			t.Fatalf("%s: unexpected ffmpeg arguments %q", tc.url, args)
+		}
+	}
+}
+
+func TestPipeSourceReadsStdin(t *testing.T) {
+	dir := t.TempDir()
+	red, _ := os.ReadFile(writeColorJPEG(t, dir, "red.jpg", color.RGBA{R: 255, A: 255}))
+	blue, _ := os.ReadFile(writeColorJPEG(t, dir, "blue.jpg", color.RGBA{B: 255, A: 255}))
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdin := os.Stdin
+	os.Stdin = r
+	defer func() { os.Stdin = stdin }()
+
+	src, err := media.Open(media.StdinPath, 30)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer src.Close()
+
+	// Concatenated JPEGs, like `cat *.jpg | mediastream --file -`.
+	w.Write(append(append([]byte{}, red...), blue...))
+	w.Close()
+
+	var got [][]byte
+	deadline := time.Now().Add(2 * time.Second)
+	for len(got) < 2 && time.Now().Before(deadline) {
+		frame, err := src.NextFrame()
+		if err == nil && (len(got) == 0 || !bytes.Equal(frame, got[len(got)-1])) {
+			got = append(got, frame)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if len(got) != 2 || !bytes.Equal(got[0], red) || !bytes.Equal(got[1], blue) {
+		t.Fatalf("expected the red then the blue frame, got %d frames", len(got))
+	}
+
+	// The input has ended: the last frame is held.
+	time.Sleep(20 * time.Millisecond)
+	if frame, err := src.NextFrame(); err != nil || !bytes.Equal(frame, blue) {
+		t.Fatalf("expected the last frame to be held after EOF, got err %v", err)
+	}
+}
+
+func TestOpenDetectsFormatFromContent(t *testing.T) {
+	dir := t.TempDir()
+	jpg, _ := os.ReadFile(writeMinimalJPEG(t))
+	gifData, _ := os.ReadFile(writeTwoFrameGIF(t))
+
+	var tif bytes.Buffer
+	if err := tiff.Encode(&tif, image.NewRGBA(image.Rect(0, 0, 4, 4)), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, data := range map[string][]byte{
+		"snapshot":       jpg,
+		"photo.jfif":     jpg,
+		"upload.JPG.tmp": jpg,
+		"scan.tiff":      tif.Bytes(),
+		"scan":           tif.Bytes(),
+		"animation.png":  gifData, // mislabeled: must play as a GIF
+	} {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		src, err := media.Open(path, 30)
+		if err != nil {
+			t.Fatalf("Open(%s): %v", name, err)
+		}
+		if _, err := src.NextFrame(); err != nil {
+			t.Fatalf("%s: NextFrame: %v", name, err)
+		}
+		_, seekable := src.(media.Seeker)
+		if seekable != (name == "animation.png") {
+			t.Fatalf("%s: opened as the wrong kind of source", name)
+		}
+		src.Close()
+	}
+
+	// Content that is recognizably something else is rejected whatever
+	// its extension, with the detected type in the error.
+	pdf := filepath.Join(dir, "report.jpg")
+	os.WriteFile(pdf, []byte("%PDF-1.7\n%\xe2\xe3\xcf\xd3\n"), 0o644)
+	_, err := media.Open(pdf, 30)
+	var unsupported *media.UnsupportedFormatError
+	if !errors.As(err, &unsupported) || unsupported.MIMEType != "application/pdf" {
+		t.Fatalf("expected an UnsupportedFormatError naming application/pdf, got %v", err)
+	}
+}
@@ -0,0 +1,163 @@
+package media
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// urlScheme matches a path that is a URL rather than a file name.
+var urlScheme = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9+.-]*://`)
+
+// isURL reports whether path is a URL such as http://camera/video.
+func isURL(path string) bool {
+	return urlScheme.MatchString(path)
+}
+
+// isHTTPURL reports whether path is an http or https URL.
+func isHTTPURL(path string) bool {
+	lower := strings.ToLower(path)
+	return strings.HasPrefix(lower, "http://") || strings.HasPrefix(lower, "https://")
+}
+
+// mjpegSource relays an upstream MJPEG-over-HTTP stream, such as an IP
+// camera or another mediastream, so that a camera that allows only a
+// connection or two can serve any number of viewers. Dropped connections
+// are retried with backoff while the last frame keeps being served.
+type mjpegSource struct {
+	relayState
+	client *http.Client
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// newMJPEGSource connects to url and starts relaying it. The first
+// connection is made before returning so a wrong URL fails right away.
+func newMJPEGSource(url string) (*mjpegSource, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.ResponseHeaderTimeout = liveStallTimeout
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &mjpegSource{
+		relayState: relayState{input: url},
+		client:     &http.Client{Transport: transport},
+		ctx:        ctx,
+		cancel:     cancel,
+		done:       make(chan struct{}),
+	}
+	body, parts, err := s.connect()
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	go func() {
+		defer close(s.done)
+		s.reconnect(ctx.Done(), func() (bool, error) {
+			if body == nil {
+				var err error
+				if body, parts, err = s.connect(); err != nil {
+					return false, err
+				}
+			}
+			defer func() { body.Close(); body = nil }()
+			return s.relay(body, parts)
+		})
+	}()
+	return s, nil
+}
+
+// connect requests the stream and checks that it is multipart.
+func (s *mjpegSource) connect() (io.ReadCloser, *multipart.Reader, error) {
+	req, err := http.NewRequestWithContext(s.ctx, http.MethodGet, s.input, nil)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid MJPEG URL %q: %w", s.input, err)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, nil, fmt.Errorf("connecting to %s: %w", s.input, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, nil, fmt.Errorf("connecting to %s: %s", s.input, resp.Status)
+	}
+
+	ct := resp.Header.Get("Content-Type")
+	mediaType, params, err := mime.ParseMediaType(ct)
+	if err != nil || !strings.HasPrefix(mediaType, "multipart/") || params["boundary"] == "" {
+		resp.Body.Close()
+		return nil, nil, fmt.Errorf("%s is not an MJPEG stream (Content-Type %q)", s.input, ct)
+	}
+	return resp.Body, multipart.NewReader(resp.Body, params["boundary"]), nil
+}
+
+// relay reads frames from one connection until it ends, fails or stalls.
+// It reports whether any frame got through.
+func (s *mjpegSource) relay(body io.Closer, parts *multipart.Reader) (bool, error) {
+	var stalled atomic.Bool
+	watchdog := time.AfterFunc(liveStallTimeout, func() {
+		stalled.Store(true)
+		body.Close() // unblocks the read below
+	})
+	defer watchdog.Stop()
+
+	relayed := false
+	for {
+		frame, err := readPart(parts)
+		if stalled.Load() {
+			return relayed, fmt.Errorf("no frame from %s in %v", s.input, liveStallTimeout)
+		}
+		if err == io.EOF {
+			return relayed, fmt.Errorf("%s ended the stream", s.input)
+		}
+		if err != nil {
+			return relayed, fmt.Errorf("reading %s: %w", s.input, err)
+		}
+		if frame == nil {
+			continue // not a JPEG part
+		}
+
+		watchdog.Reset(liveStallTimeout)
+		s.store(frame)
+		relayed = true
+	}
+}
+
+// readPart returns the JPEG in the next part of the stream, or nil if the
+// part doesn't hold a complete one.
+func readPart(parts *multipart.Reader) ([]byte, error) {
+	part, err := parts.NextPart()
+	if err != nil {
+		return nil, err
+	}
+	data, err := io.ReadAll(io.LimitReader(part, maxJPEGFrame))
+	if err != nil {
+		return nil, err
+	}
+
+	// Some cameras pad the body with blank lines before the image.
+	if i := bytes.Index(data, jpegSOI); i > 0 {
+		data = data[i:]
+	}
+	n, err := scanJPEG(data)
+	if err != nil || n == 0 {
+		return nil, nil
+	}
+	return data[:n], nil
+}
+
+// Close disconnects from the upstream.
+func (s *mjpegSource) Close() error {
+	s.cancel()
+	<-s.done
+	return nil
+}
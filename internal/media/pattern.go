@@ -0,0 +1,292 @@
+package media
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"math"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/gomonobold"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+
+	"github.com/idevakk/mediastream/internal/metrics"
+)
+
+// PatternScheme prefixes the pseudo-paths Open accepts for generated test
+// patterns, such as "pattern:bars?w=1280&h=720".
+const PatternScheme = "pattern:"
+
+// PatternKinds lists the test patterns a pattern: path can name.
+var PatternKinds = []string{"bars", "gradient", "counter", "clock"}
+
+// Limits for the w and h parameters of a pattern: path.
+const (
+	minPatternSize = 16
+	maxPatternSize = 7680
+)
+
+// patternSource generates test frames instead of reading a file:
+//
+//	bars      SMPTE color bars (static)
+//	gradient  a hue gradient scrolling sideways
+//	counter   the number of frames generated so far
+//	clock     the wall-clock time, with milliseconds, at generation
+//
+// counter and clock also burn in each other's value, so a consumer can
+// measure end-to-end latency and spot dropped frames.
+type patternSource struct {
+	mu       sync.Mutex
+	kind     string
+	interval time.Duration // between generated frames
+	canvas   *image.RGBA
+	large    font.Face
+	small    font.Face
+	start    time.Time
+	frames   uint64    // frames generated so far
+	next     time.Time // when the next frame is due
+	last     []byte
+}
+
+// newPatternSource parses a path like "pattern:clock?w=640&h=360&fps=10".
+// w and h default to 1280x720 and fps to frameRate.
+func newPatternSource(path string, frameRate int) (*patternSource, error) {
+	kind, query, _ := strings.Cut(strings.TrimPrefix(path, PatternScheme), "?")
+	params, err := url.ParseQuery(query)
+	if err != nil {
+		return nil, fmt.Errorf("invalid pattern parameters in %q: %w", path, err)
+	}
+
+	known := false
+	for _, k := range PatternKinds {
+		known = known || k == kind
+	}
+	if !known {
+		return nil, fmt.Errorf("unknown pattern %q — available patterns: %s", kind, strings.Join(PatternKinds, ", "))
+	}
+
+	intParam := func(name string, def, lo, hi int) (int, error) {
+		v := params.Get(name)
+		if v == "" {
+			return def, nil
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil || n < lo || n > hi {
+			return 0, fmt.Errorf("invalid pattern parameter %s=%q: must be %d to %d", name, v, lo, hi)
+		}
+		return n, nil
+	}
+	w, err := intParam("w", 1280, minPatternSize, maxPatternSize)
+	if err != nil {
+		return nil, err
+	}
+	h, err := intParam("h", 720, minPatternSize, maxPatternSize)
+	if err != nil {
+		return nil, err
+	}
+	fps, err := intParam("fps", frameRate, 1, 240)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &patternSource{
+		kind:     kind,
+		interval: time.Second / time.Duration(fps),
+		canvas:   image.NewRGBA(image.Rect(0, 0, w, h)),
+		start:    time.Now(),
+	}
+	if kind == "counter" || kind == "clock" {
+		if s.large, err = monoFace(float64(h) / 6); err != nil {
+			return nil, err
+		}
+		if s.small, err = monoFace(float64(h) / 18); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// monoFace returns the bold Go Mono font at the given pixel size.
+func monoFace(size float64) (font.Face, error) {
+	f, err := opentype.Parse(gomonobold.TTF)
+	if err != nil {
+		return nil, fmt.Errorf("loading pattern font: %w", err)
+	}
+	face, err := opentype.NewFace(f, &opentype.FaceOptions{Size: size, DPI: 72, Hinting: font.HintingFull})
+	if err != nil {
+		return nil, fmt.Errorf("loading pattern font: %w", err)
+	}
+	return face, nil
+}
+
+// NextFrame generates a new frame once per interval, and otherwise
+// repeats the last one, so the pattern's fps holds at any hub rate. Frames
+// fall due on a fixed schedule, and a call up to half an interval early
+// still gets a new frame, so timer jitter in a caller running at the
+// pattern's own rate doesn't repeat frames that would read as drops.
+func (s *patternSource) NextFrame() ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	if s.last != nil && (s.kind == "bars" || now.Before(s.next.Add(-s.interval/2))) {
+		return s.last, nil
+	}
+
+	s.frames++
+	switch s.kind {
+	case "bars":
+		drawBars(s.canvas)
+	case "gradient":
+		drawGradient(s.canvas, now.Sub(s.start))
+	case "counter":
+		draw.Draw(s.canvas, s.canvas.Bounds(), image.Black, image.Point{}, draw.Src)
+		s.drawText(s.large, fmt.Sprintf("%08d", s.frames), 0.5)
+		s.drawText(s.small, now.Format("2006-01-02 15:04:05.000"), 0.8)
+	case "clock":
+		draw.Draw(s.canvas, s.canvas.Bounds(), image.Black, image.Point{}, draw.Src)
+		s.drawText(s.large, now.Format("15:04:05.000"), 0.5)
+		s.drawText(s.small, fmt.Sprintf("%s  frame %d", now.Format("2006-01-02"), s.frames), 0.8)
+	}
+
+	var buf bytes.Buffer
+	start := time.Now()
+	if err := jpeg.Encode(&buf, s.canvas, &jpeg.Options{Quality: 90}); err != nil {
+		return nil, fmt.Errorf("encoding %s pattern: %w", s.kind, err)
+	}
+	metrics.EncodeSeconds.With("pattern").ObserveSince(start)
+	s.last = buf.Bytes()
+	s.next = s.next.Add(s.interval)
+	if s.next.Before(now) {
+		// The first frame, or the caller fell behind; don't burst to catch up.
+		s.next = now.Add(s.interval)
+	}
+	return s.last, nil
+}
+
+// drawText draws text in white, centred horizontally with its baseline at
+// the given fraction of the frame height.
+func (s *patternSource) drawText(face font.Face, text string, y float64) {
+	b := s.canvas.Bounds()
+	d := font.Drawer{Dst: s.canvas, Src: image.White, Face: face}
+	width := d.MeasureString(text)
+	d.Dot = fixed.Point26_6{
+		X: (fixed.I(b.Dx()) - width) / 2,
+		Y: fixed.I(int(float64(b.Dy()) * y)),
+	}
+	d.DrawString(text)
+}
+
+// IsStatic reports true for color bars, which never change.
+func (s *patternSource) IsStatic() bool { return s.kind == "bars" }
+
+// Close releases the pattern's fonts.
+func (s *patternSource) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, f := range []font.Face{s.large, s.small} {
+		if f != nil {
+			f.Close()
+		}
+	}
+	return nil
+}
+
+// SMPTE color bar colors at 75% intensity (RP 219 approximations).
+var (
+	barsTop = []color.RGBA{
+		{191, 191, 191, 255}, {191, 191, 0, 255}, {0, 191, 191, 255}, {0, 191, 0, 255},
+		{191, 0, 191, 255}, {191, 0, 0, 255}, {0, 0, 191, 255},
+	}
+	barsMiddle = []color.RGBA{
+		{0, 0, 191, 255}, {19, 19, 19, 255}, {191, 0, 191, 255}, {19, 19, 19, 255},
+		{0, 191, 191, 255}, {19, 19, 19, 255}, {191, 191, 191, 255},
+	}
+	// -I, white, +Q, black, then the PLUGE steps below, at and above black.
+	barsBottom = []color.RGBA{
+		{0, 33, 76, 255}, {255, 255, 255, 255}, {50, 0, 106, 255}, {19, 19, 19, 255},
+		{9, 9, 9, 255}, {19, 19, 19, 255}, {29, 29, 29, 255}, {19, 19, 19, 255},
+	}
+	// Widths of the bottom row in 84ths of the frame width: the first four
+	// each span one and a quarter of the bars above.
+	barsBottomWidths = []int{15, 15, 15, 15, 4, 4, 4, 12}
+)
+
+// drawBars paints SMPTE color bars: seven bars on the top two thirds, a
+// strip of reverse bars, then the -I / white / +Q / PLUGE row.
+func drawBars(img *image.RGBA) {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	top, mid := h*2/3, h*3/4
+
+	fill := func(x0, y0, x1, y1 int, c color.RGBA) {
+		draw.Draw(img, image.Rect(x0, y0, x1, y1), image.NewUniform(c), image.Point{}, draw.Src)
+	}
+	for i := range barsTop {
+		x0, x1 := w*i/7, w*(i+1)/7
+		fill(x0, 0, x1, top, barsTop[i])
+		fill(x0, top, x1, mid, barsMiddle[i])
+	}
+	x := 0
+	for i, c := range barsBottom {
+		sum := 0
+		for _, bw := range barsBottomWidths[:i+1] {
+			sum += bw
+		}
+		next := w * sum / 84
+		fill(x, mid, next, h, c)
+		x = next
+	}
+}
+
+// drawGradient paints a full-saturation hue sweep that scrolls across the
+// frame once every ten seconds, darkening towards the bottom.
+func drawGradient(img *image.RGBA, elapsed time.Duration) {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	shift := math.Mod(elapsed.Seconds()/10, 1)
+
+	row := make([]color.RGBA, w)
+	for x := range row {
+		row[x] = hueColor(math.Mod(float64(x)/float64(w)+shift, 1))
+	}
+	for y := 0; y < h; y++ {
+		scale := 1 - 0.75*float64(y)/float64(h)
+		line := img.Pix[y*img.Stride:]
+		for x, c := range row {
+			line[x*4+0] = uint8(float64(c.R) * scale)
+			line[x*4+1] = uint8(float64(c.G) * scale)
+			line[x*4+2] = uint8(float64(c.B) * scale)
+			line[x*4+3] = 255
+		}
+	}
+}
+
+// hueColor returns the fully saturated color at hue h in [0, 1).
+func hueColor(h float64) color.RGBA {
+	f := h * 6
+	x := uint8(255 * (1 - math.Abs(math.Mod(f, 2)-1)))
+	switch int(f) {
+	case 0:
+		return color.RGBA{255, x, 0, 255}
+	case 1:
+		return color.RGBA{x, 255, 0, 255}
+	case 2:
+		return color.RGBA{0, 255, x, 255}
+	case 3:
+		return color.RGBA{0, x, 255, 255}
+	case 4:
+		return color.RGBA{x, 0, 255, 255}
+	default:
+		return color.RGBA{255, 0, x, 255}
+	}
+}
@@ -0,0 +1,145 @@
+package media
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// StdinPath is the path that makes Open read frames from standard input.
+const StdinPath = "-"
+
+// isPipe reports whether path is standard input or a named pipe (FIFO).
+func isPipe(path string) bool {
+	if path == StdinPath {
+		return true
+	}
+	info, err := os.Stat(path)
+	return err == nil && info.Mode()&os.ModeNamedPipe != 0
+}
+
+// pipeSource reads back-to-back JPEGs, such as concatenated files or
+// FFmpeg's image2pipe output, from standard input or a named pipe, so other
+// programs can generate frames: python render.py | mediastream --file -.
+//
+// Frames are handed over one at a time, so a producer that writes faster
+// than the stream's frame rate blocks instead of having its frames thrown
+// away, while a slower one just has its last frame repeated. At end of
+// input the last frame is held.
+type pipeSource struct {
+	path   string
+	frames chan []byte
+	quit   chan struct{}
+
+	mu     sync.Mutex
+	r      io.Closer // nil until a FIFO has been opened
+	closed bool
+	last   []byte
+	err    error // why reading stopped; io.EOF at end of input
+}
+
+// newPipeSource starts reading path, or standard input for StdinPath.
+// Opening a FIFO waits for a writer, so it happens in the background.
+func newPipeSource(path string) *pipeSource {
+	s := &pipeSource{
+		path:   path,
+		frames: make(chan []byte),
+		quit:   make(chan struct{}),
+	}
+	go s.read()
+	return s
+}
+
+// read feeds frames to NextFrame until the input ends or Close.
+func (s *pipeSource) read() {
+	var r io.ReadCloser = os.Stdin
+	if s.path != StdinPath {
+		f, err := os.Open(s.path) // blocks until a writer opens the FIFO
+		if err != nil {
+			s.stop(fmt.Errorf("opening pipe %q: %w", s.path, err))
+			return
+		}
+		r = f
+	}
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		r.Close()
+		return
+	}
+	s.r = r
+	s.mu.Unlock()
+
+	var buf bytes.Buffer
+	for {
+		frame, err := readJPEG(r, &buf)
+		if err != nil {
+			s.stop(err)
+			return
+		}
+		select {
+		case s.frames <- frame:
+		case <-s.quit:
+			return
+		}
+	}
+}
+
+// stop records why reading ended.
+func (s *pipeSource) stop(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.closed {
+		s.err = err
+	}
+}
+
+// NextFrame returns the frame the producer has written since the last
+// call, or repeats the previous one if there is none yet.
+func (s *pipeSource) NextFrame() ([]byte, error) {
+	select {
+	case frame := <-s.frames:
+		s.mu.Lock()
+		s.last = frame
+		s.mu.Unlock()
+		return frame, nil
+	default:
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch {
+	case s.last != nil:
+		return s.last, nil
+	case s.err != nil:
+		return nil, s.err
+	default:
+		return nil, errors.New("waiting for the first frame from " + s.name())
+	}
+}
+
+func (s *pipeSource) name() string {
+	if s.path == StdinPath {
+		return "standard input"
+	}
+	return s.path
+}
+
+// Close stops reading and closes the pipe. A FIFO that no writer has
+// opened yet is left to be closed once one does.
+func (s *pipeSource) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	close(s.quit)
+	if s.r != nil {
+		return s.r.Close()
+	}
+	return nil
+}
@@ -0,0 +1,298 @@
+package media
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// readPlaylist reads an .m3u or plain-text playlist: one path per line,
+// with blank lines and lines starting with '#' ignored. Relative paths are
+// resolved against the playlist's directory; pattern: paths and URLs are
+// kept as is.
+func readPlaylist(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("opening playlist %q: %w", path, err)
+	}
+	defer f.Close()
+
+	dir := filepath.Dir(path)
+	var paths []string
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		line := strings.TrimSpace(strings.TrimPrefix(sc.Text(), "\ufeff"))
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if !filepath.IsAbs(line) && !strings.HasPrefix(line, PatternScheme) && !isURL(line) {
+			line = filepath.Join(dir, line)
+		}
+		paths = append(paths, line)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("reading playlist %q: %w", path, err)
+	}
+	return paths, nil
+}
+
+// OpenPlaylist returns a Source that plays paths one after another, as if
+// they were listed in a playlist file.
+func OpenPlaylist(paths []string, frameRate int, opts ...Option) (Source, error) {
+	o, err := collectOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+	return newPlaylistSource(paths, frameRate, o)
+}
+
+// playlistSource plays a list of files in turn: still images for the
+// image duration, GIFs for a number of loops and videos to their end.
+// Only the current item is open at any time.
+type playlistSource struct {
+	mu        sync.Mutex
+	paths     []string
+	frameRate int
+	o         options // settings for each item
+
+	order   []int // play order; a permutation of paths when shuffling
+	pos     int   // index into order of the current item
+	cur     Source
+	limit   time.Duration // how long cur plays; zero plays it to io.EOF
+	startAt time.Time     // when cur started, shifted forward by pauses
+	paused  time.Time     // zero unless paused
+	last    []byte        // last frame returned
+	done    bool          // the list finished and repeat is off
+}
+
+// errEmptyPlaylist is returned by NextFrame while a live playlist, such
+// as a watched directory, has no items.
+var errEmptyPlaylist = errors.New("playlist is empty")
+
+// newPlaylistSource checks that paths is usable and opens the first item.
+func newPlaylistSource(paths []string, frameRate int, o options) (*playlistSource, error) {
+	if len(paths) == 0 {
+		return nil, errEmptyPlaylist
+	}
+	s := newLivePlaylist(frameRate, o)
+	if err := s.setPaths(paths, nil); err != nil {
+		return nil, err
+	}
+
+	// Fail early if nothing in the list can be played.
+	if _, err := s.NextFrame(); err != nil {
+		s.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// newLivePlaylist returns an empty playlist whose items are supplied, and
+// later replaced, with setPaths.
+func newLivePlaylist(frameRate int, o options) *playlistSource {
+	s := &playlistSource{frameRate: frameRate, o: o}
+	s.o.start, s.o.end = 0, 0 // clip bounds don't carry over to items
+	s.o.once = true
+	return s
+}
+
+// setPaths replaces the playlist's items. The current item keeps playing
+// if it is still listed and not in changed; otherwise playback continues
+// with whatever is now at its position.
+func (s *playlistSource) setPaths(paths []string, changed map[string]bool) error {
+	for _, p := range paths {
+		switch strings.ToLower(filepath.Ext(p)) {
+		case ".m3u", ".txt":
+			return fmt.Errorf("nested playlist %q is not supported", p)
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var current string
+	if s.cur != nil {
+		current = s.paths[s.order[s.pos]]
+	}
+
+	s.paths = paths
+	s.order = make([]int, len(paths))
+	for i := range s.order {
+		s.order[i] = i
+	}
+	if s.o.shuffle {
+		s.shuffle()
+	}
+
+	if s.cur != nil && !changed[current] {
+		for i, idx := range s.order {
+			if paths[idx] == current {
+				s.pos = i
+				return nil
+			}
+		}
+	}
+	if s.cur != nil {
+		s.cur.Close()
+		s.cur = nil
+	}
+	if s.pos >= len(s.order) {
+		s.pos = 0
+	}
+	s.done = false // new items play even after a once-only list ended
+	return nil
+}
+
+func (s *playlistSource) shuffle() {
+	rand.Shuffle(len(s.order), func(i, j int) {
+		s.order[i], s.order[j] = s.order[j], s.order[i]
+	})
+}
+
+// openCurrent opens the item at s.pos and works out how long it plays.
+func (s *playlistSource) openCurrent() error {
+	path := s.paths[s.order[s.pos]]
+	src, err := open(path, s.frameRate, s.o)
+	if err != nil {
+		return err
+	}
+
+	s.cur, s.limit = src, 0
+	switch src := src.(type) {
+	case *gifSource:
+		s.limit = time.Duration(s.o.gifLoops) * src.duration()
+	case Static:
+		if src.IsStatic() {
+			s.limit = s.o.imageDuration
+		}
+	}
+	s.startAt = time.Now()
+	if !s.paused.IsZero() {
+		s.paused = s.startAt
+		if p, ok := src.(Pauser); ok {
+			p.Pause()
+		}
+	}
+	return nil
+}
+
+// advance closes the current item and moves to the next one, reshuffling
+// or stopping at the end of the list.
+func (s *playlistSource) advance() {
+	if s.cur != nil {
+		s.cur.Close()
+		s.cur = nil
+	}
+	s.pos++
+	if s.pos < len(s.order) {
+		return
+	}
+	if !s.o.repeat {
+		s.pos = len(s.order) - 1
+		s.done = true
+		return
+	}
+	s.pos = 0
+	if s.o.shuffle {
+		s.shuffle()
+	}
+}
+
+// elapsed returns how long the current item has been playing.
+func (s *playlistSource) elapsed() time.Duration {
+	if !s.paused.IsZero() {
+		return s.paused.Sub(s.startAt)
+	}
+	return time.Since(s.startAt)
+}
+
+// NextFrame returns a frame from the current item, moving on when the
+// item's time is up or it reports io.EOF. Items that fail to open or play
+// are skipped; an error is returned only if none of them work.
+func (s *playlistSource) NextFrame() ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.paths) == 0 {
+		return nil, errEmptyPlaylist
+	}
+	if s.cur != nil && s.limit > 0 && s.elapsed() >= s.limit {
+		s.advance()
+	}
+
+	var lastErr error
+	for tries := 0; tries <= len(s.paths); {
+		if s.done {
+			return s.last, nil
+		}
+		if s.cur == nil {
+			tries++
+			if err := s.openCurrent(); err != nil {
+				lastErr = err
+				s.advance()
+				continue
+			}
+		}
+
+		frame, err := s.cur.NextFrame()
+		if err == nil {
+			s.last = frame
+			return frame, nil
+		}
+		if !errors.Is(err, io.EOF) {
+			lastErr = err
+		}
+		s.advance()
+	}
+	if s.last != nil {
+		return s.last, nil
+	}
+	return nil, fmt.Errorf("no playable items in playlist: %w", lastErr)
+}
+
+// Pause freezes the current item and its timer.
+func (s *playlistSource) Pause() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.paused.IsZero() {
+		return
+	}
+	s.paused = time.Now()
+	if p, ok := s.cur.(Pauser); ok {
+		p.Pause()
+	}
+}
+
+// Resume continues the current item where it stopped.
+func (s *playlistSource) Resume() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.paused.IsZero() {
+		return
+	}
+	s.startAt = s.startAt.Add(time.Since(s.paused))
+	s.paused = time.Time{}
+	if p, ok := s.cur.(Pauser); ok {
+		p.Resume()
+	}
+}
+
+// Close closes the current item.
+func (s *playlistSource) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.done = true
+	if s.cur == nil {
+		return nil
+	}
+	err := s.cur.Close()
+	s.cur = nil
+	return err
+}
@@ -0,0 +1,96 @@
+package media
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// liveStallTimeout is how long a live input may go without producing a
+// frame, or an upstream without answering, before it is reconnected.
+const liveStallTimeout = 10 * time.Second
+
+// relayState is shared by sources that read a live input in the
+// background, such as an MJPEG camera or an RTSP stream. The input is
+// drained continuously so it never backs up, and NextFrame returns the
+// newest frame at whatever rate the consumer asks for. While the input is
+// down the last frame is repeated.
+type relayState struct {
+	input string // named in errors
+
+	mu       sync.Mutex
+	last     []byte
+	running  bool
+	restarts int
+	lastErr  error
+}
+
+// store records frame as the newest one.
+func (r *relayState) store(frame []byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.last = frame
+	r.running = true
+}
+
+// fail records err and marks the input as down.
+func (r *relayState) fail(err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.running = false
+	r.lastErr = err
+}
+
+// reconnect runs session until quit is closed, waiting with exponential
+// backoff between sessions. A session reports whether it relayed any
+// frame, which resets the backoff, and why it ended.
+func (r *relayState) reconnect(quit <-chan struct{}, session func() (bool, error)) {
+	delay := minRestartDelay
+	for {
+		relayed, err := session()
+		select {
+		case <-quit:
+			return
+		default:
+		}
+		if relayed {
+			delay = minRestartDelay
+		}
+		r.fail(err)
+
+		select {
+		case <-quit:
+			return
+		case <-time.After(delay):
+		}
+		delay = min(delay*2, maxRestartDelay)
+
+		r.mu.Lock()
+		r.restarts++
+		r.mu.Unlock()
+	}
+}
+
+// NextFrame returns the newest frame from the input.
+func (r *relayState) NextFrame() ([]byte, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.last != nil {
+		return r.last, nil
+	}
+	if r.lastErr != nil {
+		return nil, r.lastErr
+	}
+	return nil, errors.New("waiting for the first frame from " + r.input)
+}
+
+// Health reports whether the input is delivering frames.
+func (r *relayState) Health() Health {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	h := Health{Running: r.running, Restarts: r.restarts}
+	if r.lastErr != nil {
+		h.LastError = r.lastErr.Error()
+	}
+	return h
+}
@@ -0,0 +1,331 @@
+package media
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultCacheBudget is how many bytes of encoded frames an image
+// sequence keeps in memory unless WithCacheBudget says otherwise.
+const DefaultCacheBudget = 256 << 20
+
+// WithCacheBudget limits the memory an image sequence uses to cache
+// encoded frames. Sequences that fit are decoded only once.
+func WithCacheBudget(bytes int64) Option {
+	return func(o *options) { o.cacheBudget = bytes }
+}
+
+// printfVerb matches the frame number in a pattern such as frame_%05d.png.
+var printfVerb = regexp.MustCompile(`%(0\d+)?d`)
+
+// isSequencePattern reports whether path names an image sequence rather
+// than a file: a printf-style pattern, or a glob that isn't an existing
+// file and matches some. A mistyped name such as clip[1].mp4 that matches
+// nothing is left to fail as a missing file.
+func isSequencePattern(path string) bool {
+	if _, err := os.Stat(path); err == nil {
+		return false
+	}
+	if len(printfVerb.FindAllStringIndex(filepath.Base(path), -1)) == 1 {
+		return true
+	}
+	if !strings.ContainsAny(path, "*?[") {
+		return false
+	}
+	matches, err := filepath.Glob(path)
+	return err == nil && len(matches) > 0
+}
+
+// sequencePaths expands pattern to the frame files in playback order.
+// printf patterns sort by frame number, tolerating gaps; globs sort by
+// name, which suits zero-padded numbering.
+func sequencePaths(pattern string) ([]string, error) {
+	dir, base := filepath.Split(pattern)
+	loc := printfVerb.FindStringSubmatchIndex(base)
+	if loc == nil || len(printfVerb.FindAllStringIndex(base, -1)) != 1 {
+		paths, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid image sequence pattern %q: %w", pattern, err)
+		}
+		sort.Strings(paths)
+		return paths, nil
+	}
+
+	digits := `\d+`
+	if loc[2] >= 0 {
+		width, _ := strconv.Atoi(base[loc[2]+1 : loc[3]])
+		digits = fmt.Sprintf(`\d{%d,}`, width)
+	}
+	re := regexp.MustCompile("^" + regexp.QuoteMeta(base[:loc[0]]) +
+		"(" + digits + ")" + regexp.QuoteMeta(base[loc[1]:]) + "$")
+
+	if dir == "" {
+		dir = "."
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("reading image sequence directory %q: %w", dir, err)
+	}
+	type numbered struct {
+		n    int
+		path string
+	}
+	var frames []numbered
+	for _, e := range entries {
+		m := re.FindStringSubmatch(e.Name())
+		if m == nil || e.IsDir() {
+			continue
+		}
+		n, err := strconv.Atoi(m[1])
+		if err != nil {
+			continue
+		}
+		frames = append(frames, numbered{n, filepath.Join(dir, e.Name())})
+	}
+	sort.Slice(frames, func(i, j int) bool { return frames[i].n < frames[j].n })
+
+	paths := make([]string, len(frames))
+	for i, f := range frames {
+		paths[i] = f.path
+	}
+	return paths, nil
+}
+
+// seqFrame is a cache slot for one frame of a sequence. ready is closed
+// once data or err is set.
+type seqFrame struct {
+	ready chan struct{}
+	data  []byte
+	err   error
+}
+
+// seqJob asks a worker to decode frame i into f.
+type seqJob struct {
+	i int
+	f *seqFrame
+}
+
+// sequenceSource plays numbered image files as video at the frame rate,
+// looping at the end. A pool of workers decodes frames ahead of playback
+// into a cache bounded by a memory budget, so no FFmpeg is needed.
+type sequenceSource struct {
+	mu        sync.Mutex
+	paths     []string
+	frameRate int
+	budget    int64
+	ahead     int // frames to decode ahead of playback
+
+	cache  map[int]*seqFrame
+	cached int64 // bytes of encoded frames in cache
+	jobs   chan seqJob
+	wg     sync.WaitGroup
+	closed bool
+
+	startAt time.Time // when frame 0 showed, shifted forward by pauses
+	paused  time.Time // zero unless paused
+	last    []byte
+}
+
+// newSequenceSource expands pattern and starts the decoding workers.
+func newSequenceSource(pattern string, frameRate int, o options) (*sequenceSource, error) {
+	paths, err := sequencePaths(pattern)
+	if err != nil {
+		return nil, err
+	}
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("no files match image sequence %q", pattern)
+	}
+
+	s := &sequenceSource{
+		paths:     paths,
+		frameRate: frameRate,
+		budget:    o.cacheBudget,
+		ahead:     min(2*frameRate, len(paths)-1),
+		cache:     make(map[int]*seqFrame),
+		startAt:   time.Now(),
+	}
+	s.jobs = make(chan seqJob, s.ahead+1)
+	for i := 0; i < runtime.GOMAXPROCS(0); i++ {
+		s.wg.Add(1)
+		go s.worker()
+	}
+
+	// Decode the first frame now so a broken sequence fails early.
+	if _, err := s.NextFrame(); err != nil {
+		s.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *sequenceSource) worker() {
+	defer s.wg.Done()
+	for job := range s.jobs {
+		s.decode(job.i, job.f)
+	}
+}
+
+// decode encodes frame i into its cache slot f.
+func (s *sequenceSource) decode(i int, f *seqFrame) {
+	data, err := encodeImageFile(s.paths[i])
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f.data, f.err = data, err
+	if s.cache[i] == f {
+		s.cached += int64(len(data))
+	}
+	close(f.ready)
+}
+
+// request returns the cache slot for frame i, queueing it for decoding if
+// needed. A prefetch is dropped when the workers are backed up; the frame
+// about to be shown is decoded on its own goroutine instead.
+// Called with s.mu held.
+func (s *sequenceSource) request(i int, prefetch bool) *seqFrame {
+	if f, ok := s.cache[i]; ok {
+		return f
+	}
+	f := &seqFrame{ready: make(chan struct{})}
+	s.cache[i] = f
+	select {
+	case s.jobs <- seqJob{i, f}:
+	default:
+		if prefetch {
+			delete(s.cache, i)
+			return nil
+		}
+		go s.decode(i, f)
+	}
+	return f
+}
+
+// index returns the frame showing now.
+func (s *sequenceSource) index() int {
+	elapsed := time.Since(s.startAt)
+	if !s.paused.IsZero() {
+		elapsed = s.paused.Sub(s.startAt)
+	}
+	return int(elapsed.Seconds()*float64(s.frameRate)) % len(s.paths)
+}
+
+// evict drops cached frames furthest ahead of cur in playback order, which
+// means the ones just played go first, until the cache fits the budget.
+// Called with s.mu held.
+func (s *sequenceSource) evict(cur int) {
+	n := len(s.paths)
+	for s.cached > s.budget {
+		victim, dist := -1, 0
+		for i, f := range s.cache {
+			select {
+			case <-f.ready:
+			default:
+				continue // still decoding
+			}
+			if d := (i - cur + n) % n; d > dist {
+				victim, dist = i, d
+			}
+		}
+		if victim < 0 {
+			return
+		}
+		s.cached -= int64(len(s.cache[victim].data))
+		delete(s.cache, victim)
+	}
+}
+
+// NextFrame returns the frame for the current time, waiting for it to be
+// decoded if the workers have fallen behind. A frame that fails to decode
+// is replaced by the previous one.
+func (s *sequenceSource) NextFrame() ([]byte, error) {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil, fmt.Errorf("image sequence closed")
+	}
+	cur := s.index()
+	f := s.request(cur, false)
+	for k := 1; k <= s.ahead && s.cached < s.budget; k++ {
+		if s.request((cur+k)%len(s.paths), true) == nil {
+			break
+		}
+	}
+	s.mu.Unlock()
+
+	<-f.ready
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.evict(cur)
+	if f.err != nil {
+		if s.last != nil {
+			return s.last, nil
+		}
+		return nil, f.err
+	}
+	s.last = f.data
+	return f.data, nil
+}
+
+// Seek jumps to the frame at pos, wrapping past the end.
+func (s *sequenceSource) Seek(pos time.Duration) error {
+	if pos < 0 {
+		return fmt.Errorf("invalid seek position %v", pos)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	length := time.Duration(len(s.paths)) * time.Second / time.Duration(s.frameRate)
+	now := time.Now()
+	s.startAt = now.Add(-(pos % length))
+	if !s.paused.IsZero() {
+		s.paused = now
+	}
+	return nil
+}
+
+// Pause freezes playback on the current frame.
+func (s *sequenceSource) Pause() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.paused.IsZero() {
+		s.paused = time.Now()
+	}
+}
+
+// Resume continues playback from the paused frame.
+func (s *sequenceSource) Resume() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.paused.IsZero() {
+		s.startAt = s.startAt.Add(time.Since(s.paused))
+		s.paused = time.Time{}
+	}
+}
+
+// Close stops the decoding workers and drops the cache.
+func (s *sequenceSource) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.closed = true
+	close(s.jobs)
+	s.mu.Unlock()
+
+	s.wg.Wait()
+	s.mu.Lock()
+	s.cache, s.cached = nil, 0
+	s.mu.Unlock()
+	return nil
+}
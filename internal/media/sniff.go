@@ -0,0 +1,108 @@
+package media
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// sniffLen is how much of a file is read to detect its format; it is also
+// what http.DetectContentType considers.
+const sniffLen = 512
+
+// extFormats maps file extensions to the format they usually hold, for
+// files whose content doesn't identify them.
+var extFormats = map[string]string{
+	".jpg": "image/jpeg", ".jpeg": "image/jpeg", ".png": "image/png",
+	".webp": "image/webp", ".bmp": "image/bmp", ".tif": "image/tiff", ".tiff": "image/tiff",
+	".gif": "image/gif",
+	".mp4": "video/mp4", ".m4v": "video/mp4", ".mov": "video/quicktime",
+	".mkv": "video/x-matroska", ".webm": "video/webm", ".avi": "video/x-msvideo",
+	".flv": "video/x-flv", ".ts": "video/mp2t",
+	".m3u": "audio/x-mpegurl", ".txt": "text/plain",
+}
+
+// UnsupportedFormatError is returned by Open for a file it can't play.
+type UnsupportedFormatError struct {
+	Path string
+	// MIMEType is the content type detected from the file's first bytes,
+	// or empty if the file couldn't be read.
+	MIMEType string
+}
+
+func (e *UnsupportedFormatError) Error() string {
+	what := fmt.Sprintf("file type %q", strings.ToLower(filepath.Ext(e.Path)))
+	if e.MIMEType != "" {
+		what = fmt.Sprintf("format %s in %q", e.MIMEType, e.Path)
+	}
+	return fmt.Sprintf("unsupported %s — supported formats: %s", what, strings.Join(SupportedExtensions, ", "))
+}
+
+// detectFormat returns the MIME type of the file at path from its magic
+// bytes. Only if they are inconclusive, as for text or unreadable files,
+// is the extension used instead. It returns "" if the file is not in a
+// format Open plays; head is the start of the file, or nil if it couldn't
+// be read.
+func detectFormat(path string) (format string, head []byte) {
+	if f, err := os.Open(path); err == nil {
+		head = make([]byte, sniffLen)
+		n, _ := io.ReadFull(f, head)
+		head = head[:n]
+		f.Close()
+	}
+	if format := sniffFormat(head); format != "" {
+		return format, head
+	}
+	if ct := contentType(head); ct != "" && ct != "application/octet-stream" && !strings.HasPrefix(ct, "text/plain") {
+		return "", head // recognizably something else, such as a PDF or HTML page
+	}
+	return extFormats[strings.ToLower(filepath.Ext(path))], head
+}
+
+// sniffFormat identifies the media formats Open plays from their magic
+// bytes, returning "" if b matches none of them.
+func sniffFormat(b []byte) string {
+	switch {
+	case bytes.HasPrefix(b, []byte{0xFF, 0xD8, 0xFF}):
+		return "image/jpeg"
+	case bytes.HasPrefix(b, []byte("\x89PNG\r\n\x1a\n")):
+		return "image/png"
+	case bytes.HasPrefix(b, []byte("GIF87a")), bytes.HasPrefix(b, []byte("GIF89a")):
+		return "image/gif"
+	case len(b) >= 12 && string(b[:4]) == "RIFF" && string(b[8:12]) == "WEBP":
+		return "image/webp"
+	case len(b) >= 12 && string(b[:4]) == "RIFF" && string(b[8:12]) == "AVI ":
+		return "video/x-msvideo"
+	case len(b) >= 18 && string(b[:2]) == "BM" && bytes.Equal(b[6:10], []byte{0, 0, 0, 0}) &&
+		bytes.Equal(b[15:18], []byte{0, 0, 0}):
+		// The reserved header fields are zero and the DIB header size is small.
+		return "image/bmp"
+	case bytes.HasPrefix(b, []byte("II*\x00")), bytes.HasPrefix(b, []byte("MM\x00*")):
+		return "image/tiff"
+	case len(b) >= 12 && string(b[4:8]) == "ftyp":
+		// ISO base media: the major brand tells QuickTime from MP4.
+		if string(b[8:12]) == "qt  " {
+			return "video/quicktime"
+		}
+		return "video/mp4"
+	case bytes.HasPrefix(b, []byte{0x1A, 0x45, 0xDF, 0xA3}):
+		// EBML: the DocType element says whether it is WebM or Matroska.
+		if bytes.Contains(b, []byte("webm")) {
+			return "video/webm"
+		}
+		return "video/x-matroska"
+	}
+	return ""
+}
+
+// contentType describes content that sniffFormat didn't recognize.
+func contentType(head []byte) string {
+	if head == nil {
+		return ""
+	}
+	return http.DetectContentType(head)
+}
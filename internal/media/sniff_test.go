@@ -0,0 +1,30 @@
+package media
+
+import "testing"
+
+func TestSniffFormat(t *testing.T) {
+	for _, tc := range []struct {
+		head string
+		want string
+	}{
+		{"\xFF\xD8\xFF\xE0\x00\x10JFIF", "image/jpeg"},
+		{"\x89PNG\r\n\x1a\n\x00\x00\x00\x0dIHDR", "image/png"},
+		{"GIF89a\x01\x00\x01\x00", "image/gif"},
+		{"RIFF\x24\x00\x00\x00WEBPVP8 ", "image/webp"},
+		{"BM\x3a\x00\x00\x00\x00\x00\x00\x00\x36\x00\x00\x00\x28\x00\x00\x00", "image/bmp"},
+		{"II*\x00\x08\x00\x00\x00", "image/tiff"},
+		{"MM\x00*\x00\x00\x00\x08", "image/tiff"},
+		{"\x00\x00\x00\x20ftypisom\x00\x00\x02\x00", "video/mp4"},
+		{"\x00\x00\x00\x14ftypqt  \x00\x00\x02\x00", "video/quicktime"},
+		{"\x1A\x45\xDF\xA3\x9F\x42\x86\x81\x01\x42\x82\x84webm", "video/webm"},
+		{"\x1A\x45\xDF\xA3\xA3\x42\x86\x81\x01\x42\x82\x88matroska", "video/x-matroska"},
+		{"RIFF\x24\x00\x00\x00AVI LIST", "video/x-msvideo"},
+		{"BMW.jpg\nAudi.jpg\n", ""},
+		{"%PDF-1.7", ""},
+		{"", ""},
+	} {
+		if got := sniffFormat([]byte(tc.head)); got != tc.want {
+			t.Errorf("sniffFormat(%q) = %q, want %q", tc.head, got, tc.want)
+		}
+	}
+}
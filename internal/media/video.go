@@ -2,54 +2,120 @@ package media
 
 import (
 	"bytes"
-	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
 	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
 	"sync"
+	"time"
 )
 
+// Bounds for the delay before FFmpeg is respawned after a failure. The
+// delay doubles with each consecutive failure.
+const (
+	minRestartDelay = 500 * time.Millisecond
+	maxRestartDelay = 30 * time.Second
+)
+
+// errSourceClosed is returned by NextFrame once Close has been called.
+var errSourceClosed = errors.New("video source closed")
+
 // videoSource pipes frames from an FFmpeg subprocess as raw JPEG images.
 // It works with any container/codec that FFmpeg supports, and loops automatically.
+// If FFmpeg dies it is respawned with backoff, and the last good frame is
+// repeated until the new process produces output.
 type videoSource struct {
 	mu        sync.Mutex
 	path      string
 	frameRate int
-	cmd       *exec.Cmd
-	stdout    io.ReadCloser
+	start     time.Duration // clip start; playback loops back here
+	end       time.Duration // clip end; zero plays to the end of the file
+	speed     float64
+	once      bool          // return io.EOF at the end instead of looping
+	finished  bool          // a once-only video reached its end
+	offset    time.Duration // where the current FFmpeg process started reading
+	frames    int           // frames read from the current FFmpeg process
 	buf       bytes.Buffer
+	last      []byte // last good frame, served while FFmpeg is down
+
+	delay    time.Duration // current backoff delay
+	retryAt  time.Time     // when a failed FFmpeg may be respawned
+	stderr   tailBuffer
+	duration durationProbe // file length, from FFmpeg's input banner
+
+	// procMu guards the process handles and failure stats, so Close and
+	// Health don't wait while NextFrame is blocked reading from FFmpeg.
+	procMu   sync.Mutex
+	cmd      *exec.Cmd
+	stdout   io.ReadCloser
+	closed   bool
+	restarts int
+	lastErr  error
 }
 
 // newVideoSource verifies that FFmpeg is available, then spawns the decoding
 // subprocess. FFmpeg outputs one JPEG per frame separated by JPEG EOI markers.
-func newVideoSource(path string, frameRate int) (*videoSource, error) {
+func newVideoSource(path string, frameRate int, o options) (*videoSource, error) {
 	if _, err := exec.LookPath("ffmpeg"); err != nil {
 		return nil, fmt.Errorf(
 			"ffmpeg not found in PATH — please install FFmpeg to stream video files: %w", err,
 		)
 	}
 
-	s := &videoSource{path: path, frameRate: frameRate}
+	s := &videoSource{
+		path:      path,
+		frameRate: frameRate,
+		start:     o.start,
+		end:       o.end,
+		speed:     o.speed,
+		once:      o.once,
+		offset:    o.start,
+	}
 	if err := s.spawn(); err != nil {
 		return nil, err
 	}
 	return s, nil
 }
 
-// spawn starts (or restarts) the FFmpeg process. Called on init and on loop.
+// ffmpegLoops reports whether FFmpeg loops the input by itself. Its
+// -stream_loop always rewinds to the start of the file, so that only works
+// when playback started there and runs to the end; sub-clips and seeks are
+// looped by restarting FFmpeg instead.
+func (s *videoSource) ffmpegLoops() bool {
+	return s.offset == 0 && s.end == 0 && !s.once
+}
+
+// spawn starts (or restarts) the FFmpeg process at s.offset. Called on
+// init, on seek, on playback changes and at the end of a clip.
 func (s *videoSource) spawn() error {
-	// -stream_loop -1 tells FFmpeg to loop the input indefinitely.
-	// image2pipe + mjpeg output gives us a raw stream of back-to-back JPEGs.
-	cmd := exec.Command("ffmpeg",
-		"-stream_loop", "-1",
-		"-re", // read at native frame rate
-		"-i", s.path,
-		"-vf", fmt.Sprintf("fps=%d", s.frameRate),
-		"-q:v", "3", // JPEG quality (2=best, 31=worst)
-		"-f", "image2pipe",
-		"-vcodec", "mjpeg",
-		"-",
-	)
+	var args []string
+	if s.ffmpegLoops() {
+		// -stream_loop -1 tells FFmpeg to loop the input indefinitely.
+		args = append(args, "-stream_loop", "-1")
+	}
+	if s.speed == 1 {
+		// Read at native frame rate. At other speeds FFmpeg would throttle
+		// the wrong timeline, so the pipe is paced by our reads instead.
+		args = append(args, "-re")
+	}
+	if s.offset > 0 {
+		args = append(args, "-ss", formatSeconds(s.offset))
+	}
+	if s.end > 0 {
+		args = append(args, "-t", formatSeconds(s.end-s.offset))
+	}
+
+	filter := fmt.Sprintf("fps=%d", s.frameRate)
+	if s.speed != 1 {
+		filter = fmt.Sprintf("setpts=PTS/%s,%s", strconv.FormatFloat(s.speed, 'f', -1, 64), filter)
+	}
+
+	args = append(args, "-i", s.path)
+	cmd := exec.Command("ffmpeg", append(args, jpegOutputArgs(filter)...)...)
+	cmd.Stderr = io.MultiWriter(&s.stderr, &s.duration)
 
 	stdout, err := cmd.StdoutPipe()
 	if err != nil {
@@ -60,71 +126,372 @@ func (s *videoSource) spawn() error {
 		return fmt.Errorf("starting ffmpeg: %w", err)
 	}
 
+	s.procMu.Lock()
+	defer s.procMu.Unlock()
+	if s.closed {
+		cmd.Process.Kill() //nolint:errcheck
+		cmd.Wait()         //nolint:errcheck
+		return errSourceClosed
+	}
 	s.cmd = cmd
 	s.stdout = stdout
+	s.frames = 0
+	return nil
+}
+
+// jpegOutputArgs returns the FFmpeg output options that write the input,
+// passed through the video filter, to stdout as back-to-back JPEGs.
+func jpegOutputArgs(filter string) []string {
+	return []string{
+		"-vf", filter,
+		"-q:v", "3", // JPEG quality (2=best, 31=worst)
+		// Baseline 4:2:0 with the standard Huffman tables is what RTP/JPEG
+		// (RFC 2435) receivers assume, so frames can be relayed untouched.
+		"-pix_fmt", "yuvj420p",
+		"-huffman", "default",
+		// image2pipe + mjpeg output gives us a raw stream of back-to-back JPEGs.
+		"-f", "image2pipe",
+		"-vcodec", "mjpeg",
+		"-",
+	}
+}
+
+// stop kills the FFmpeg process, if any, and waits for it to exit. It
+// returns the process's exit error, or nil if it had exited cleanly.
+func (s *videoSource) stop(kill bool) error {
+	s.procMu.Lock()
+	cmd := s.cmd
+	s.cmd, s.stdout = nil, nil
+	s.procMu.Unlock()
+
+	s.buf.Reset()
+	if cmd == nil {
+		return nil
+	}
+	if kill {
+		cmd.Process.Kill() //nolint:errcheck
+	}
+	return cmd.Wait()
+}
+
+// restart replaces the FFmpeg process with one starting at pos. A failure
+// to spawn is also recorded so NextFrame retries with backoff.
+func (s *videoSource) restart(pos time.Duration) error {
+	s.stop(true) //nolint:errcheck // the process was killed on purpose
+	s.offset = pos
+	s.delay, s.retryAt = 0, time.Time{}
+	s.finished = false
+	if err := s.spawn(); err != nil {
+		s.fail(err)
+		return err
+	}
 	return nil
 }
 
-// NextFrame reads the next JPEG frame from the FFmpeg output pipe.
-// It scans for JPEG SOI (0xFFD8) and EOI (0xFFD9) markers to extract
-// each complete frame from the continuous byte stream.
+// fail records an FFmpeg failure and schedules a respawn after the
+// current backoff delay.
+func (s *videoSource) fail(err error) {
+	if tail := s.stderr.lastLine(); tail != "" {
+		err = fmt.Errorf("%w (ffmpeg: %s)", err, tail)
+	}
+	s.procMu.Lock()
+	s.lastErr = err
+	s.procMu.Unlock()
+
+	s.delay = min(max(2*s.delay, minRestartDelay), maxRestartDelay)
+	s.retryAt = time.Now().Add(s.delay)
+}
+
+// fallback is returned by NextFrame while FFmpeg is down: the last good
+// frame if there is one, so viewers see a frozen picture, or the error.
+func (s *videoSource) fallback() ([]byte, error) {
+	if s.last != nil {
+		return s.last, nil
+	}
+	s.procMu.Lock()
+	defer s.procMu.Unlock()
+	return nil, s.lastErr
+}
+
+// clipEnd returns where playback loops back to the clip start: the clip
+// end if one is set, else the length of the file, or zero if not yet known.
+func (s *videoSource) clipEnd() time.Duration {
+	if s.end > 0 {
+		return s.end
+	}
+	return s.duration.get()
+}
+
+// position estimates the current playback position in the file from the
+// number of frames delivered since FFmpeg started. The count keeps growing
+// while FFmpeg loops the file by itself, so it is wrapped into the clip.
+func (s *videoSource) position() time.Duration {
+	played := float64(s.frames) / float64(s.frameRate) * s.speed
+	pos := s.offset + time.Duration(played*float64(time.Second))
+	if end := s.clipEnd(); end > s.start && pos >= end {
+		pos = s.start + (pos-s.start)%(end-s.start)
+	}
+	return pos
+}
+
+// NextFrame returns the next frame from FFmpeg. When a clip runs out, FFmpeg
+// is restarted at the clip start so playback loops; a process started at
+// the very end of the clip may exit cleanly without any output, which
+// counts as the end too. Any other exit is treated as a crash: the last
+// good frame is returned until FFmpeg has been respawned.
 func (s *videoSource) NextFrame() ([]byte, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	// Read until we find a complete JPEG (SOI...EOI).
 	for {
-		b, err := readByte(s.stdout)
-		if err != nil {
-			return nil, fmt.Errorf("reading from ffmpeg: %w", err)
+		s.procMu.Lock()
+		closed, running := s.closed, s.cmd != nil
+		s.procMu.Unlock()
+		if closed {
+			return nil, errSourceClosed
 		}
-		// Look for JPEG SOI marker: 0xFF 0xD8
-		if b != 0xFF {
-			continue
+		if s.finished {
+			return nil, io.EOF
 		}
-		b2, err := readByte(s.stdout)
-		if err != nil {
-			return nil, err
+
+		if !running {
+			if time.Now().Before(s.retryAt) {
+				return s.fallback()
+			}
+			s.procMu.Lock()
+			s.restarts++
+			s.procMu.Unlock()
+			if err := s.spawn(); err != nil {
+				s.fail(err)
+				return s.fallback()
+			}
 		}
-		if b2 != 0xD8 {
-			continue
+
+		frame, err := s.readFrame()
+		if err == nil {
+			s.frames++
+			if s.frames == s.frameRate {
+				// A second of healthy output clears the backoff.
+				s.delay = 0
+			}
+			s.last = frame
+			return frame, nil
 		}
 
-		// We're at the start of a JPEG. Read until EOI (0xFF 0xD9).
-		frame := []byte{0xFF, 0xD8}
-		for {
-			chunk := make([]byte, 4096)
-			n, err := s.stdout.Read(chunk)
-			if n > 0 {
-				frame = append(frame, chunk[:n]...)
-				// Check if EOI appeared in this chunk
-				if idx := bytes.Index(frame, []byte{0xFF, 0xD9}); idx >= 0 {
-					return frame[:idx+2], nil
-				}
+		// A closed pipe means FFmpeg is exiting; anything else may leave
+		// it running, so kill it before reaping.
+		eof := errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+		exitErr := s.stop(!eof)
+		if s.isClosed() {
+			return nil, errSourceClosed
+		}
+		if exitErr == nil && (s.frames > 0 || s.offset > s.start) && !s.ffmpegLoops() {
+			if s.once {
+				s.finished = true
+				return nil, io.EOF
 			}
-			if err != nil {
-				return nil, fmt.Errorf("reading ffmpeg frame body: %w", err)
+			// The clip ended normally; loop back to its start.
+			s.offset = s.start
+			if err := s.spawn(); err != nil {
+				s.fail(err)
+				return s.fallback()
 			}
+			continue
+		}
+
+		if exitErr != nil {
+			err = fmt.Errorf("ffmpeg exited: %w", exitErr)
 		}
+		// Retry from the clip start: the position that failed may be
+		// what FFmpeg chokes on.
+		s.offset = s.start
+		s.fail(err)
+		return s.fallback()
+	}
+}
+
+func (s *videoSource) isClosed() bool {
+	s.procMu.Lock()
+	defer s.procMu.Unlock()
+	return s.closed
+}
+
+// readFrame reads the next JPEG frame from the FFmpeg output pipe. Bytes
+// read past the end of the frame stay in s.buf for the next call.
+func (s *videoSource) readFrame() ([]byte, error) {
+	frame, err := readJPEG(s.stdout, &s.buf)
+	if err != nil {
+		return nil, fmt.Errorf("reading from ffmpeg: %w", err)
+	}
+	return frame, nil
+}
+
+// Seek restarts FFmpeg at pos, which must lie within the clip. Viewers keep
+// the last frame until the new process delivers its first one.
+func (s *videoSource) Seek(pos time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if end := s.clipEnd(); pos < s.start || (end > 0 && pos >= end) {
+		return fmt.Errorf("seek position %v is outside the clip", pos)
+	}
+	return s.restart(pos)
+}
+
+// SetClip restricts playback to [start, end) and restarts at start.
+func (s *videoSource) SetClip(start, end time.Duration) error {
+	if err := validateClip(start, end); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.start, s.end = start, end
+	return s.restart(start)
+}
+
+// SetSpeed restarts FFmpeg at the current position with the new speed.
+func (s *videoSource) SetSpeed(speed float64) error {
+	if err := validateSpeed(speed); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	pos := s.position()
+	s.speed = speed
+	return s.restart(pos)
+}
+
+// Health reports whether FFmpeg is running, how often it has been
+// restarted after failures, and the tail of its stderr.
+func (s *videoSource) Health() Health {
+	s.procMu.Lock()
+	defer s.procMu.Unlock()
+
+	h := Health{
+		Running:  s.cmd != nil,
+		Restarts: s.restarts,
+		Stderr:   s.stderr.String(),
+	}
+	if s.lastErr != nil {
+		h.LastError = s.lastErr.Error()
 	}
+	return h
 }
 
-// Close terminates the FFmpeg subprocess and closes the pipe.
+// Close terminates the FFmpeg subprocess, closes the pipe and reaps the
+// process so it doesn't linger as a zombie. The process is not respawned
+// afterwards.
 func (s *videoSource) Close() error {
+	s.procMu.Lock()
+	s.closed = true
 	if s.stdout != nil {
 		s.stdout.Close()
 	}
-	if s.cmd != nil && s.cmd.Process != nil {
-		return s.cmd.Process.Kill()
+	cmd := s.cmd
+	s.cmd = nil // NextFrame won't wait for it too
+	s.procMu.Unlock()
+
+	if cmd == nil || cmd.Process == nil {
+		return nil
 	}
-	return nil
+	err := cmd.Process.Kill()
+	cmd.Wait() //nolint:errcheck // killed on purpose
+	return err
+}
+
+// formatSeconds renders d as an FFmpeg time argument.
+func formatSeconds(d time.Duration) string {
+	return strconv.FormatFloat(d.Seconds(), 'f', 3, 64)
+}
+
+// stderrTail is how much of FFmpeg's stderr is kept for Health.
+const stderrTail = 2048
+
+// tailBuffer is an io.Writer that keeps only the last stderrTail bytes
+// written to it, trimmed to whole lines.
+type tailBuffer struct {
+	mu  sync.Mutex
+	buf []byte
+}
+
+func (t *tailBuffer) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.buf = append(t.buf, p...)
+	if over := len(t.buf) - stderrTail; over > 0 {
+		cut := over
+		if i := bytes.IndexByte(t.buf[over:], '\n'); i >= 0 {
+			cut += i + 1
+		}
+		t.buf = append(t.buf[:0], t.buf[cut:]...)
+	}
+	return len(p), nil
+}
+
+// String returns the retained output.
+func (t *tailBuffer) String() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return string(t.buf)
+}
+
+// lastLine returns the last non-empty line of the retained output.
+func (t *tailBuffer) lastLine() string {
+	lines := strings.Split(strings.TrimSpace(t.String()), "\n")
+	return strings.TrimSpace(lines[len(lines)-1])
+}
+
+// durationProbe is an io.Writer that picks the input duration out of
+// FFmpeg's stderr banner ("Duration: 00:01:23.45, start: ...").
+type durationProbe struct {
+	mu      sync.Mutex
+	partial []byte // unterminated last line
+	d       time.Duration
+}
+
+var durationLine = regexp.MustCompile(`Duration: (\d+):(\d{2}):(\d{2}(?:\.\d+)?)`)
+
+func (p *durationProbe) Write(b []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	// The banner comes first; once found, the rest can be ignored.
+	if p.d > 0 {
+		return len(b), nil
+	}
+	p.partial = append(p.partial, b...)
+	for {
+		i := bytes.IndexByte(p.partial, '\n')
+		if i < 0 {
+			break
+		}
+		line := p.partial[:i]
+		p.partial = p.partial[i+1:]
+		if m := durationLine.FindSubmatch(line); m != nil {
+			h, _ := strconv.Atoi(string(m[1]))
+			mins, _ := strconv.Atoi(string(m[2]))
+			sec, _ := strconv.ParseFloat(string(m[3]), 64)
+			p.d = time.Duration(h)*time.Hour + time.Duration(mins)*time.Minute +
+				time.Duration(sec*float64(time.Second))
+			p.partial = nil
+			break
+		}
+	}
+	if len(p.partial) > stderrTail {
+		p.partial = p.partial[len(p.partial)-stderrTail:]
+	}
+	return len(b), nil
 }
 
-// readByte is a helper that reads exactly one byte from r.
-func readByte(r io.Reader) (byte, error) {
-	var b [1]byte
-	_, err := io.ReadFull(r, b[:])
-	// suppress "unused import" for binary — it's available for future use
-	_ = binary.LittleEndian
-	return b[0], err
+// get returns the probed duration, or zero if FFmpeg hasn't reported one
+// (yet, or at all, e.g. for "Duration: N/A").
+func (p *durationProbe) get() time.Duration {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.d
 }
@@ -0,0 +1,173 @@
+// Package metrics implements the few Prometheus metric types mediastream
+// exports, and the text format they are scraped in, without depending on
+// the Prometheus client library.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// LatencyBuckets are the upper bounds, in seconds, of the latency
+// histograms: from a millisecond to a second.
+var LatencyBuckets = []float64{0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1}
+
+// Process-wide frame processing latencies, labelled by the stage that
+// decodes or encodes: image, gif, pattern, pipeline or rtsp.
+var (
+	DecodeSeconds = NewHistogramVec(LatencyBuckets)
+	EncodeSeconds = NewHistogramVec(LatencyBuckets)
+)
+
+// Histogram counts observed durations into buckets. It is safe for
+// concurrent use.
+type Histogram struct {
+	bounds []float64       // upper bounds in seconds, ascending
+	counts []atomic.Uint64 // per bucket, plus one for +Inf
+	sum    atomic.Int64    // nanoseconds
+}
+
+// NewHistogram returns a histogram with the given ascending bucket bounds,
+// in seconds.
+func NewHistogram(bounds []float64) *Histogram {
+	return &Histogram{bounds: bounds, counts: make([]atomic.Uint64, len(bounds)+1)}
+}
+
+// Observe records d.
+func (h *Histogram) Observe(d time.Duration) {
+	i := sort.SearchFloat64s(h.bounds, d.Seconds())
+	h.counts[i].Add(1)
+	h.sum.Add(int64(d))
+}
+
+// ObserveSince records the time elapsed since start.
+func (h *Histogram) ObserveSince(start time.Time) {
+	h.Observe(time.Since(start))
+}
+
+// HistogramVec is a set of histograms told apart by one label.
+type HistogramVec struct {
+	bounds []float64
+
+	mu sync.Mutex
+	m  map[string]*Histogram
+}
+
+// NewHistogramVec returns an empty set of histograms with the given
+// bucket bounds.
+func NewHistogramVec(bounds []float64) *HistogramVec {
+	return &HistogramVec{bounds: bounds, m: make(map[string]*Histogram)}
+}
+
+// With returns the histogram for label, creating it on first use.
+func (v *HistogramVec) With(label string) *Histogram {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	h, ok := v.m[label]
+	if !ok {
+		h = NewHistogram(v.bounds)
+		v.m[label] = h
+	}
+	return h
+}
+
+// Writer writes metrics in the Prometheus text exposition format,
+// version 0.0.4. Writing stops at the first error.
+type Writer struct {
+	w   io.Writer
+	err error
+}
+
+// ContentType is the media type of the text exposition format.
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// NewWriter returns a Writer writing to w.
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: w}
+}
+
+func (w *Writer) printf(format string, args ...any) {
+	if w.err == nil {
+		_, w.err = fmt.Fprintf(w.w, format, args...)
+	}
+}
+
+// Header starts a metric family; typ is counter, gauge or histogram.
+func (w *Writer) Header(name, typ, help string) {
+	w.printf("# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+// Sample writes one sample. labels alternate names and values.
+func (w *Writer) Sample(name string, value float64, labels ...string) {
+	w.printf("%s%s %s\n", name, formatLabels(labels), formatValue(value))
+}
+
+// HistogramVec writes every histogram in v as one family, each labelled
+// with label.
+func (w *Writer) HistogramVec(name, help, label string, v *HistogramVec) {
+	w.Header(name, "histogram", help)
+
+	v.mu.Lock()
+	keys := make([]string, 0, len(v.m))
+	for k := range v.m {
+		keys = append(keys, k)
+	}
+	v.mu.Unlock()
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		h := v.With(k)
+		var cum uint64
+		for i := range h.counts {
+			cum += h.counts[i].Load()
+			le := math.Inf(1)
+			if i < len(h.bounds) {
+				le = h.bounds[i]
+			}
+			w.Sample(name+"_bucket", float64(cum), label, k, "le", formatValue(le))
+		}
+		w.Sample(name+"_sum", time.Duration(h.sum.Load()).Seconds(), label, k)
+		w.Sample(name+"_count", float64(cum), label, k)
+	}
+}
+
+// formatLabels renders label pairs as {a="1",b="2"}, or "" if there are
+// none.
+func formatLabels(labels []string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteByte('{')
+	for i := 0; i+1 < len(labels); i += 2 {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(labels[i])
+		b.WriteString(`="`)
+		b.WriteString(labelEscaper.Replace(labels[i+1]))
+		b.WriteByte('"')
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+// labelEscaper escapes label values as the text format requires.
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
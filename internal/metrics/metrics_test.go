@@ -0,0 +1,43 @@
+package metrics_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/idevakk/mediastream/internal/metrics"
+)
+
+func TestHistogramVec(t *testing.T) {
+	v := metrics.NewHistogramVec([]float64{0.01, 0.1})
+	v.With("a").Observe(5 * time.Millisecond)
+	v.With("a").Observe(50 * time.Millisecond)
+	v.With("a").Observe(2 * time.Second)
+
+	var b strings.Builder
+	metrics.NewWriter(&b).HistogramVec("x_seconds", "Test.", "stage", v)
+
+	want := `# HELP x_seconds Test.
+# TYPE x_seconds histogram
+x_seconds_bucket{stage="a",le="0.01"} 1
+x_seconds_bucket{stage="a",le="0.1"} 2
+x_seconds_bucket{stage="a",le="+Inf"} 3
+x_seconds_sum{stage="a"} 2.055
+x_seconds_count{stage="a"} 3
+`
+	if b.String() != want {
+		t.Errorf("got:\n%s\nwant:\n%s", b.String(), want)
+	}
+}
+
+func TestSampleEscapesLabels(t *testing.T) {
+	var b strings.Builder
+	w := metrics.NewWriter(&b)
+	w.Sample("up", 1)
+	w.Sample("clients", 2, "stream", "lobby", "remote", "a\"b\\c\nd")
+
+	want := "up 1\nclients{stream=\"lobby\",remote=\"a\\\"b\\\\c\\nd\"} 2\n"
+	if b.String() != want {
+		t.Errorf("got %q, want %q", b.String(), want)
+	}
+}
@@ -0,0 +1,180 @@
+package pipeline
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/image/draw"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/gomonobold"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+)
+
+// Frame describes the frame overlays are drawn on.
+type Frame struct {
+	Time time.Time
+	// Number counts the frames rendered since the source was opened,
+	// starting at 1.
+	Number uint64
+}
+
+// Position places an overlay in one of nine spots on the frame.
+type Position string
+
+// Positions, from the top-left corner to the bottom-right.
+const (
+	TopLeft     Position = "top-left"
+	Top         Position = "top"
+	TopRight    Position = "top-right"
+	Left        Position = "left"
+	Center      Position = "center"
+	Right       Position = "right"
+	BottomLeft  Position = "bottom-left"
+	Bottom      Position = "bottom"
+	BottomRight Position = "bottom-right"
+)
+
+var positions = []Position{TopLeft, Top, TopRight, Left, Center, Right, BottomLeft, Bottom, BottomRight}
+
+// ParsePosition validates a position name; "" means TopLeft.
+func ParsePosition(s string) (Position, error) {
+	if s == "" {
+		return TopLeft, nil
+	}
+	for _, p := range positions {
+		if Position(s) == p {
+			return p, nil
+		}
+	}
+	names := make([]string, len(positions))
+	for i, p := range positions {
+		names[i] = string(p)
+	}
+	return "", fmt.Errorf("invalid position %q: use %s", s, strings.Join(names, ", "))
+}
+
+// anchor returns where p sits horizontally and vertically: -1 for the
+// left or top edge, 0 for the middle and 1 for the right or bottom edge.
+func (p Position) anchor() (x, y int) {
+	switch {
+	case strings.HasPrefix(string(p), "top"):
+		y = -1
+	case strings.HasPrefix(string(p), "bottom"):
+		y = 1
+	}
+	switch {
+	case strings.HasSuffix(string(p), "left"):
+		x = -1
+	case strings.HasSuffix(string(p), "right"):
+		x = 1
+	}
+	return x, y
+}
+
+// boxColor is the semi-transparent black drawn behind text with Box set.
+var boxColor = color.NRGBA{A: 0x99}
+
+// Text draws a line of text, such as a timestamp or a camera name, onto
+// every frame. Overlays sharing a Position are stacked in order, away from
+// the edge. A Text must not be copied after first use.
+type Text struct {
+	// Content returns the text to draw on a frame.
+	Content  func(Frame) string
+	Position Position
+	// Size is the font height in pixels. Zero scales it with the frame,
+	// to a twentieth of its height.
+	Size int
+	// Color is the text colour; nil means white.
+	Color color.Color
+	// Box draws a semi-transparent box behind the text. Without one the
+	// text gets a drop shadow, so it stays readable on light frames.
+	Box bool
+
+	mu       sync.Mutex
+	face     font.Face
+	faceSize int
+}
+
+// faceFor returns the font at size pixels, loading it on first use.
+func (t *Text) faceFor(size int) (font.Face, error) {
+	if t.face != nil && t.faceSize == size {
+		return t.face, nil
+	}
+	f, err := opentype.Parse(gomonobold.TTF)
+	if err != nil {
+		return nil, fmt.Errorf("loading overlay font: %w", err)
+	}
+	face, err := opentype.NewFace(f, &opentype.FaceOptions{Size: float64(size), DPI: 72, Hinting: font.HintingFull})
+	if err != nil {
+		return nil, fmt.Errorf("loading overlay font: %w", err)
+	}
+	if t.face != nil {
+		t.face.Close()
+	}
+	t.face, t.faceSize = face, size
+	return face, nil
+}
+
+// draw draws s onto dst, offset from the edge by the height of the
+// overlays already stacked at the same position, and returns the height
+// it took up.
+func (t *Text) draw(dst *image.RGBA, s string, offset int) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	b := dst.Bounds()
+	size := t.Size
+	if size == 0 {
+		size = max(10, b.Dy()/20)
+	}
+	face, err := t.faceFor(size)
+	if err != nil {
+		return 0, err
+	}
+	m := face.Metrics()
+	ascent := m.Ascent.Ceil()
+	pad, margin := max(1, size/4), size/2
+	d := font.Drawer{Dst: dst, Face: face}
+	w := d.MeasureString(s).Ceil() + 2*pad
+	h := ascent + m.Descent.Ceil() + 2*pad
+
+	ax, ay := t.Position.anchor()
+	var x, y int
+	switch ax {
+	case -1:
+		x = b.Min.X + margin
+	case 0:
+		x = b.Min.X + (b.Dx()-w)/2
+	default:
+		x = b.Max.X - margin - w
+	}
+	switch ay {
+	case -1:
+		y = b.Min.Y + margin + offset
+	case 0:
+		y = b.Min.Y + (b.Dy()-h)/2 + offset
+	default:
+		y = b.Max.Y - margin - h - offset
+	}
+
+	dot := fixed.P(x+pad, y+pad+ascent)
+	if t.Box {
+		draw.Draw(dst, image.Rect(x, y, x+w, y+h), image.NewUniform(boxColor), image.Point{}, draw.Over)
+	} else {
+		shadow := max(1, size/16)
+		d.Src, d.Dot = image.Black, dot.Add(fixed.P(shadow, shadow))
+		d.DrawString(s)
+	}
+	var c color.Color = color.White
+	if t.Color != nil {
+		c = t.Color
+	}
+	d.Src, d.Dot = image.NewUniform(c), dot
+	d.DrawString(s)
+	return h, nil
+}
@@ -0,0 +1,83 @@
+package pipeline_test
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"testing"
+
+	"github.com/idevakk/mediastream/internal/pipeline"
+)
+
+// lit reports whether any pixel in r of img is brighter than mid-grey.
+func lit(img image.Image, r image.Rectangle) bool {
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			if c := color.GrayModel.Convert(img.At(x, y)).(color.Gray); c.Y > 0x80 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func TestOverlayPositions(t *testing.T) {
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 320, 240)), nil); err != nil {
+		t.Fatal(err)
+	}
+	p := pipeline.Pipeline{
+		Overlays: []*pipeline.Text{
+			{Content: func(pipeline.Frame) string { return "CAM 01" }, Position: pipeline.TopLeft, Size: 20},
+			{Content: func(f pipeline.Frame) string { return fmt.Sprint(f.Number) }, Position: pipeline.BottomRight, Size: 20, Box: true},
+		},
+		Quality: 95,
+	}
+	out, err := p.Process(buf.Bytes(), pipeline.Frame{Number: 42})
+	if err != nil {
+		t.Fatalf("Process: %v", err)
+	}
+	img, err := jpeg.Decode(bytes.NewReader(out))
+	if err != nil {
+		t.Fatalf("decoding output: %v", err)
+	}
+
+	if !lit(img, image.Rect(0, 0, 120, 40)) {
+		t.Error("expected the label in the top-left corner")
+	}
+	if !lit(img, image.Rect(240, 200, 320, 240)) {
+		t.Error("expected the counter in the bottom-right corner")
+	}
+	if lit(img, image.Rect(0, 200, 120, 240)) || lit(img, image.Rect(240, 0, 320, 40)) {
+		t.Error("expected the other corners to stay black")
+	}
+}
+
+func TestParsePosition(t *testing.T) {
+	if p, err := pipeline.ParsePosition(""); err != nil || p != pipeline.TopLeft {
+		t.Errorf(`ParsePosition("") = %q, %v; want top-left`, p, err)
+	}
+	if _, err := pipeline.ParsePosition("middle"); err == nil {
+		t.Error("expected an error for an unknown position")
+	}
+}
+
+func TestWrapRedrawsChangingOverlays(t *testing.T) {
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 64, 48)), nil); err != nil {
+		t.Fatal(err)
+	}
+	counter := &pipeline.Text{Content: func(f pipeline.Frame) string { return fmt.Sprint(f.Number) }}
+	src := pipeline.Wrap(&countingSource{frame: buf.Bytes()}, &pipeline.Pipeline{Overlays: []*pipeline.Text{counter}})
+
+	first, err := src.NextFrame()
+	if err != nil {
+		t.Fatalf("NextFrame: %v", err)
+	}
+	second, _ := src.NextFrame()
+	if bytes.Equal(first, second) {
+		t.Error("expected the frame counter to change a repeated frame")
+	}
+}
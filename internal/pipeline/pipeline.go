@@ -0,0 +1,196 @@
+// Package pipeline re-renders JPEG frames: it decodes a frame, passes the
+// image through a chain of transforms, draws overlays such as a timestamp
+// on it and encodes the result again.
+package pipeline
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"math"
+	"time"
+
+	"golang.org/x/image/draw"
+
+	"github.com/idevakk/mediastream/internal/metrics"
+)
+
+// DefaultQuality is the JPEG quality used when a Pipeline doesn't set one.
+const DefaultQuality = 85
+
+// MaxSize bounds the width and height a frame may be scaled to.
+const MaxSize = 7680
+
+// Transform changes a decoded frame. Implementations may return img itself
+// when there is nothing to do.
+type Transform interface {
+	Apply(img image.Image) image.Image
+}
+
+// Pipeline decodes a JPEG frame, applies its transforms in order, draws
+// its overlays and re-encodes the result at the given quality.
+type Pipeline struct {
+	Transforms []Transform
+	// Overlays are drawn over the transformed frame, in order.
+	Overlays []*Text
+	// Quality is the output JPEG quality, 1 to 100. Defaults to
+	// DefaultQuality if zero.
+	Quality int
+}
+
+// Process returns frame re-rendered through the pipeline, with overlays
+// drawn as for f.
+func (p Pipeline) Process(frame []byte, f Frame) ([]byte, error) {
+	img, err := p.transform(frame)
+	if err != nil {
+		return nil, err
+	}
+	out, _, err := p.render(img, p.texts(f), nil)
+	return out, err
+}
+
+// transform decodes frame and applies the transforms to it.
+func (p Pipeline) transform(frame []byte) (image.Image, error) {
+	start := time.Now()
+	img, err := jpeg.Decode(bytes.NewReader(frame))
+	if err != nil {
+		return nil, fmt.Errorf("decoding frame: %w", err)
+	}
+	metrics.DecodeSeconds.With("pipeline").ObserveSince(start)
+	for _, t := range p.Transforms {
+		img = t.Apply(img)
+	}
+	return img, nil
+}
+
+// texts returns the text of each overlay for f.
+func (p Pipeline) texts(f Frame) []string {
+	if len(p.Overlays) == 0 {
+		return nil
+	}
+	texts := make([]string, len(p.Overlays))
+	for i, t := range p.Overlays {
+		texts[i] = t.Content(f)
+	}
+	return texts
+}
+
+// render draws texts over a copy of img and encodes the result. canvas,
+// if it has the right size, is reused for the copy; the one used is
+// returned for next time.
+func (p Pipeline) render(img image.Image, texts []string, canvas *image.RGBA) ([]byte, *image.RGBA, error) {
+	if len(texts) > 0 {
+		b := img.Bounds()
+		if canvas == nil || canvas.Bounds().Size() != b.Size() {
+			canvas = image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+		}
+		draw.Draw(canvas, canvas.Bounds(), img, b.Min, draw.Src)
+		offsets := make(map[Position]int)
+		for i, t := range p.Overlays {
+			h, err := t.draw(canvas, texts[i], offsets[t.Position])
+			if err != nil {
+				return nil, canvas, err
+			}
+			offsets[t.Position] += h
+		}
+		img = canvas
+	}
+
+	q := p.Quality
+	if q == 0 {
+		q = DefaultQuality
+	}
+	var buf bytes.Buffer
+	start := time.Now()
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: q}); err != nil {
+		return nil, canvas, fmt.Errorf("encoding frame: %w", err)
+	}
+	metrics.EncodeSeconds.With("pipeline").ObserveSince(start)
+	return buf.Bytes(), canvas, nil
+}
+
+// Fit selects how Resize maps a frame onto its target size.
+type Fit string
+
+const (
+	// FitContain scales the frame to fit inside the target, keeping its
+	// aspect ratio; the result may be smaller than the target on one side.
+	FitContain Fit = "contain"
+	// FitCover scales the frame to cover the target, keeping its aspect
+	// ratio, and crops what overflows, centred.
+	FitCover Fit = "cover"
+	// FitFill stretches the frame to exactly the target size.
+	FitFill Fit = "fill"
+	// FitLetterbox scales the frame like FitContain and pads it with
+	// black bars, centred, to exactly the target size.
+	FitLetterbox Fit = "letterbox"
+)
+
+// ParseFit validates a fit mode name; "" means FitContain.
+func ParseFit(s string) (Fit, error) {
+	switch f := Fit(s); f {
+	case "":
+		return FitContain, nil
+	case FitContain, FitCover, FitFill, FitLetterbox:
+		return f, nil
+	default:
+		return "", fmt.Errorf("invalid fit %q: use contain, cover, fill or letterbox", s)
+	}
+}
+
+// Resize scales frames to Width x Height. If only one of them is set, the
+// other follows from the frame's aspect ratio.
+type Resize struct {
+	Width, Height int
+	Fit           Fit
+}
+
+// Apply scales img as described by r.
+func (r Resize) Apply(img image.Image) image.Image {
+	src := img.Bounds()
+	if (r.Width == 0 && r.Height == 0) || src.Empty() {
+		return img
+	}
+	sw, sh := float64(src.Dx()), float64(src.Dy())
+
+	w, h, fit := r.Width, r.Height, r.Fit
+	switch {
+	case w == 0:
+		w, fit = scaled(sw*float64(h)/sh), FitFill
+	case h == 0:
+		h, fit = scaled(sh*float64(w)/sw), FitFill
+	}
+
+	switch fit {
+	case FitCover:
+		scale := math.Max(float64(w)/sw, float64(h)/sh)
+		cw, ch := scaled(float64(w)/scale), scaled(float64(h)/scale)
+		x0, y0 := src.Min.X+(src.Dx()-cw)/2, src.Min.Y+(src.Dy()-ch)/2
+		src = image.Rect(x0, y0, x0+cw, y0+ch)
+	case FitFill:
+	case FitLetterbox:
+		scale := math.Min(float64(w)/sw, float64(h)/sh)
+		iw, ih := scaled(sw*scale), scaled(sh*scale)
+		dst := image.NewRGBA(image.Rect(0, 0, w, h))
+		draw.Draw(dst, dst.Bounds(), image.Black, image.Point{}, draw.Src)
+		x0, y0 := (w-iw)/2, (h-ih)/2
+		draw.BiLinear.Scale(dst, image.Rect(x0, y0, x0+iw, y0+ih), img, src, draw.Src, nil)
+		return dst
+	default: // FitContain
+		scale := math.Min(float64(w)/sw, float64(h)/sh)
+		w, h = scaled(sw*scale), scaled(sh*scale)
+	}
+
+	if w == src.Dx() && h == src.Dy() && src == img.Bounds() {
+		return img
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.BiLinear.Scale(dst, dst.Bounds(), img, src, draw.Src, nil)
+	return dst
+}
+
+// scaled rounds a computed dimension to whole pixels, at least one.
+func scaled(v float64) int {
+	return max(1, min(MaxSize, int(math.Round(v))))
+}
@@ -0,0 +1,105 @@
+package pipeline_test
+
+import (
+	"bytes"
+	"image"
+	"image/jpeg"
+	"testing"
+
+	"github.com/idevakk/mediastream/internal/media"
+	"github.com/idevakk/mediastream/internal/pipeline"
+)
+
+func TestResize(t *testing.T) {
+	src := image.NewRGBA(image.Rect(0, 0, 400, 200))
+	tests := []struct {
+		name   string
+		resize pipeline.Resize
+		want   image.Point
+	}{
+		{"contain", pipeline.Resize{Width: 100, Height: 100, Fit: pipeline.FitContain}, image.Pt(100, 50)},
+		{"cover", pipeline.Resize{Width: 100, Height: 100, Fit: pipeline.FitCover}, image.Pt(100, 100)},
+		{"fill", pipeline.Resize{Width: 100, Height: 100, Fit: pipeline.FitFill}, image.Pt(100, 100)},
+		{"width only", pipeline.Resize{Width: 200}, image.Pt(200, 100)},
+		{"height only", pipeline.Resize{Height: 50}, image.Pt(100, 50)},
+		{"none", pipeline.Resize{}, image.Pt(400, 200)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.resize.Apply(src).Bounds().Size(); got != tt.want {
+				t.Errorf("size = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseFit(t *testing.T) {
+	if f, err := pipeline.ParseFit(""); err != nil || f != pipeline.FitContain {
+		t.Errorf(`ParseFit("") = %q, %v; want contain`, f, err)
+	}
+	if _, err := pipeline.ParseFit("stretch"); err == nil {
+		t.Error("expected an error for an unknown fit")
+	}
+}
+
+func TestProcess(t *testing.T) {
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 64, 48)), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	p := pipeline.Pipeline{Transforms: []pipeline.Transform{pipeline.Resize{Width: 32}}, Quality: 40}
+	out, err := p.Process(buf.Bytes(), pipeline.Frame{})
+	if err != nil {
+		t.Fatalf("Process: %v", err)
+	}
+	cfg, err := jpeg.DecodeConfig(bytes.NewReader(out))
+	if err != nil {
+		t.Fatalf("decoding output: %v", err)
+	}
+	if cfg.Width != 32 || cfg.Height != 24 {
+		t.Errorf("output is %dx%d, want 32x24", cfg.Width, cfg.Height)
+	}
+
+	if _, err := p.Process([]byte("not a jpeg"), pipeline.Frame{}); err == nil {
+		t.Error("expected an error for a corrupt frame")
+	}
+}
+
+// countingSource returns the same frame every time, counting calls.
+type countingSource struct {
+	frame []byte
+	calls int
+}
+
+func (s *countingSource) NextFrame() ([]byte, error) {
+	s.calls++
+	return s.frame, nil
+}
+
+func (s *countingSource) Close() error { return nil }
+
+func TestWrapRendersRepeatedFrameOnce(t *testing.T) {
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 64, 48)), nil); err != nil {
+		t.Fatal(err)
+	}
+	inner := &countingSource{frame: buf.Bytes()}
+	src := pipeline.Wrap(inner, &pipeline.Pipeline{Transforms: []pipeline.Transform{pipeline.Rotate{Degrees: 90}}})
+
+	first, err := src.NextFrame()
+	if err != nil {
+		t.Fatalf("NextFrame: %v", err)
+	}
+	second, _ := src.NextFrame()
+	if &first[0] != &second[0] {
+		t.Error("expected a repeated frame to reuse its rendering")
+	}
+	cfg, err := jpeg.DecodeConfig(bytes.NewReader(first))
+	if err != nil || cfg.Width != 48 || cfg.Height != 64 {
+		t.Errorf("output is %dx%d (%v), want 48x64", cfg.Width, cfg.Height, err)
+	}
+	if got, ok := media.As[*countingSource](src); !ok || got != inner {
+		t.Error("expected media.As to find the wrapped source")
+	}
+}
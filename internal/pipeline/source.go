@@ -0,0 +1,66 @@
+package pipeline
+
+import (
+	"image"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/idevakk/mediastream/internal/media"
+)
+
+// source re-renders the frames of the Source it wraps.
+type source struct {
+	media.Source
+	pipe *Pipeline
+
+	mu     sync.Mutex
+	frames uint64      // frames rendered, for overlays
+	in     []byte      // last frame transformed
+	img    image.Image // its transformed image
+	texts  []string    // overlay texts last drawn on img
+	out    []byte      // the last frame returned
+	canvas *image.RGBA // reused for drawing overlays
+}
+
+// Wrap returns a Source that passes every frame of src through p. A frame
+// the source repeats, as a still image or a paused video does, is only
+// decoded and transformed once, and only re-encoded when an overlay's text
+// changes. Errors from src are passed on unchanged.
+func Wrap(src media.Source, p *Pipeline) media.Source {
+	return &source{Source: src, pipe: p}
+}
+
+// Unwrap returns the wrapped source, for media.As.
+func (s *source) Unwrap() media.Source { return s.Source }
+
+func (s *source) NextFrame() ([]byte, error) {
+	frame, err := s.Source.NextFrame()
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.frames++
+	texts := s.pipe.texts(Frame{Time: time.Now(), Number: s.frames})
+	// Sources repeat the very same slice for an unchanged frame.
+	same := len(s.in) > 0 && len(frame) == len(s.in) && &frame[0] == &s.in[0]
+	if same && slices.Equal(texts, s.texts) {
+		return s.out, nil
+	}
+	if !same {
+		img, err := s.pipe.transform(frame)
+		if err != nil {
+			return nil, err
+		}
+		s.in, s.img = frame, img
+	}
+	out, canvas, err := s.pipe.render(s.img, texts, s.canvas)
+	if err != nil {
+		return nil, err
+	}
+	s.canvas, s.texts, s.out = canvas, texts, out
+	return out, nil
+}
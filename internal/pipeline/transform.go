@@ -0,0 +1,147 @@
+package pipeline
+
+import (
+	"image"
+
+	"golang.org/x/image/draw"
+)
+
+// Crop cuts frames down to Rect, given relative to the frame's top-left
+// corner. The part of Rect outside the frame is ignored, and a Rect that
+// misses the frame entirely leaves it unchanged.
+type Crop struct {
+	Rect image.Rectangle
+}
+
+// Apply crops img to c.Rect.
+func (c Crop) Apply(img image.Image) image.Image {
+	b := img.Bounds()
+	r := c.Rect.Add(b.Min).Intersect(b)
+	if r.Empty() || r == b {
+		return img
+	}
+	if sub, ok := img.(interface {
+		SubImage(image.Rectangle) image.Image
+	}); ok {
+		return sub.SubImage(r)
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, r.Dx(), r.Dy()))
+	draw.Draw(dst, dst.Bounds(), img, r.Min, draw.Src)
+	return dst
+}
+
+// Rotate turns frames clockwise by Degrees, which must be a multiple of 90;
+// negative values turn anticlockwise. Other values leave frames unchanged.
+type Rotate struct {
+	Degrees int
+}
+
+// Apply rotates img by r.Degrees.
+func (r Rotate) Apply(img image.Image) image.Image {
+	deg := (r.Degrees%360 + 360) % 360
+	if deg == 0 || deg%90 != 0 {
+		return img
+	}
+	src := toRGBA(img)
+	b := src.Bounds()
+	w, h := b.Dx(), b.Dy()
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	if deg != 180 {
+		dst = image.NewRGBA(image.Rect(0, 0, h, w))
+	}
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var dx, dy int
+			switch deg {
+			case 90:
+				dx, dy = h-1-y, x
+			case 180:
+				dx, dy = w-1-x, h-1-y
+			default: // 270
+				dx, dy = y, w-1-x
+			}
+			copyPixel(dst, dx, dy, src, b.Min.X+x, b.Min.Y+y)
+		}
+	}
+	return dst
+}
+
+// Flip mirrors frames left to right, top to bottom, or both.
+type Flip struct {
+	Horizontal, Vertical bool
+}
+
+// Apply mirrors img as set by f.
+func (f Flip) Apply(img image.Image) image.Image {
+	if !f.Horizontal && !f.Vertical {
+		return img
+	}
+	src := toRGBA(img)
+	b := src.Bounds()
+	w, h := b.Dx(), b.Dy()
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			sx, sy := x, y
+			if f.Horizontal {
+				sx = w - 1 - x
+			}
+			if f.Vertical {
+				sy = h - 1 - y
+			}
+			copyPixel(dst, x, y, src, b.Min.X+sx, b.Min.Y+sy)
+		}
+	}
+	return dst
+}
+
+// Grayscale drops the colour from frames. The result keeps neutral chroma
+// planes rather than becoming a single-channel image, so it still encodes
+// as the three-component JPEG every consumer, including RTP/JPEG, accepts.
+type Grayscale struct{}
+
+// Apply returns img in shades of grey.
+func (Grayscale) Apply(img image.Image) image.Image {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	dst := image.NewYCbCr(image.Rect(0, 0, w, h), image.YCbCrSubsampleRatio420)
+	if m, ok := img.(*image.YCbCr); ok {
+		// The luma plane already is the grey image.
+		for y := 0; y < h; y++ {
+			i := m.YOffset(b.Min.X, b.Min.Y+y)
+			copy(dst.Y[y*dst.YStride:y*dst.YStride+w], m.Y[i:i+w])
+		}
+	} else {
+		src := toRGBA(img)
+		sb := src.Bounds()
+		for y := 0; y < h; y++ {
+			for x := 0; x < w; x++ {
+				p := src.Pix[src.PixOffset(sb.Min.X+x, sb.Min.Y+y):]
+				// The same weights as color.GrayModel.
+				lum := (19595*uint32(p[0]) + 38470*uint32(p[1]) + 7471*uint32(p[2]) + 1<<15) >> 16
+				dst.Y[y*dst.YStride+x] = uint8(lum)
+			}
+		}
+	}
+	for i := range dst.Cb {
+		dst.Cb[i], dst.Cr[i] = 128, 128
+	}
+	return dst
+}
+
+// toRGBA returns img as an *image.RGBA, converting it if necessary.
+func toRGBA(img image.Image) *image.RGBA {
+	if m, ok := img.(*image.RGBA); ok {
+		return m
+	}
+	b := img.Bounds()
+	m := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(m, m.Bounds(), img, b.Min, draw.Src)
+	return m
+}
+
+// copyPixel copies the pixel at (sx, sy) in src to (dx, dy) in dst.
+func copyPixel(dst *image.RGBA, dx, dy int, src *image.RGBA, sx, sy int) {
+	di, si := dst.PixOffset(dx, dy), src.PixOffset(sx, sy)
+	copy(dst.Pix[di:di+4], src.Pix[si:si+4])
+}
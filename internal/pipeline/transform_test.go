@@ -0,0 +1,107 @@
+package pipeline_test
+
+import (
+	"image"
+	"image/color"
+	"testing"
+
+	"github.com/idevakk/mediastream/internal/pipeline"
+)
+
+// marked returns a 4x2 image, black except for a red top-left pixel.
+func marked() *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, 4, 2))
+	for i := 3; i < len(img.Pix); i += 4 {
+		img.Pix[i] = 0xFF
+	}
+	img.Set(0, 0, color.RGBA{R: 0xFF, A: 0xFF})
+	return img
+}
+
+func isRed(img image.Image, x, y int) bool {
+	r, g, _, _ := img.At(x, y).RGBA()
+	return r > 0x8000 && g < 0x8000
+}
+
+func TestRotate(t *testing.T) {
+	tests := []struct {
+		degrees int
+		size    image.Point
+		red     image.Point // where the top-left pixel ends up
+	}{
+		{90, image.Pt(2, 4), image.Pt(1, 0)},
+		{180, image.Pt(4, 2), image.Pt(3, 1)},
+		{270, image.Pt(2, 4), image.Pt(0, 3)},
+		{-90, image.Pt(2, 4), image.Pt(0, 3)},
+	}
+	for _, tt := range tests {
+		out := pipeline.Rotate{Degrees: tt.degrees}.Apply(marked())
+		if got := out.Bounds().Size(); got != tt.size {
+			t.Errorf("%d°: size = %v, want %v", tt.degrees, got, tt.size)
+		}
+		if !isRed(out, tt.red.X, tt.red.Y) {
+			t.Errorf("%d°: expected the marked pixel at %v", tt.degrees, tt.red)
+		}
+	}
+}
+
+func TestFlip(t *testing.T) {
+	if out := (pipeline.Flip{Horizontal: true}).Apply(marked()); !isRed(out, 3, 0) {
+		t.Error("horizontal flip: expected the marked pixel at (3,0)")
+	}
+	if out := (pipeline.Flip{Vertical: true}).Apply(marked()); !isRed(out, 0, 1) {
+		t.Error("vertical flip: expected the marked pixel at (0,1)")
+	}
+	if out := (pipeline.Flip{Horizontal: true, Vertical: true}).Apply(marked()); !isRed(out, 3, 1) {
+		t.Error("flip both: expected the marked pixel at (3,1)")
+	}
+}
+
+func TestCrop(t *testing.T) {
+	out := pipeline.Crop{Rect: image.Rect(1, 0, 3, 2)}.Apply(marked())
+	if got := out.Bounds().Size(); got != image.Pt(2, 2) {
+		t.Errorf("size = %v, want (2,2)", got)
+	}
+	out = pipeline.Crop{Rect: image.Rect(3, 1, 10, 10)}.Apply(marked())
+	if got := out.Bounds().Size(); got != image.Pt(1, 1) {
+		t.Errorf("overhanging crop: size = %v, want (1,1)", got)
+	}
+	out = pipeline.Crop{Rect: image.Rect(10, 10, 20, 20)}.Apply(marked())
+	if got := out.Bounds().Size(); got != image.Pt(4, 2) {
+		t.Errorf("crop outside the frame: size = %v, want it unchanged", got)
+	}
+}
+
+func TestGrayscale(t *testing.T) {
+	for _, src := range []image.Image{
+		marked(),
+		image.NewYCbCr(image.Rect(0, 0, 4, 2), image.YCbCrSubsampleRatio420),
+	} {
+		out := pipeline.Grayscale{}.Apply(src)
+		if got := out.Bounds().Size(); got != image.Pt(4, 2) {
+			t.Errorf("%T: size = %v, want (4,2)", src, got)
+		}
+		r, g, b, _ := out.At(0, 0).RGBA()
+		if r != g || g != b {
+			t.Errorf("%T: pixel is not grey: %d,%d,%d", src, r, g, b)
+		}
+	}
+}
+
+func TestLetterbox(t *testing.T) {
+	src := image.NewRGBA(image.Rect(0, 0, 400, 200))
+	for i := range src.Pix {
+		src.Pix[i] = 0xFF
+	}
+	out := pipeline.Resize{Width: 100, Height: 100, Fit: pipeline.FitLetterbox}.Apply(src)
+	if got := out.Bounds().Size(); got != image.Pt(100, 100) {
+		t.Fatalf("size = %v, want (100,100)", got)
+	}
+	// A 100x50 picture centred between black bars.
+	if r, _, _, _ := out.At(50, 10).RGBA(); r != 0 {
+		t.Error("expected a black bar at the top")
+	}
+	if r, _, _, _ := out.At(50, 50).RGBA(); r == 0 {
+		t.Error("expected the picture in the middle")
+	}
+}
@@ -0,0 +1,81 @@
+package pipeline
+
+import (
+	"image"
+	"image/color"
+	"sync"
+
+	"golang.org/x/image/draw"
+)
+
+// Watermark composites a logo, with its alpha channel, onto frames. A
+// Watermark must not be copied after first use.
+type Watermark struct {
+	Image    image.Image
+	Position Position
+	// Scale is the logo's width as a fraction of the frame's width; zero
+	// draws it at its own size.
+	Scale float64
+	// Margin is the gap between the logo and the frame's edges, in pixels.
+	Margin int
+	// Opacity fades the logo, from 0 (invisible) to 1 (as drawn).
+	Opacity float64
+
+	mu        sync.Mutex
+	scaled    image.Image // Image scaled for frames scaledFor pixels wide
+	scaledFor int
+}
+
+// Apply returns a copy of img with the logo drawn on it.
+func (wm *Watermark) Apply(img image.Image) image.Image {
+	b := img.Bounds()
+	dst := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(dst, dst.Bounds(), img, b.Min, draw.Src)
+
+	logo := wm.logoFor(b.Dx())
+	lb := logo.Bounds()
+	w, h, m := lb.Dx(), lb.Dy(), wm.Margin
+	var x, y int
+	ax, ay := wm.Position.anchor()
+	switch ax {
+	case -1:
+		x = m
+	case 0:
+		x = (b.Dx() - w) / 2
+	default:
+		x = b.Dx() - m - w
+	}
+	switch ay {
+	case -1:
+		y = m
+	case 0:
+		y = (b.Dy() - h) / 2
+	default:
+		y = b.Dy() - m - h
+	}
+
+	mask := image.NewUniform(color.Alpha16{A: uint16(wm.Opacity * 0xFFFF)})
+	draw.DrawMask(dst, image.Rect(x, y, x+w, y+h), logo, lb.Min, mask, image.Point{}, draw.Over)
+	return dst
+}
+
+// logoFor returns the logo sized for a frame width pixels wide, scaling
+// it once per frame width.
+func (wm *Watermark) logoFor(width int) image.Image {
+	wm.mu.Lock()
+	defer wm.mu.Unlock()
+
+	if wm.Scale == 0 {
+		return wm.Image
+	}
+	if wm.scaled != nil && wm.scaledFor == width {
+		return wm.scaled
+	}
+	lb := wm.Image.Bounds()
+	w := scaled(float64(width) * wm.Scale)
+	h := scaled(float64(lb.Dy()) * float64(w) / float64(lb.Dx()))
+	logo := image.NewNRGBA(image.Rect(0, 0, w, h))
+	draw.CatmullRom.Scale(logo, logo.Bounds(), wm.Image, lb, draw.Src, nil)
+	wm.scaled, wm.scaledFor = logo, width
+	return logo
+}
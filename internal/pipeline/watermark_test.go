@@ -0,0 +1,50 @@
+package pipeline_test
+
+import (
+	"image"
+	"image/color"
+	"testing"
+
+	"github.com/idevakk/mediastream/internal/pipeline"
+)
+
+// logo returns a 10x10 logo, opaque red on the left half and transparent
+// on the right.
+func logo() image.Image {
+	img := image.NewNRGBA(image.Rect(0, 0, 10, 10))
+	for y := 0; y < 10; y++ {
+		for x := 0; x < 5; x++ {
+			img.Set(x, y, color.NRGBA{R: 0xFF, A: 0xFF})
+		}
+	}
+	return img
+}
+
+func TestWatermark(t *testing.T) {
+	frame := image.NewRGBA(image.Rect(0, 0, 100, 100))
+	wm := &pipeline.Watermark{Image: logo(), Position: pipeline.BottomRight, Scale: 0.2, Margin: 5, Opacity: 1}
+	out := wm.Apply(frame)
+
+	// Scaled to 20x20, the logo covers (75,75)-(95,95).
+	if r, _, _, _ := out.At(78, 85).RGBA(); r < 0xF000 {
+		t.Errorf("expected the opaque half of the logo at (78,85), got red %#x", r)
+	}
+	if r, _, _, _ := out.At(92, 85).RGBA(); r != 0 {
+		t.Errorf("expected the transparent half to leave the frame black, got red %#x", r)
+	}
+	if r, _, _, _ := out.At(50, 50).RGBA(); r != 0 {
+		t.Error("expected the rest of the frame untouched")
+	}
+	if r, _, _, _ := frame.At(78, 85).RGBA(); r != 0 {
+		t.Error("expected the input frame not to be modified")
+	}
+}
+
+func TestWatermarkOpacity(t *testing.T) {
+	frame := image.NewRGBA(image.Rect(0, 0, 40, 40))
+	wm := &pipeline.Watermark{Image: logo(), Position: pipeline.TopLeft, Opacity: 0.5}
+	r, _, _, _ := wm.Apply(frame).At(2, 2).RGBA()
+	if r < 0x7000 || r > 0x9000 {
+		t.Errorf("expected half-strength red at 50%% opacity, got %#x", r)
+	}
+}
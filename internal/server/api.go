@@ -0,0 +1,315 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/idevakk/mediastream/internal/media"
+)
+
+// Errors returned by the playback control methods.
+var (
+	ErrUnknownStream = errors.New("unknown stream")
+	ErrNotSeekable   = errors.New("source does not support seeking")
+
+	ErrNoPlaybackControl = errors.New("source does not support clip or speed changes")
+
+	// ErrSourceSwitchDisabled is returned by POST /api/source unless
+	// Config.SourceRoot is set.
+	ErrSourceSwitchDisabled = errors.New("switching sources over the API is disabled; start the server with --source-root")
+
+	errNotInSourceRoot = errors.New("file not found in the source root")
+)
+
+// StreamStatus describes the playback state of one stream.
+type StreamStatus struct {
+	Name     string `json:"name"`
+	FilePath string `json:"file"`
+	Paused   bool   `json:"paused"`
+	Seekable bool   `json:"seekable"`
+	// Playback reports whether clip bounds and speed can be changed.
+	Playback bool    `json:"playback"`
+	Speed    float64 `json:"speed"`
+}
+
+// channel returns the named stream; "" selects the default stream.
+func (s *Server) channel(stream string) (*channel, error) {
+	if stream == "" {
+		return s.channels[0], nil
+	}
+	ch, ok := s.byName[stream]
+	if !ok {
+		return nil, fmt.Errorf("%w %q", ErrUnknownStream, stream)
+	}
+	return ch, nil
+}
+
+// Pause freezes the named stream on its current frame. Connected clients
+// stay attached and keep receiving that frame. "" selects the default stream.
+func (s *Server) Pause(stream string) error {
+	ch, err := s.channel(stream)
+	if err != nil {
+		return err
+	}
+	ch.hub.setPaused(true)
+	return nil
+}
+
+// Resume continues playback of a paused stream.
+func (s *Server) Resume(stream string) error {
+	ch, err := s.channel(stream)
+	if err != nil {
+		return err
+	}
+	ch.hub.setPaused(false)
+	return nil
+}
+
+// Seek moves the named stream to pos. It returns ErrNotSeekable for
+// sources without a timeline, such as still images.
+func (s *Server) Seek(stream string, pos time.Duration) error {
+	ch, err := s.channel(stream)
+	if err != nil {
+		return err
+	}
+	return ch.seek(pos)
+}
+
+// SetSource switches the named stream to a different file without
+// disconnecting its viewers.
+func (s *Server) SetSource(stream, path string) error {
+	ch, err := s.channel(stream)
+	if err != nil {
+		return err
+	}
+	return ch.setSource(path)
+}
+
+// SetClip limits the named stream to a looping sub-clip [start, end); an
+// end of zero plays to the end of the file.
+func (s *Server) SetClip(stream string, start, end time.Duration) error {
+	ch, err := s.channel(stream)
+	if err != nil {
+		return err
+	}
+	return ch.setClip(start, end)
+}
+
+// SetSpeed changes the playback speed of the named stream, from
+// media.MinSpeed to media.MaxSpeed.
+func (s *Server) SetSpeed(stream string, speed float64) error {
+	ch, err := s.channel(stream)
+	if err != nil {
+		return err
+	}
+	return ch.setSpeed(speed)
+}
+
+// Status returns the playback state of every stream.
+func (s *Server) Status() []StreamStatus {
+	out := make([]StreamStatus, len(s.channels))
+	for i, ch := range s.channels {
+		out[i] = ch.status()
+	}
+	return out
+}
+
+// handleAPI serves the JSON control API:
+//
+//	GET  /api/status
+//	POST /api/pause?stream=<name>
+//	POST /api/resume?stream=<name>
+//	POST /api/seek?stream=<name>&t=<seconds>
+//	POST /api/clip?stream=<name>&start=<seconds>&end=<seconds>
+//	POST /api/speed?stream=<name>&x=<factor>
+//	POST /api/source?stream=<name>   body: {"file": "<path>"}
+//
+// stream may be omitted to control the default stream. The API is
+// unauthenticated, so /api/source only opens files under Config.SourceRoot
+// and its errors don't name any path.
+func (s *Server) handleAPI(w http.ResponseWriter, r *http.Request) {
+	action := r.URL.Path[len("/api/"):]
+	stream := r.URL.Query().Get("stream")
+
+	if action == "status" {
+		if r.Method != http.MethodGet {
+			writeAPIError(w, http.StatusMethodNotAllowed, errors.New("use GET"))
+			return
+		}
+		writeJSON(w, http.StatusOK, s.Status())
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		writeAPIError(w, http.StatusMethodNotAllowed, errors.New("use POST"))
+		return
+	}
+
+	var err error
+	switch action {
+	case "pause":
+		err = s.Pause(stream)
+	case "resume":
+		err = s.Resume(stream)
+	case "seek":
+		secs, perr := strconv.ParseFloat(r.URL.Query().Get("t"), 64)
+		if perr != nil || !validSeconds(secs) {
+			writeAPIError(w, http.StatusBadRequest, errors.New("t must be a non-negative number of seconds"))
+			return
+		}
+		err = s.Seek(stream, time.Duration(secs*float64(time.Second)))
+	case "clip":
+		start, serr := parseSeconds(r.URL.Query().Get("start"))
+		end, eerr := parseSeconds(r.URL.Query().Get("end"))
+		if serr != nil || eerr != nil {
+			writeAPIError(w, http.StatusBadRequest, errors.New("start and end must be non-negative numbers of seconds"))
+			return
+		}
+		err = s.SetClip(stream, start, end)
+	case "speed":
+		x, perr := strconv.ParseFloat(r.URL.Query().Get("x"), 64)
+		if perr != nil {
+			writeAPIError(w, http.StatusBadRequest, errors.New("x must be a number"))
+			return
+		}
+		err = s.SetSpeed(stream, x)
+	case "source":
+		var body struct {
+			File string `json:"file"`
+		}
+		if r.ContentLength != 0 {
+			if derr := json.NewDecoder(r.Body).Decode(&body); derr != nil {
+				writeAPIError(w, http.StatusBadRequest, fmt.Errorf("invalid JSON body: %w", derr))
+				return
+			}
+		}
+		if body.File == "" {
+			body.File = r.URL.Query().Get("file")
+		}
+		if body.File == "" {
+			writeAPIError(w, http.StatusBadRequest, errors.New("file is required"))
+			return
+		}
+		path, perr := s.sourcePath(body.File)
+		if errors.Is(perr, ErrSourceSwitchDisabled) {
+			writeAPIError(w, http.StatusForbidden, perr)
+			return
+		} else if perr != nil {
+			writeAPIError(w, http.StatusBadRequest, perr)
+			return
+		}
+		if err = s.SetSource(stream, path); err != nil && !errors.Is(err, ErrUnknownStream) {
+			writeSourceError(w, err)
+			return
+		}
+	default:
+		writeAPIError(w, http.StatusNotFound, fmt.Errorf("unknown action %q", action))
+		return
+	}
+
+	switch {
+	case errors.Is(err, ErrUnknownStream):
+		writeAPIError(w, http.StatusNotFound, err)
+	case errors.As(err, new(*media.UnsupportedFormatError)):
+		writeAPIError(w, http.StatusUnsupportedMediaType, err)
+	case err != nil:
+		writeAPIError(w, http.StatusBadRequest, err)
+	default:
+		ch, _ := s.channel(stream)
+		writeJSON(w, http.StatusOK, ch.status())
+	}
+}
+
+// sourcePath resolves a file requested over the API, absolute or relative
+// to Config.SourceRoot, and checks that it exists inside the root once
+// symlinks are followed. Missing and outside files get the same error, so
+// the API can't be used to probe the rest of the filesystem.
+func (s *Server) sourcePath(file string) (string, error) {
+	root := s.cfg.SourceRoot
+	if root == "" {
+		return "", ErrSourceSwitchDisabled
+	}
+	if !filepath.IsAbs(file) {
+		file = filepath.Join(root, file)
+	}
+	path, err := filepath.EvalSymlinks(file)
+	if err != nil {
+		return "", errNotInSourceRoot
+	}
+	rel, err := filepath.Rel(root, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", errNotInSourceRoot
+	}
+	return path, nil
+}
+
+// resolveSourceRoot returns the absolute, symlink-free form of root, which
+// must be a directory.
+func resolveSourceRoot(root string) (string, error) {
+	abs, err := filepath.Abs(root)
+	if err == nil {
+		abs, err = filepath.EvalSymlinks(abs)
+	}
+	if err != nil {
+		return "", fmt.Errorf("invalid source root %q: %w", root, err)
+	}
+	if fi, err := os.Stat(abs); err != nil || !fi.IsDir() {
+		return "", fmt.Errorf("invalid source root %q: not a directory", root)
+	}
+	return abs, nil
+}
+
+// writeSourceError reports a file that couldn't be switched to. Media
+// errors name the file, and those of playlists and directories other files
+// too, so only the detected format of an unplayable file is passed on.
+func writeSourceError(w http.ResponseWriter, err error) {
+	var ufe *media.UnsupportedFormatError
+	if !errors.As(err, &ufe) {
+		writeAPIError(w, http.StatusBadRequest, errors.New("file could not be opened"))
+		return
+	}
+	what := ufe.MIMEType
+	if what == "" {
+		what = fmt.Sprintf("file type %q", strings.ToLower(filepath.Ext(ufe.Path)))
+	}
+	writeAPIError(w, http.StatusUnsupportedMediaType, fmt.Errorf(
+		"unsupported format %s — supported formats: %s", what, strings.Join(media.SupportedExtensions, ", "),
+	))
+}
+
+// parseSeconds parses an optional, non-negative number of seconds.
+func parseSeconds(v string) (time.Duration, error) {
+	if v == "" {
+		return 0, nil
+	}
+	secs, err := strconv.ParseFloat(v, 64)
+	if err != nil || !validSeconds(secs) {
+		return 0, fmt.Errorf("invalid seconds %q", v)
+	}
+	return time.Duration(secs * float64(time.Second)), nil
+}
+
+// validSeconds reports whether secs is a usable position or duration:
+// ParseFloat accepts "NaN" and "Inf", which don't convert to a
+// time.Duration.
+func validSeconds(secs float64) bool {
+	return !math.IsNaN(secs) && !math.IsInf(secs, 0) && secs >= 0
+}
+
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v) //nolint:errcheck
+}
+
+func writeAPIError(w http.ResponseWriter, code int, err error) {
+	writeJSON(w, code, map[string]string{"error": err.Error()})
+}
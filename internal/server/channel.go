@@ -0,0 +1,305 @@
+package server
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/idevakk/mediastream/internal/media"
+)
+
+// channel is one named stream hosted by a Server. Each channel owns its
+// own media source (held by its hub), so channels play independently of
+// each other.
+type channel struct {
+	cfg StreamConfig
+	hub *hub
+	hls *hlsSegmenter // nil unless HLS output is enabled
+
+	mu       sync.Mutex
+	filePath string  // current source; changes with setSource
+	speed    float64 // current playback speed; changes with setSpeed
+	variants map[string]*variant
+}
+
+// openChannel opens the media source described by cfg. cfg must already
+// have its defaults applied, and hlsCfg must be validated if enabled.
+func openChannel(cfg StreamConfig, hlsCfg HLSConfig) (*channel, error) {
+	src, err := cfg.open()
+	if err != nil {
+		return nil, fmt.Errorf("opening media for stream %q: %w", cfg.Name, err)
+	}
+	ch := &channel{
+		cfg:      cfg,
+		hub:      newHub(src, cfg.FrameRate, cfg.ClientQueue),
+		filePath: cfg.source(),
+		speed:    cfg.Speed,
+	}
+
+	if hlsCfg.Enabled {
+		ch.hls, err = newHLSSegmenter(hlsCfg, cfg.Name, cfg.FrameRate, ch.hub)
+		if err != nil {
+			src.Close()
+			return nil, fmt.Errorf("preparing HLS for stream %q: %w", cfg.Name, err)
+		}
+	}
+	return ch, nil
+}
+
+// close releases the media source and any HLS files on disk.
+func (c *channel) close() error {
+	err := c.hub.currentSource().Close()
+	if c.hls != nil {
+		if hlsErr := c.hls.close(); err == nil {
+			err = hlsErr
+		}
+	}
+	return err
+}
+
+// setSource opens path and switches the channel to it. Connected clients
+// stay attached and simply start receiving frames from the new source.
+// The new file plays at the stream's current speed, from its beginning.
+func (c *channel) setSource(path string) error {
+	c.mu.Lock()
+	speed := c.speed
+	c.mu.Unlock()
+
+	opts := append([]media.Option{media.WithSpeed(speed)}, c.cfg.renderOptions()...)
+	src, err := media.Open(path, c.cfg.FrameRate, opts...)
+	if err != nil {
+		return fmt.Errorf("opening media for stream %q: %w", c.cfg.Name, err)
+	}
+	src = c.cfg.wrap(src)
+
+	c.mu.Lock()
+	c.filePath = path
+	c.mu.Unlock()
+
+	old := c.hub.setSource(src)
+	if c.hub.isPaused() {
+		c.hub.publishNext() // show the new source even while paused
+	}
+	return old.Close()
+}
+
+// seek moves playback to pos if the current source supports it.
+func (c *channel) seek(pos time.Duration) error {
+	sk, ok := media.As[media.Seeker](c.hub.currentSource())
+	if !ok {
+		return ErrNotSeekable
+	}
+	if err := sk.Seek(pos); err != nil {
+		return err
+	}
+	if c.hub.isPaused() {
+		c.hub.publishNext() // show the new position even while paused
+	}
+	return nil
+}
+
+// setClip limits playback to [start, end) if the source supports it.
+func (c *channel) setClip(start, end time.Duration) error {
+	p, ok := media.As[media.Player](c.hub.currentSource())
+	if !ok {
+		return ErrNoPlaybackControl
+	}
+	return p.SetClip(start, end)
+}
+
+// setSpeed changes the playback speed if the source supports it.
+func (c *channel) setSpeed(speed float64) error {
+	p, ok := media.As[media.Player](c.hub.currentSource())
+	if !ok {
+		return ErrNoPlaybackControl
+	}
+	if err := p.SetSpeed(speed); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	c.speed = speed
+	c.mu.Unlock()
+	return nil
+}
+
+func (c *channel) currentFile() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.filePath
+}
+
+// status reports the channel's playback state.
+func (c *channel) status() StreamStatus {
+	src := c.hub.currentSource()
+	_, seekable := media.As[media.Seeker](src)
+	_, player := media.As[media.Player](src)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return StreamStatus{
+		Name:     c.cfg.Name,
+		FilePath: c.filePath,
+		Paused:   c.hub.isPaused(),
+		Seekable: seekable,
+		Speed:    c.speed,
+		Playback: player,
+	}
+}
+
+// serveStream outputs an MJPEG stream. Frames come from the channel's hub,
+// so every client sees the same playback position. Query parameters w, h,
+// fit, q and fps ask for a resized, re-encoded or slower variant, which is
+// shared by every client asking for the same one.
+func (c *channel) serveStream(w http.ResponseWriter, r *http.Request) {
+	spec, isVariant, err := parseVariant(r.URL.Query(), c.cfg.FrameRate)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	h := c.hub
+	if isVariant {
+		v := c.acquireVariant(spec)
+		defer c.releaseVariant(v)
+		h = v.hub
+	}
+
+	w.Header().Set("Content-Type", "multipart/x-mixed-replace; boundary=mjpegframe")
+	w.Header().Set("Cache-Control", "no-cache, no-store, must-revalidate")
+	w.Header().Set("Connection", "keep-alive")
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported by this client", http.StatusInternalServerError)
+		return
+	}
+	if c.hub.inOutage() {
+		w.Header().Set("Retry-After", "1")
+		http.Error(w, "stream source is unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	rc := http.NewResponseController(w)
+
+	sub := h.subscribe(r.RemoteAddr)
+	defer h.unsubscribe(sub)
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case frame, ok := <-sub.frames:
+			if !ok {
+				if sub.reset.Load() {
+					// Drop the connection without ending the multipart
+					// body, the way a camera that lost power would.
+					panic(http.ErrAbortHandler)
+				}
+				return
+			}
+
+			// A client that can't accept a frame within the timeout is
+			// dropped; the rest of the viewers are unaffected either way.
+			rc.SetWriteDeadline(time.Now().Add(clientWriteTimeout)) //nolint:errcheck
+
+			n, _ := fmt.Fprintf(w, "--mjpegframe\r\nContent-Type: image/jpeg\r\nContent-Length: %d\r\n\r\n", len(frame))
+			if _, err := w.Write(frame); err != nil {
+				return
+			}
+			fmt.Fprintf(w, "\r\n")
+			flusher.Flush()
+			sub.delivered(n + len(frame) + 2)
+		}
+	}
+}
+
+// serveHLS serves the HLS playlist and segments; file is the path below
+// the stream's /hls/ prefix.
+func (c *channel) serveHLS(w http.ResponseWriter, r *http.Request, file string) {
+	if c.hls == nil {
+		http.Error(w, "HLS output is not enabled", http.StatusNotFound)
+		return
+	}
+	c.hls.serve(w, r, file)
+}
+
+// serveSnapshot returns the frame currently being broadcast as a single
+// JPEG. Frames from a static source carry an ETag so clients can revalidate
+// cheaply; everything else is marked uncacheable.
+func (c *channel) serveSnapshot(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if c.hub.inOutage() {
+		w.Header().Set("Retry-After", "1")
+		http.Error(w, "stream source is unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	frame := c.hub.latestFrame()
+	if frame == nil {
+		w.Header().Set("Retry-After", "1")
+		http.Error(w, "no frame available yet", http.StatusServiceUnavailable)
+		return
+	}
+
+	w.Header().Set("Content-Type", "image/jpeg")
+	if st, ok := media.As[media.Static](c.hub.currentSource()); ok && st.IsStatic() {
+		w.Header().Set("ETag", fmt.Sprintf(`"%x"`, sha1.Sum(frame)))
+		w.Header().Set("Cache-Control", "no-cache")
+	} else {
+		w.Header().Set("Cache-Control", "no-cache, no-store, must-revalidate")
+	}
+
+	// ServeContent takes care of Content-Length, HEAD and If-None-Match.
+	http.ServeContent(w, r, "snapshot.jpg", time.Time{}, bytes.NewReader(frame))
+}
+
+// streamHealth is the per-stream section of the /health response.
+type streamHealth struct {
+	Name      string        `json:"name"`
+	FilePath  string        `json:"file"`
+	FrameRate int           `json:"fps"`
+	Path      string        `json:"path"`
+	HLSPath   string        `json:"hls_path,omitempty"`
+	Clients   []ClientStats `json:"clients"`
+	// Outage is true while fault injection has taken the source down.
+	Outage bool `json:"outage,omitempty"`
+	// Source reports process restarts and errors for sources that can
+	// fail at runtime, such as FFmpeg-backed video.
+	Source *media.Health `json:"source,omitempty"`
+}
+
+// clients returns stats for the clients of the stream and of its variants.
+func (c *channel) clients() []ClientStats {
+	stats := c.hub.clients()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, v := range c.variants {
+		stats = append(stats, v.hub.clients()...)
+	}
+	return stats
+}
+
+func (c *channel) health() streamHealth {
+	sh := streamHealth{
+		Name:      c.cfg.Name,
+		FilePath:  c.currentFile(),
+		FrameRate: c.cfg.FrameRate,
+		Path:      "/streams/" + c.cfg.Name,
+		Clients:   c.clients(),
+		Outage:    c.hub.inOutage(),
+	}
+	if c.hls != nil {
+		sh.HLSPath = sh.Path + "/hls/index.m3u8"
+	}
+	if m, ok := media.As[media.Monitor](c.hub.currentSource()); ok {
+		h := m.Health()
+		sh.Source = &h
+	}
+	return sh
+}
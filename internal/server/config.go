@@ -0,0 +1,355 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"image/color"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/idevakk/mediastream/internal/media"
+	"github.com/idevakk/mediastream/internal/pipeline"
+)
+
+// DefaultStreamName is the name given to the stream built from
+// Config.FilePath when no explicit Streams are configured.
+const DefaultStreamName = "default"
+
+// StreamConfig describes one named stream hosted by a Server.
+type StreamConfig struct {
+	// Name identifies the stream in URLs: /streams/<Name>.
+	Name     string `json:"name"`
+	FilePath string `json:"file,omitempty"`
+	// Files, if set instead of FilePath, plays several files in turn.
+	Files []string `json:"files,omitempty"`
+	// FrameRate is the target frames-per-second for this stream.
+	// Defaults to Config.FrameRate if zero.
+	FrameRate int `json:"fps,omitempty"`
+	// ClientQueue is how many frames are buffered per client before the
+	// oldest is dropped. Defaults to Config.ClientQueue if zero.
+	ClientQueue int `json:"client_queue,omitempty"`
+	// Start and End, in seconds, limit video playback to a looping
+	// sub-clip. Zero means the start and end of the file.
+	Start float64 `json:"start,omitempty"`
+	End   float64 `json:"end,omitempty"`
+	// Speed is the video playback speed, 0.25 to 4. Defaults to 1 if zero.
+	Speed float64 `json:"speed,omitempty"`
+	// Matte is the "#RRGGBB" color that transparent GIF pixels are
+	// flattened onto. Defaults to Config.Matte, then black.
+	Matte string `json:"matte,omitempty"`
+
+	// Playlist controls playlists, whether from Files or from an .m3u or
+	// .txt FilePath. Defaults to Config.Playlist if unset.
+	Playlist PlaylistConfig `json:"playlist"`
+
+	// Faults, if set, injects camera faults into the stream for
+	// resilience testing. Defaults to Config.Faults.
+	Faults *FaultConfig `json:"faults,omitempty"`
+
+	// Transform, if set, resizes, crops, rotates, flips or greys out
+	// every frame of the stream. Defaults to Config.Transform.
+	Transform *TransformConfig `json:"transform,omitempty"`
+	// Watermark, if set, composites a logo onto every frame, after
+	// Transform. Defaults to Config.Watermark.
+	Watermark *WatermarkConfig `json:"watermark,omitempty"`
+	// Overlays are drawn over every frame, after Transform and Watermark.
+	// Defaults to Config.Overlays.
+	Overlays []OverlayConfig `json:"overlays,omitempty"`
+
+	matte       color.Color        // parsed Matte, set by Config.streams
+	pipe        *pipeline.Pipeline // built from Transform, Watermark and Overlays by Config.streams
+	cacheBudget int64              // from Config.SequenceCacheMB
+}
+
+// PlaylistConfig holds the settings of a stream that plays several files.
+type PlaylistConfig struct {
+	// ImageDuration is how many seconds each still image is shown.
+	// Defaults to 5 if zero.
+	ImageDuration float64 `json:"image_duration,omitempty"`
+	// GIFLoops is how many times each animated GIF plays. Defaults to 1.
+	GIFLoops int  `json:"gif_loops,omitempty"`
+	Shuffle  bool `json:"shuffle,omitempty"`
+	// Once stops on the last frame after one pass instead of repeating
+	// the whole list.
+	Once bool `json:"once,omitempty"`
+	// Sort orders the files of a directory slideshow: "name" (default)
+	// or "mtime" for oldest first.
+	Sort string `json:"sort,omitempty"`
+}
+
+// sortOrder converts Sort to a media.SortOrder.
+func (pc PlaylistConfig) sortOrder() (media.SortOrder, error) {
+	switch pc.Sort {
+	case "", "name":
+		return media.SortByName, nil
+	case "mtime":
+		return media.SortByModTime, nil
+	default:
+		return 0, fmt.Errorf("invalid playlist sort %q: use name or mtime", pc.Sort)
+	}
+}
+
+// options converts the playlist settings to media options.
+func (pc PlaylistConfig) options() []media.Option {
+	order, _ := pc.sortOrder() // validated by Config.streams
+	opts := []media.Option{
+		media.WithShuffle(pc.Shuffle),
+		media.WithRepeat(!pc.Once),
+		media.WithSort(order),
+	}
+	if pc.ImageDuration != 0 {
+		opts = append(opts, media.WithImageDuration(seconds(pc.ImageDuration)))
+	}
+	if pc.GIFLoops != 0 {
+		opts = append(opts, media.WithGIFLoops(pc.GIFLoops))
+	}
+	return opts
+}
+
+// open opens the stream's source: a playlist of Files if set, otherwise
+// FilePath.
+func (sc StreamConfig) open() (media.Source, error) {
+	var src media.Source
+	var err error
+	if len(sc.Files) > 0 {
+		src, err = media.OpenPlaylist(sc.Files, sc.FrameRate, sc.mediaOptions()...)
+	} else {
+		src, err = media.Open(sc.FilePath, sc.FrameRate, sc.mediaOptions()...)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return sc.wrap(src), nil
+}
+
+// wrap applies the stream's transforms and fault injection, if any, to
+// src. Faults go on the outside, as a camera's would reach its viewers.
+func (sc StreamConfig) wrap(src media.Source) media.Source {
+	if sc.pipe != nil {
+		src = pipeline.Wrap(src, sc.pipe)
+	}
+	if sc.Faults == nil {
+		return src
+	}
+	return media.WithFaults(src, sc.Faults.media())
+}
+
+// source describes what the stream plays, for status and health output.
+func (sc StreamConfig) source() string {
+	if len(sc.Files) > 0 {
+		return strings.Join(sc.Files, ", ")
+	}
+	return sc.FilePath
+}
+
+// mediaOptions converts the stream's playback settings to media options.
+func (sc StreamConfig) mediaOptions() []media.Option {
+	return append([]media.Option{
+		media.WithStart(seconds(sc.Start)),
+		media.WithEnd(seconds(sc.End)),
+		media.WithSpeed(sc.Speed),
+	}, sc.renderOptions()...)
+}
+
+// renderOptions returns the media options that apply to every file the
+// stream plays, including ones switched to at runtime.
+func (sc StreamConfig) renderOptions() []media.Option {
+	opts := sc.Playlist.options()
+	if sc.cacheBudget > 0 {
+		opts = append(opts, media.WithCacheBudget(sc.cacheBudget))
+	}
+	if sc.matte != nil {
+		opts = append(opts, media.WithMatte(sc.matte))
+	}
+	return opts
+}
+
+// parseHexColor parses an opaque "#RRGGBB" or "RRGGBB" color.
+func parseHexColor(s string) (color.Color, error) {
+	hex := strings.TrimPrefix(s, "#")
+	v, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil || len(hex) != 6 {
+		return nil, fmt.Errorf("invalid color %q: expected #RRGGBB", s)
+	}
+	return color.RGBA{R: uint8(v >> 16), G: uint8(v >> 8), B: uint8(v), A: 255}, nil
+}
+
+// seconds converts a JSON/CLI seconds value to a time.Duration.
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
+
+// validStreamName restricts names to characters that are safe in a URL path.
+var validStreamName = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// LoadConfig reads a JSON config file such as:
+//
+//	{
+//	  "port": 8080,
+//	  "fps": 30,
+//	  "streams": [
+//	    {"name": "lobby", "file": "lobby.mp4"},
+//	    {"name": "door",  "file": "door.jpg", "fps": 5}
+//	  ]
+//	}
+func LoadConfig(path string) (Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Config{}, fmt.Errorf("reading config %q: %w", path, err)
+	}
+
+	var cfg Config
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return Config{}, fmt.Errorf("parsing config %q: %w", path, err)
+	}
+	if err := cfg.validateRates(); err != nil {
+		return Config{}, fmt.Errorf("config %q: %w", path, err)
+	}
+	return cfg, nil
+}
+
+// validateRates rejects negative frame rates and client queues, at the
+// top level and per stream. Zero means the default.
+func (cfg Config) validateRates() error {
+	check := func(what string, fps, queue int) error {
+		if fps < 0 {
+			return fmt.Errorf("%sinvalid fps %d: must be at least 1", what, fps)
+		}
+		if queue < 0 {
+			return fmt.Errorf("%sinvalid client_queue %d: must be at least 1", what, queue)
+		}
+		return nil
+	}
+	if err := check("", cfg.FrameRate, cfg.ClientQueue); err != nil {
+		return err
+	}
+	for _, sc := range cfg.Streams {
+		if err := check(fmt.Sprintf("stream %q: ", sc.Name), sc.FrameRate, sc.ClientQueue); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ParseStreamSpec parses a command-line stream definition of the form
+// NAME=PATH or NAME@FPS=PATH, e.g. "lobby=lobby.mp4" or "door@5=door.jpg".
+func ParseStreamSpec(spec string) (StreamConfig, error) {
+	name, path, ok := strings.Cut(spec, "=")
+	if !ok || path == "" {
+		return StreamConfig{}, fmt.Errorf("invalid stream %q: expected NAME=PATH", spec)
+	}
+
+	sc := StreamConfig{Name: name, FilePath: path}
+	if n, fps, ok := strings.Cut(name, "@"); ok {
+		rate, err := strconv.Atoi(fps)
+		if err != nil || rate < 1 {
+			return StreamConfig{}, fmt.Errorf("invalid frame rate %q in stream %q", fps, spec)
+		}
+		sc.Name, sc.FrameRate = n, rate
+	}
+	return sc, nil
+}
+
+// streams returns the stream list with defaults applied, validating names.
+// A config without Streams yields a single stream built from FilePath.
+func (cfg Config) streams() ([]StreamConfig, error) {
+	list := cfg.Streams
+	if len(list) == 0 {
+		list = []StreamConfig{{
+			Name:      DefaultStreamName,
+			FilePath:  cfg.FilePath,
+			Files:     cfg.Files,
+			Start:     cfg.Start,
+			End:       cfg.End,
+			Speed:     cfg.Speed,
+			Faults:    cfg.Faults,
+			Transform: cfg.Transform,
+			Watermark: cfg.Watermark,
+			Overlays:  cfg.Overlays,
+		}}
+	}
+
+	seen := make(map[string]bool, len(list))
+	out := make([]StreamConfig, 0, len(list))
+	for _, sc := range list {
+		if !validStreamName.MatchString(sc.Name) {
+			return nil, fmt.Errorf("invalid stream name %q: use letters, digits, '-' or '_'", sc.Name)
+		}
+		if seen[sc.Name] {
+			return nil, fmt.Errorf("duplicate stream name %q", sc.Name)
+		}
+		seen[sc.Name] = true
+
+		if sc.FrameRate == 0 {
+			sc.FrameRate = cfg.FrameRate
+		}
+		if sc.ClientQueue == 0 {
+			sc.ClientQueue = cfg.ClientQueue
+		}
+		if sc.Speed == 0 {
+			sc.Speed = 1
+		}
+		if sc.FilePath != "" && len(sc.Files) > 0 {
+			return nil, fmt.Errorf("stream %q: set either file or files, not both", sc.Name)
+		}
+		if sc.Playlist == (PlaylistConfig{}) {
+			sc.Playlist = cfg.Playlist
+		}
+		if _, err := sc.Playlist.sortOrder(); err != nil {
+			return nil, fmt.Errorf("stream %q: %w", sc.Name, err)
+		}
+		sc.cacheBudget = int64(cfg.SequenceCacheMB) << 20
+		if sc.Faults == nil {
+			sc.Faults = cfg.Faults
+		}
+		if sc.Faults != nil {
+			if err := sc.Faults.validate(); err != nil {
+				return nil, fmt.Errorf("stream %q: %w", sc.Name, err)
+			}
+		}
+		if sc.Transform == nil {
+			sc.Transform = cfg.Transform
+		}
+		if sc.Watermark == nil {
+			sc.Watermark = cfg.Watermark
+		}
+		if len(sc.Overlays) == 0 {
+			sc.Overlays = cfg.Overlays
+		}
+		if sc.Transform != nil || sc.Watermark != nil || len(sc.Overlays) > 0 {
+			pipe := &pipeline.Pipeline{}
+			var err error
+			if sc.Transform != nil {
+				if pipe, err = sc.Transform.pipeline(); err != nil {
+					return nil, fmt.Errorf("stream %q: %w", sc.Name, err)
+				}
+			}
+			if sc.Watermark != nil {
+				wm, err := sc.Watermark.watermark()
+				if err != nil {
+					return nil, fmt.Errorf("stream %q: %w", sc.Name, err)
+				}
+				pipe.Transforms = append(pipe.Transforms, wm)
+			}
+			if pipe.Overlays, err = overlays(sc.Overlays); err != nil {
+				return nil, fmt.Errorf("stream %q: %w", sc.Name, err)
+			}
+			sc.pipe = pipe
+		}
+		if sc.Matte == "" {
+			sc.Matte = cfg.Matte
+		}
+		if sc.Matte != "" {
+			c, err := parseHexColor(sc.Matte)
+			if err != nil {
+				return nil, fmt.Errorf("stream %q: %w", sc.Name, err)
+			}
+			sc.matte = c
+		}
+		out = append(out, sc)
+	}
+	return out, nil
+}
@@ -0,0 +1,122 @@
+package server
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/idevakk/mediastream/internal/media"
+)
+
+// FaultConfig makes a stream misbehave like unreliable camera hardware,
+// for testing how clients cope. Each fault is scheduled independently.
+type FaultConfig struct {
+	// Seed makes random faults repeat exactly from run to run. Zero
+	// picks a different seed each time.
+	Seed     int64     `json:"seed,omitempty"`
+	Freeze   FaultSpec `json:"freeze"`
+	Drop     FaultSpec `json:"drop"`
+	Latency  FaultSpec `json:"latency"`
+	Corrupt  FaultSpec `json:"corrupt"`
+	Truncate FaultSpec `json:"truncate"`
+	Reset    FaultSpec `json:"reset"`
+	Outage   FaultSpec `json:"outage"`
+}
+
+// FaultSpec schedules one kind of fault.
+type FaultSpec struct {
+	// Every fires the fault at a fixed interval, in seconds.
+	Every float64 `json:"every,omitempty"`
+	// Chance fires the fault at random, with this probability per frame.
+	Chance float64 `json:"chance,omitempty"`
+	// For is how many seconds a freeze, latency spike or outage lasts.
+	For float64 `json:"for,omitempty"`
+}
+
+// faultKinds names the faults in the order they appear in FaultConfig.
+var faultKinds = []string{"freeze", "drop", "latency", "corrupt", "truncate", "reset", "outage"}
+
+// specs returns pointers to each FaultSpec, in faultKinds order.
+func (fc *FaultConfig) specs() []*FaultSpec {
+	return []*FaultSpec{&fc.Freeze, &fc.Drop, &fc.Latency, &fc.Corrupt, &fc.Truncate, &fc.Reset, &fc.Outage}
+}
+
+func (fc FaultConfig) validate() error {
+	for i, f := range fc.specs() {
+		if !validSeconds(f.Every) || !validSeconds(f.For) {
+			return fmt.Errorf("invalid %s fault: durations must be finite and not negative", faultKinds[i])
+		}
+		if math.IsNaN(f.Chance) || f.Chance < 0 || f.Chance > 1 {
+			return fmt.Errorf("invalid %s fault: chance must be between 0 and 1", faultKinds[i])
+		}
+	}
+	return nil
+}
+
+// media converts the config to a media.FaultConfig.
+func (fc FaultConfig) media() media.FaultConfig {
+	spec := func(f FaultSpec) media.FaultSpec {
+		return media.FaultSpec{Every: seconds(f.Every), Chance: f.Chance, For: seconds(f.For)}
+	}
+	return media.FaultConfig{
+		Seed:     fc.Seed,
+		Freeze:   spec(fc.Freeze),
+		Drop:     spec(fc.Drop),
+		Latency:  spec(fc.Latency),
+		Corrupt:  spec(fc.Corrupt),
+		Truncate: spec(fc.Truncate),
+		Reset:    spec(fc.Reset),
+		Outage:   spec(fc.Outage),
+	}
+}
+
+// ParseFaults parses a command-line fault schedule: a comma-separated
+// list of seed=N and KIND.every, KIND.chance or KIND.for settings, e.g.
+// "seed=42,drop.chance=0.05,outage.every=60,outage.for=10".
+func ParseFaults(spec string) (*FaultConfig, error) {
+	var fc FaultConfig
+	for _, item := range strings.Split(spec, ",") {
+		key, val, ok := strings.Cut(strings.TrimSpace(item), "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid fault setting %q: expected KEY=VALUE", item)
+		}
+		if key == "seed" {
+			seed, err := strconv.ParseInt(val, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid fault seed %q", val)
+			}
+			fc.Seed = seed
+			continue
+		}
+
+		kind, field, _ := strings.Cut(key, ".")
+		var f *FaultSpec
+		for i, k := range faultKinds {
+			if k == kind {
+				f = fc.specs()[i]
+			}
+		}
+		if f == nil {
+			return nil, fmt.Errorf("unknown fault %q — available faults: %s", kind, strings.Join(faultKinds, ", "))
+		}
+		v, err := strconv.ParseFloat(val, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid fault setting %q: %q is not a number", item, val)
+		}
+		switch field {
+		case "every":
+			f.Every = v
+		case "chance":
+			f.Chance = v
+		case "for":
+			f.For = v
+		default:
+			return nil, fmt.Errorf("invalid fault setting %q: use %s.every, %s.chance or %s.for", item, kind, kind, kind)
+		}
+	}
+	if err := fc.validate(); err != nil {
+		return nil, err
+	}
+	return &fc, nil
+}
@@ -0,0 +1,179 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// HLSConfig enables HLS output next to the MJPEG stream. FFmpeg reads the
+// frames a stream is already broadcasting, encodes them to H.264 and writes
+// a rolling window of segments that the server then serves over HTTP.
+type HLSConfig struct {
+	Enabled bool `json:"enabled"`
+	// SegmentType is "mpegts" (default) or "fmp4".
+	SegmentType string `json:"segment_type,omitempty"`
+	// SegmentSeconds is the target segment duration. Defaults to 2.
+	SegmentSeconds int `json:"segment_seconds,omitempty"`
+	// ListSize is how many segments the playlist keeps. Defaults to 6.
+	ListSize int `json:"list_size,omitempty"`
+}
+
+// hlsRestartDelay is how long to wait before restarting a segmenter whose
+// FFmpeg process exited unexpectedly.
+const hlsRestartDelay = time.Second
+
+// hlsFiles matches every file name the segmenter writes; nothing else in
+// its directory is ever served.
+var hlsFiles = regexp.MustCompile(`^(index\.m3u8|init\.mp4|segment_\d+\.(ts|m4s))$`)
+
+// withDefaults validates cfg and fills in zero values.
+func (cfg HLSConfig) withDefaults() (HLSConfig, error) {
+	switch cfg.SegmentType {
+	case "":
+		cfg.SegmentType = "mpegts"
+	case "mpegts", "fmp4":
+	default:
+		return cfg, fmt.Errorf("invalid HLS segment type %q: use mpegts or fmp4", cfg.SegmentType)
+	}
+	if cfg.SegmentSeconds == 0 {
+		cfg.SegmentSeconds = 2
+	}
+	if cfg.ListSize == 0 {
+		cfg.ListSize = 6
+	}
+	if _, err := exec.LookPath("ffmpeg"); err != nil {
+		return cfg, fmt.Errorf("ffmpeg not found in PATH — please install FFmpeg to enable HLS output: %w", err)
+	}
+	return cfg, nil
+}
+
+// hlsSegmenter feeds one channel's frames into FFmpeg's HLS muxer.
+type hlsSegmenter struct {
+	cfg       HLSConfig
+	frameRate int
+	hub       *hub
+	dir       string
+}
+
+// newHLSSegmenter creates the temporary directory that will hold the
+// playlist and segments for the named stream.
+func newHLSSegmenter(cfg HLSConfig, name string, frameRate int, h *hub) (*hlsSegmenter, error) {
+	dir, err := os.MkdirTemp("", "mediastream-hls-"+name+"-")
+	if err != nil {
+		return nil, fmt.Errorf("creating HLS directory: %w", err)
+	}
+	return &hlsSegmenter{cfg: cfg, frameRate: frameRate, hub: h, dir: dir}, nil
+}
+
+// run keeps an FFmpeg segmenter alive until ctx is cancelled.
+func (s *hlsSegmenter) run(ctx context.Context) {
+	for {
+		s.segment(ctx) //nolint:errcheck
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(hlsRestartDelay):
+		}
+	}
+}
+
+// segment runs a single FFmpeg process, piping hub frames to its stdin
+// until ctx is cancelled or the process dies.
+func (s *hlsSegmenter) segment(ctx context.Context) error {
+	ext := "ts"
+	if s.cfg.SegmentType == "fmp4" {
+		ext = "m4s"
+	}
+	gop := strconv.Itoa(s.frameRate * s.cfg.SegmentSeconds)
+
+	// image2pipe reads the back-to-back JPEGs we write to stdin; every
+	// segment starts on a keyframe so segments are independently decodable.
+	cmd := exec.CommandContext(ctx, "ffmpeg",
+		"-hide_banner", "-loglevel", "error",
+		"-f", "image2pipe",
+		"-framerate", strconv.Itoa(s.frameRate),
+		"-c:v", "mjpeg",
+		"-i", "-",
+		"-vf", "scale=trunc(iw/2)*2:trunc(ih/2)*2", // libx264 needs even dimensions
+		"-c:v", "libx264",
+		"-preset", "veryfast",
+		"-tune", "zerolatency",
+		"-pix_fmt", "yuv420p",
+		"-g", gop, "-keyint_min", gop, "-sc_threshold", "0",
+		"-f", "hls",
+		"-hls_time", strconv.Itoa(s.cfg.SegmentSeconds),
+		"-hls_list_size", strconv.Itoa(s.cfg.ListSize),
+		"-hls_flags", "delete_segments+independent_segments+omit_endlist",
+		"-hls_segment_type", s.cfg.SegmentType,
+		"-hls_fmp4_init_filename", "init.mp4",
+		"-hls_segment_filename", filepath.Join(s.dir, "segment_%05d."+ext),
+		filepath.Join(s.dir, "index.m3u8"),
+	)
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return fmt.Errorf("creating ffmpeg stdin pipe: %w", err)
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("starting ffmpeg: %w", err)
+	}
+
+	sub := s.hub.subscribeFeed("hls")
+	defer s.hub.unsubscribe(sub)
+
+	for frame := range sub.frames {
+		if _, err := stdin.Write(frame); err != nil {
+			break
+		}
+	}
+	stdin.Close()
+	return cmd.Wait()
+}
+
+// close removes the playlist and every segment from disk.
+func (s *hlsSegmenter) close() error {
+	return os.RemoveAll(s.dir)
+}
+
+// serve handles requests for the playlist and its segments. file is the
+// path below the stream's /hls/ prefix.
+func (s *hlsSegmenter) serve(w http.ResponseWriter, r *http.Request, file string) {
+	if !hlsFiles.MatchString(file) {
+		http.NotFound(w, r)
+		return
+	}
+
+	path := filepath.Join(s.dir, file)
+	if _, err := os.Stat(path); err != nil {
+		if file == "index.m3u8" {
+			// FFmpeg hasn't finished the first segment yet.
+			w.Header().Set("Retry-After", strconv.Itoa(s.cfg.SegmentSeconds))
+			http.Error(w, "playlist not ready yet", http.StatusServiceUnavailable)
+			return
+		}
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	switch filepath.Ext(file) {
+	case ".m3u8":
+		w.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
+		w.Header().Set("Cache-Control", "no-cache, no-store, must-revalidate")
+	case ".ts":
+		w.Header().Set("Content-Type", "video/mp2t")
+	case ".m4s":
+		w.Header().Set("Content-Type", "video/iso.segment")
+	case ".mp4":
+		w.Header().Set("Content-Type", "video/mp4")
+	}
+	http.ServeFile(w, r, path)
+}
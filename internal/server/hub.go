@@ -0,0 +1,359 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/idevakk/mediastream/internal/media"
+)
+
+// hub pulls frames from a single media.Source at a fixed rate and fans each
+// frame out to every subscriber. Because there is exactly one reader per
+// source, every viewer sees the same playback position no matter how many
+// clients are connected.
+type hub struct {
+	interval time.Duration
+	queueLen int
+
+	mu     sync.Mutex
+	source media.Source
+	paused bool
+	subs   map[*subscriber]struct{}
+	latest []byte
+	closed bool
+	outage bool   // the source reported media.ErrOutage
+	resets uint64 // times viewers were disconnected by resetClients
+
+	// variant labels the clients of a re-rendered variant's hub.
+	variant string
+
+	produced     atomic.Uint64  // frames read from the source
+	sourceErrors atomic.Uint64  // failed reads, not counting injected faults
+	traffic      *traffic       // shared with the hubs of the stream's variants
+	ids          *atomic.Uint64 // last client ID, shared like traffic
+}
+
+// traffic totals what a stream has delivered to its clients, including
+// clients that have since disconnected.
+type traffic struct {
+	sent, dropped, bytes atomic.Uint64
+}
+
+// subscriber receives frames published by a hub through a bounded queue.
+// When the queue is full the oldest frame is discarded, so a slow client
+// always catches up to the newest frame instead of stalling the producer.
+type subscriber struct {
+	id        uint64
+	remote    string
+	connected time.Time
+	frames    chan []byte
+	feed      bool // an internal consumer, such as HLS, that is never reset
+	variant   string
+
+	// reset is set when frames was closed by a connection reset rather
+	// than a shutdown, so the client should be cut off abruptly.
+	reset atomic.Bool
+
+	sent    atomic.Uint64
+	dropped atomic.Uint64
+	bytes   atomic.Uint64
+	traffic *traffic
+}
+
+// ClientStats is a point-in-time snapshot of one connected client.
+type ClientStats struct {
+	ID        uint64    `json:"id"`
+	Remote    string    `json:"remote"`
+	Connected time.Time `json:"connected"`
+	Sent      uint64    `json:"frames_sent"`
+	Dropped   uint64    `json:"frames_dropped"`
+	Bytes     uint64    `json:"bytes_sent"`
+	// Variant describes the re-rendering the client asked for, if any.
+	Variant string `json:"variant,omitempty"`
+}
+
+// newHub creates a hub that reads from src at frameRate frames per second
+// and queues up to queueLen frames per subscriber. The producer goroutine
+// is not started until run is called.
+func newHub(src media.Source, frameRate, queueLen int) *hub {
+	return &hub{
+		source:   src,
+		interval: time.Duration(float64(time.Second) / float64(frameRate)),
+		queueLen: queueLen,
+		subs:     make(map[*subscriber]struct{}),
+		traffic:  &traffic{},
+		ids:      new(atomic.Uint64),
+	}
+}
+
+// run is the producer loop. It reads one frame per tick and publishes it to
+// all subscribers until ctx is cancelled, then closes every subscription.
+func (h *hub) run(ctx context.Context) {
+	defer h.shutdown()
+
+	ticker := time.NewTicker(h.interval)
+	defer ticker.Stop()
+
+	for {
+		h.mu.Lock()
+		paused, latest := h.paused, h.latest
+		h.mu.Unlock()
+
+		if paused {
+			// Keep viewers fed with the frozen frame, like a paused camera.
+			if latest != nil {
+				h.publish(latest)
+			}
+		} else {
+			h.publishNext()
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// publishNext reads one frame from the current source and publishes it.
+// A failed read is skipped; clients stay connected and simply keep the
+// last frame they received. Injected resets and outages disconnect them.
+func (h *hub) publishNext() {
+	frame, err := h.currentSource().NextFrame()
+	switch {
+	case err == nil:
+		h.produced.Add(1)
+		h.mu.Lock()
+		h.outage = false
+		h.mu.Unlock()
+		h.publish(frame)
+	case errors.Is(err, media.ErrOutage):
+		h.mu.Lock()
+		h.outage = true
+		h.mu.Unlock()
+		h.resetClients()
+	case errors.Is(err, media.ErrConnectionReset):
+		h.resetClients()
+	case !errors.Is(err, media.ErrFrameDropped):
+		h.sourceErrors.Add(1)
+	}
+}
+
+// resetClients disconnects every viewer, marking each subscription as
+// reset so handlers abort their connections instead of ending cleanly.
+// Feeds stay subscribed.
+func (h *hub) resetClients() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.resets++
+	for sub := range h.subs {
+		if sub.feed {
+			continue
+		}
+		sub.reset.Store(true)
+		close(sub.frames)
+		delete(h.subs, sub)
+	}
+}
+
+// resetCount returns how many times viewers have been reset.
+func (h *hub) resetCount() uint64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.resets
+}
+
+// inOutage reports whether the source is in an injected outage.
+func (h *hub) inOutage() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.outage
+}
+
+// currentSource returns the source frames are being read from.
+func (h *hub) currentSource() media.Source {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.source
+}
+
+// setSource switches the hub to src and returns the previous source, which
+// the caller must close. Subscribers stay connected across the switch.
+func (h *hub) setSource(src media.Source) media.Source {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	old := h.source
+	h.source = src
+	if p, ok := media.As[media.Pauser](src); ok && h.paused {
+		p.Pause()
+	}
+	return old
+}
+
+// setPaused suspends or resumes reading from the source. While paused the
+// last frame keeps being broadcast.
+func (h *hub) setPaused(paused bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.paused == paused {
+		return
+	}
+	h.paused = paused
+	if p, ok := media.As[media.Pauser](h.source); ok {
+		if paused {
+			p.Pause()
+		} else {
+			p.Resume()
+		}
+	}
+}
+
+// isPaused reports whether playback is paused.
+func (h *hub) isPaused() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.paused
+}
+
+// publish records frame as the latest one and queues it for every
+// subscriber. It never blocks on a slow client.
+func (h *hub) publish(frame []byte) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.latest = frame
+	for sub := range h.subs {
+		sub.offer(frame)
+	}
+}
+
+// subscribe registers a new subscriber identified by remote (usually the
+// client address). If a frame has already been produced it is queued
+// immediately so new viewers don't wait a full tick.
+func (h *hub) subscribe(remote string) *subscriber {
+	return h.add(remote, false)
+}
+
+// subscribeFeed is like subscribe for internal consumers that must keep
+// receiving frames across injected connection resets. Feeds are not
+// viewers, so they are left out of clients and of the stream's traffic.
+func (h *hub) subscribeFeed(remote string) *subscriber {
+	return h.add(remote, true)
+}
+
+func (h *hub) add(remote string, feed bool) *subscriber {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	sub := &subscriber{
+		id:        h.ids.Add(1),
+		remote:    remote,
+		connected: time.Now(),
+		frames:    make(chan []byte, h.queueLen),
+		feed:      feed,
+		variant:   h.variant,
+		traffic:   h.traffic,
+	}
+	if feed {
+		sub.traffic = &traffic{}
+	}
+
+	if h.closed {
+		close(sub.frames)
+		return sub
+	}
+	if h.latest != nil {
+		sub.frames <- h.latest
+	}
+	h.subs[sub] = struct{}{}
+	return sub
+}
+
+// latestFrame returns the most recently published frame, or nil if the
+// producer hasn't produced one yet.
+func (h *hub) latestFrame() []byte {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.latest
+}
+
+// unsubscribe removes sub from the hub. It is safe to call more than once.
+func (h *hub) unsubscribe(sub *subscriber) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.subs, sub)
+}
+
+// clients returns stats for every current subscriber, ordered by ID.
+func (h *hub) clients() []ClientStats {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	stats := make([]ClientStats, 0, len(h.subs))
+	for sub := range h.subs {
+		if !sub.feed {
+			stats = append(stats, sub.stats())
+		}
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].ID < stats[j].ID })
+	return stats
+}
+
+// shutdown closes every subscription and rejects new ones.
+func (h *hub) shutdown() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.closed = true
+	for sub := range h.subs {
+		close(sub.frames)
+		delete(h.subs, sub)
+	}
+}
+
+// offer queues frame, discarding the oldest queued frames until it fits.
+// Only the hub's producer sends on frames, so the loop always terminates.
+func (s *subscriber) offer(frame []byte) {
+	for {
+		select {
+		case s.frames <- frame:
+			return
+		default:
+		}
+
+		select {
+		case <-s.frames:
+			s.dropped.Add(1)
+			s.traffic.dropped.Add(1)
+		default:
+		}
+	}
+}
+
+// delivered records that a frame of n bytes reached the client.
+func (s *subscriber) delivered(n int) {
+	s.sent.Add(1)
+	s.bytes.Add(uint64(n))
+	s.traffic.sent.Add(1)
+	s.traffic.bytes.Add(uint64(n))
+}
+
+func (s *subscriber) stats() ClientStats {
+	return ClientStats{
+		ID:        s.id,
+		Remote:    s.remote,
+		Connected: s.connected,
+		Sent:      s.sent.Load(),
+		Dropped:   s.dropped.Load(),
+		Bytes:     s.bytes.Load(),
+		Variant:   s.variant,
+	}
+}
@@ -0,0 +1,120 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// counterSource returns a distinct frame on every call so tests can tell
+// whether frames are shared or consumed separately.
+type counterSource struct {
+	mu    sync.Mutex
+	calls int
+}
+
+func (s *counterSource) NextFrame() ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.calls++
+	return []byte(fmt.Sprintf("frame-%d", s.calls)), nil
+}
+
+func (s *counterSource) Close() error { return nil }
+
+func TestHubSharesTimelineAcrossSubscribers(t *testing.T) {
+	src := &counterSource{}
+	h := newHub(src, 50, 2)
+
+	a := h.subscribe("test")
+	b := h.subscribe("test")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go h.run(ctx)
+	defer cancel()
+
+	for i := 0; i < 5; i++ {
+		fa := <-a.frames
+		fb := <-b.frames
+		if string(fa) != string(fb) {
+			t.Fatalf("frame %d: subscribers diverged: %q vs %q", i, fa, fb)
+		}
+	}
+}
+
+func TestHubClosesSubscribersOnShutdown(t *testing.T) {
+	h := newHub(&counterSource{}, 50, 2)
+	sub := h.subscribe("test")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go h.run(ctx)
+	cancel()
+
+	deadline := time.After(time.Second)
+	for {
+		select {
+		case _, ok := <-sub.frames:
+			if !ok {
+				return
+			}
+		case <-deadline:
+			t.Fatal("subscription was not closed after shutdown")
+		}
+	}
+}
+
+func TestHubDropsOldestFramesForSlowSubscriber(t *testing.T) {
+	h := newHub(&counterSource{}, 50, 2)
+	sub := h.subscribe("slow")
+
+	for i := 1; i <= 5; i++ {
+		h.publish([]byte(fmt.Sprintf("frame-%d", i)))
+	}
+
+	if got := sub.dropped.Load(); got != 3 {
+		t.Fatalf("expected 3 dropped frames, got %d", got)
+	}
+	for _, want := range []string{"frame-4", "frame-5"} {
+		if got := string(<-sub.frames); got != want {
+			t.Fatalf("expected %q, got %q", want, got)
+		}
+	}
+
+	stats := h.clients()
+	if len(stats) != 1 || stats[0].Remote != "slow" || stats[0].Dropped != 3 {
+		t.Fatalf("unexpected client stats: %+v", stats)
+	}
+}
+
+func TestVariantPassesOnEveryFrameOnce(t *testing.T) {
+	parent := newHub(&counterSource{}, 50, 2)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go parent.run(ctx)
+
+	src := newVariantSource(parent)
+	defer src.Close()
+	v := newHub(src, 50, 2)
+	sub := v.subscribe("viewer")
+	go v.run(ctx)
+
+	// Polling the parent on an unaligned ticker repeats some frames and
+	// skips others; the relay must hand over each one in order.
+	last, repeats := 0, 0
+	for i := 0; i < 50; i++ {
+		var n int
+		fmt.Sscanf(string(<-sub.frames), "frame-%d", &n) //nolint:errcheck
+		if n <= last {
+			repeats++
+		}
+		last = n
+	}
+	if repeats > 0 {
+		t.Fatalf("variant repeated %d of 50 frames", repeats)
+	}
+	if clients := parent.clients(); len(clients) != 0 {
+		t.Fatalf("the relay should not be listed as a client: %+v", clients)
+	}
+}
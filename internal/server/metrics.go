@@ -0,0 +1,84 @@
+package server
+
+import (
+	"bytes"
+	"net/http"
+	"strconv"
+
+	"github.com/idevakk/mediastream/internal/media"
+	"github.com/idevakk/mediastream/internal/metrics"
+)
+
+// handleMetrics serves stream, client and frame processing metrics in
+// the Prometheus text format.
+func (s *Server) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	var buf bytes.Buffer
+	s.writeMetrics(metrics.NewWriter(&buf))
+
+	w.Header().Set("Content-Type", metrics.ContentType)
+	w.Write(buf.Bytes()) //nolint:errcheck
+}
+
+// writeMetrics writes every metric family, one stream after another
+// within each.
+func (s *Server) writeMetrics(mw *metrics.Writer) {
+	type streamMetric struct {
+		name, typ, help string
+		value           func(*channel) float64
+	}
+	for _, m := range []streamMetric{
+		{"mediastream_clients", "gauge", "Clients connected to the stream, including its variants.",
+			func(ch *channel) float64 { return float64(len(ch.clients())) }},
+		{"mediastream_frames_produced_total", "counter", "Frames read from the stream's source.",
+			func(ch *channel) float64 { return float64(ch.hub.produced.Load()) }},
+		{"mediastream_frames_sent_total", "counter", "Frames delivered to the stream's clients.",
+			func(ch *channel) float64 { return float64(ch.hub.traffic.sent.Load()) }},
+		{"mediastream_frames_dropped_total", "counter", "Frames discarded because a client fell behind.",
+			func(ch *channel) float64 { return float64(ch.hub.traffic.dropped.Load()) }},
+		{"mediastream_bytes_sent_total", "counter", "Bytes delivered to the stream's clients.",
+			func(ch *channel) float64 { return float64(ch.hub.traffic.bytes.Load()) }},
+		{"mediastream_source_errors_total", "counter", "Failed reads from the stream's source.",
+			func(ch *channel) float64 { return float64(ch.hub.sourceErrors.Load()) }},
+	} {
+		mw.Header(m.name, m.typ, m.help)
+		for _, ch := range s.channels {
+			mw.Sample(m.name, m.value(ch), "stream", ch.cfg.Name)
+		}
+	}
+
+	mw.Header("mediastream_source_restarts_total", "counter",
+		"Restarts of the stream's FFmpeg process or upstream connection since its source was opened.")
+	for _, ch := range s.channels {
+		if mon, ok := media.As[media.Monitor](ch.hub.currentSource()); ok {
+			mw.Sample("mediastream_source_restarts_total", float64(mon.Health().Restarts), "stream", ch.cfg.Name)
+		}
+	}
+
+	type clientMetric struct {
+		name, help string
+		value      func(ClientStats) uint64
+	}
+	clients := make(map[string][]ClientStats, len(s.channels))
+	for _, ch := range s.channels {
+		clients[ch.cfg.Name] = ch.clients()
+	}
+	for _, m := range []clientMetric{
+		{"mediastream_client_frames_sent_total", "Frames delivered to a connected client.",
+			func(c ClientStats) uint64 { return c.Sent }},
+		{"mediastream_client_frames_dropped_total", "Frames discarded because a connected client fell behind.",
+			func(c ClientStats) uint64 { return c.Dropped }},
+		{"mediastream_client_bytes_sent_total", "Bytes delivered to a connected client.",
+			func(c ClientStats) uint64 { return c.Bytes }},
+	} {
+		mw.Header(m.name, "counter", m.help)
+		for _, ch := range s.channels {
+			for _, c := range clients[ch.cfg.Name] {
+				mw.Sample(m.name, float64(m.value(c)),
+					"stream", ch.cfg.Name, "client", strconv.FormatUint(c.ID, 10), "remote", c.Remote, "variant", c.Variant)
+			}
+		}
+	}
+
+	mw.HistogramVec("mediastream_decode_seconds", "Time taken to decode an image or frame.", "stage", metrics.DecodeSeconds)
+	mw.HistogramVec("mediastream_encode_seconds", "Time taken to encode a JPEG frame.", "stage", metrics.EncodeSeconds)
+}
@@ -0,0 +1,218 @@
+package server
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	_ "time/tzdata" // time zones for overlays on systems without a zoneinfo database
+
+	"github.com/idevakk/mediastream/internal/pipeline"
+)
+
+// defaultTimestampFormat is the strftime format of timestamp overlays
+// that don't set one.
+const defaultTimestampFormat = "%Y-%m-%d %H:%M:%S"
+
+// OverlayConfig burns a line of text into every frame of a stream, like
+// the on-screen display of a CCTV camera.
+type OverlayConfig struct {
+	// Type is timestamp (the wall-clock time), label (fixed Text) or
+	// counter (the frame number, after Text if set).
+	Type string `json:"type"`
+	Text string `json:"text,omitempty"`
+	// Format is the strftime format of a timestamp, such as
+	// "%d/%m/%Y %H:%M:%S". Defaults to "%Y-%m-%d %H:%M:%S".
+	Format string `json:"format,omitempty"`
+	// TimeZone is the IANA time zone of a timestamp, such as
+	// "Europe/Berlin". Defaults to the local time zone.
+	TimeZone string `json:"timezone,omitempty"`
+	// Position is top-left, top, top-right, left, center, right,
+	// bottom-left, bottom or bottom-right. Defaults to top-left for a
+	// timestamp, bottom-left for a label and top-right for a counter.
+	Position string `json:"position,omitempty"`
+	// Size is the font height in pixels. Defaults to a twentieth of the
+	// frame height.
+	Size int `json:"size,omitempty"`
+	// Color is the "#RRGGBB" text color. Defaults to white.
+	Color string `json:"color,omitempty"`
+	// Box draws a semi-transparent box behind the text.
+	Box bool `json:"box,omitempty"`
+}
+
+// defaultPositions places each type of overlay when Position is unset.
+var defaultPositions = map[string]pipeline.Position{
+	"timestamp": pipeline.TopLeft,
+	"label":     pipeline.BottomLeft,
+	"counter":   pipeline.TopRight,
+}
+
+// text validates the config and builds the overlay it describes.
+func (oc OverlayConfig) text() (*pipeline.Text, error) {
+	t := &pipeline.Text{Size: oc.Size, Box: oc.Box}
+
+	switch oc.Type {
+	case "timestamp":
+		loc := time.Local
+		if oc.TimeZone != "" {
+			var err error
+			if loc, err = time.LoadLocation(oc.TimeZone); err != nil {
+				return nil, fmt.Errorf("invalid overlay time zone %q: %w", oc.TimeZone, err)
+			}
+		}
+		format := oc.Format
+		if format == "" {
+			format = defaultTimestampFormat
+		}
+		if _, err := strftime(format, time.Time{}); err != nil {
+			return nil, err
+		}
+		t.Content = func(f pipeline.Frame) string {
+			s, _ := strftime(format, f.Time.In(loc))
+			return s
+		}
+	case "label":
+		if oc.Text == "" {
+			return nil, fmt.Errorf("label overlay needs text")
+		}
+		text := oc.Text
+		t.Content = func(pipeline.Frame) string { return text }
+	case "counter":
+		prefix := oc.Text
+		t.Content = func(f pipeline.Frame) string { return prefix + strconv.FormatUint(f.Number, 10) }
+	default:
+		return nil, fmt.Errorf("invalid overlay type %q: use timestamp, label or counter", oc.Type)
+	}
+
+	t.Position = defaultPositions[oc.Type]
+	if oc.Position != "" {
+		pos, err := pipeline.ParsePosition(oc.Position)
+		if err != nil {
+			return nil, err
+		}
+		t.Position = pos
+	}
+	if oc.Size < 0 || oc.Size > 1000 {
+		return nil, fmt.Errorf("invalid overlay size %d: must be 1 to 1000 pixels", oc.Size)
+	}
+	if oc.Color != "" {
+		c, err := parseHexColor(oc.Color)
+		if err != nil {
+			return nil, err
+		}
+		t.Color = c
+	}
+	return t, nil
+}
+
+// ParseOverlay parses a command-line overlay: its type followed by
+// comma-separated KEY=VALUE settings named after the JSON fields, and box
+// on its own to draw a box, e.g.
+// "timestamp,format=%H:%M:%S,timezone=UTC,position=bottom-right,size=24,box".
+func ParseOverlay(spec string) (OverlayConfig, error) {
+	items := strings.Split(spec, ",")
+	oc := OverlayConfig{Type: strings.TrimSpace(items[0])}
+	for _, item := range items[1:] {
+		key, val, ok := strings.Cut(strings.TrimSpace(item), "=")
+		switch {
+		case key == "box" && !ok:
+			oc.Box = true
+		case !ok:
+			return OverlayConfig{}, fmt.Errorf("invalid overlay setting %q: expected KEY=VALUE", item)
+		case key == "text":
+			oc.Text = val
+		case key == "format":
+			oc.Format = val
+		case key == "timezone":
+			oc.TimeZone = val
+		case key == "position":
+			oc.Position = val
+		case key == "color":
+			oc.Color = val
+		case key == "size":
+			n, err := strconv.Atoi(val)
+			if err != nil {
+				return OverlayConfig{}, fmt.Errorf("invalid overlay size %q", val)
+			}
+			oc.Size = n
+		default:
+			return OverlayConfig{}, fmt.Errorf("unknown overlay setting %q: use text, format, timezone, position, size, color or box", key)
+		}
+	}
+	if _, err := oc.text(); err != nil {
+		return OverlayConfig{}, err
+	}
+	return oc, nil
+}
+
+// overlays builds the pipeline overlays for a list of configs.
+func overlays(list []OverlayConfig) ([]*pipeline.Text, error) {
+	texts := make([]*pipeline.Text, 0, len(list))
+	for _, oc := range list {
+		t, err := oc.text()
+		if err != nil {
+			return nil, err
+		}
+		texts = append(texts, t)
+	}
+	return texts, nil
+}
+
+// strftime formats t like C's strftime. It supports the common
+// directives: %Y %y %m %d %e %j %H %I %M %S %L (milliseconds) %p %a %A %b
+// %B %Z %z and %%.
+func strftime(format string, t time.Time) (string, error) {
+	var b strings.Builder
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' {
+			b.WriteByte(format[i])
+			continue
+		}
+		if i++; i == len(format) {
+			return "", fmt.Errorf("invalid time format %q: ends with %%", format)
+		}
+		switch format[i] {
+		case 'Y':
+			fmt.Fprintf(&b, "%04d", t.Year())
+		case 'y':
+			fmt.Fprintf(&b, "%02d", t.Year()%100)
+		case 'm':
+			fmt.Fprintf(&b, "%02d", int(t.Month()))
+		case 'd':
+			fmt.Fprintf(&b, "%02d", t.Day())
+		case 'e':
+			fmt.Fprintf(&b, "%2d", t.Day())
+		case 'j':
+			fmt.Fprintf(&b, "%03d", t.YearDay())
+		case 'H':
+			fmt.Fprintf(&b, "%02d", t.Hour())
+		case 'I':
+			fmt.Fprintf(&b, "%02d", (t.Hour()+11)%12+1)
+		case 'M':
+			fmt.Fprintf(&b, "%02d", t.Minute())
+		case 'S':
+			fmt.Fprintf(&b, "%02d", t.Second())
+		case 'L':
+			fmt.Fprintf(&b, "%03d", t.Nanosecond()/int(time.Millisecond))
+		case 'p':
+			b.WriteString(t.Format("PM"))
+		case 'a':
+			b.WriteString(t.Format("Mon"))
+		case 'A':
+			b.WriteString(t.Format("Monday"))
+		case 'b':
+			b.WriteString(t.Format("Jan"))
+		case 'B':
+			b.WriteString(t.Format("January"))
+		case 'Z':
+			b.WriteString(t.Format("MST"))
+		case 'z':
+			b.WriteString(t.Format("-0700"))
+		case '%':
+			b.WriteByte('%')
+		default:
+			return "", fmt.Errorf("invalid time format %q: unknown directive %%%c", format, format[i])
+		}
+	}
+	return b.String(), nil
+}
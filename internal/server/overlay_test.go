@@ -0,0 +1,53 @@
+package server
+
+import (
+	"testing"
+	"time"
+)
+
+func TestStrftime(t *testing.T) {
+	ts := time.Date(2024, time.March, 5, 14, 7, 9, 250*int(time.Millisecond), time.UTC)
+	tests := []struct{ format, want string }{
+		{"%Y-%m-%d %H:%M:%S", "2024-03-05 14:07:09"},
+		{"%d/%m/%y %I:%M %p", "05/03/24 02:07 PM"},
+		{"%a %e %b %H:%M:%S.%L %Z", "Tue  5 Mar 14:07:09.250 UTC"},
+		{"Cam 1 %j 100%%", "Cam 1 065 100%"},
+	}
+	for _, tt := range tests {
+		got, err := strftime(tt.format, ts)
+		if err != nil || got != tt.want {
+			t.Errorf("strftime(%q) = %q, %v; want %q", tt.format, got, err, tt.want)
+		}
+	}
+	for _, bad := range []string{"%Q", "100%"} {
+		if _, err := strftime(bad, ts); err == nil {
+			t.Errorf("strftime(%q): expected an error", bad)
+		}
+	}
+}
+
+func TestParseOverlay(t *testing.T) {
+	oc, err := ParseOverlay("timestamp,format=%H:%M:%S,timezone=Asia/Tokyo,position=bottom-right,size=24,box")
+	if err != nil {
+		t.Fatalf("ParseOverlay: %v", err)
+	}
+	want := OverlayConfig{Type: "timestamp", Format: "%H:%M:%S", TimeZone: "Asia/Tokyo", Position: "bottom-right", Size: 24, Box: true}
+	if oc != want {
+		t.Errorf("got %+v, want %+v", oc, want)
+	}
+
+	for _, bad := range []string{
+		"subtitle",
+		"label",
+		"timestamp,timezone=Mars/Olympus",
+		"timestamp,format=%Q",
+		"counter,position=middle",
+		"counter,size=big",
+		"counter,color=red",
+		"counter,font=serif",
+	} {
+		if _, err := ParseOverlay(bad); err == nil {
+			t.Errorf("ParseOverlay(%q): expected an error", bad)
+		}
+	}
+}
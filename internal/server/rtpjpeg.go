@@ -0,0 +1,289 @@
+package server
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	"time"
+
+	"github.com/idevakk/mediastream/internal/metrics"
+	"github.com/idevakk/mediastream/internal/pipeline"
+)
+
+// RTP/JPEG (RFC 2435) constants.
+const (
+	rtpPayloadJPEG = 26    // static payload type for JPEG
+	rtpClockRate   = 90000 // RTP timestamp units per second for video
+	rtpMaxPacket   = 1400  // keeps packets under a typical 1500-byte MTU
+	rtpHeaderLen   = 12
+	rtpMaxSize     = 2040 // largest width or height: 255 blocks of 8 pixels
+)
+
+// errUnsupportedJPEG reports a JPEG layout that RFC 2435 can't carry, such
+// as progressive or grayscale images.
+var errUnsupportedJPEG = errors.New("JPEG layout not supported by RTP/JPEG")
+
+// standardHuffman holds the Huffman tables of ITU T.81 Annex K, keyed by
+// table class and ID, as a DHT segment lists them: 16 code counts, then
+// the symbols. RFC 2435 receivers always decode with these.
+var standardHuffman = map[byte][]byte{
+	0x00: { // DC luma
+		0x00, 0x01, 0x05, 0x01, 0x01, 0x01, 0x01, 0x01, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x00, 0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08, 0x09, 0x0a, 0x0b,
+	},
+	0x10: { // AC luma
+		0x00, 0x02, 0x01, 0x03, 0x03, 0x02, 0x04, 0x03, 0x05, 0x05, 0x04, 0x04, 0x00, 0x00, 0x01, 0x7d,
+		0x01, 0x02, 0x03, 0x00, 0x04, 0x11, 0x05, 0x12, 0x21, 0x31, 0x41, 0x06, 0x13, 0x51, 0x61, 0x07,
+		0x22, 0x71, 0x14, 0x32, 0x81, 0x91, 0xa1, 0x08, 0x23, 0x42, 0xb1, 0xc1, 0x15, 0x52, 0xd1, 0xf0,
+		0x24, 0x33, 0x62, 0x72, 0x82, 0x09, 0x0a, 0x16, 0x17, 0x18, 0x19, 0x1a, 0x25, 0x26, 0x27, 0x28,
+		0x29, 0x2a, 0x34, 0x35, 0x36, 0x37, 0x38, 0x39, 0x3a, 0x43, 0x44, 0x45, 0x46, 0x47, 0x48, 0x49,
+		0x4a, 0x53, 0x54, 0x55, 0x56, 0x57, 0x58, 0x59, 0x5a, 0x63, 0x64, 0x65, 0x66, 0x67, 0x68, 0x69,
+		0x6a, 0x73, 0x74, 0x75, 0x76, 0x77, 0x78, 0x79, 0x7a, 0x83, 0x84, 0x85, 0x86, 0x87, 0x88, 0x89,
+		0x8a, 0x92, 0x93, 0x94, 0x95, 0x96, 0x97, 0x98, 0x99, 0x9a, 0xa2, 0xa3, 0xa4, 0xa5, 0xa6, 0xa7,
+		0xa8, 0xa9, 0xaa, 0xb2, 0xb3, 0xb4, 0xb5, 0xb6, 0xb7, 0xb8, 0xb9, 0xba, 0xc2, 0xc3, 0xc4, 0xc5,
+		0xc6, 0xc7, 0xc8, 0xc9, 0xca, 0xd2, 0xd3, 0xd4, 0xd5, 0xd6, 0xd7, 0xd8, 0xd9, 0xda, 0xe1, 0xe2,
+		0xe3, 0xe4, 0xe5, 0xe6, 0xe7, 0xe8, 0xe9, 0xea, 0xf1, 0xf2, 0xf3, 0xf4, 0xf5, 0xf6, 0xf7, 0xf8,
+		0xf9, 0xfa,
+	},
+	0x01: { // DC chroma
+		0x00, 0x03, 0x01, 0x01, 0x01, 0x01, 0x01, 0x01, 0x01, 0x01, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x00, 0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08, 0x09, 0x0a, 0x0b,
+	},
+	0x11: { // AC chroma
+		0x00, 0x02, 0x01, 0x02, 0x04, 0x04, 0x03, 0x04, 0x07, 0x05, 0x04, 0x04, 0x00, 0x01, 0x02, 0x77,
+		0x00, 0x01, 0x02, 0x03, 0x11, 0x04, 0x05, 0x21, 0x31, 0x06, 0x12, 0x41, 0x51, 0x07, 0x61, 0x71,
+		0x13, 0x22, 0x32, 0x81, 0x08, 0x14, 0x42, 0x91, 0xa1, 0xb1, 0xc1, 0x09, 0x23, 0x33, 0x52, 0xf0,
+		0x15, 0x62, 0x72, 0xd1, 0x0a, 0x16, 0x24, 0x34, 0xe1, 0x25, 0xf1, 0x17, 0x18, 0x19, 0x1a, 0x26,
+		0x27, 0x28, 0x29, 0x2a, 0x35, 0x36, 0x37, 0x38, 0x39, 0x3a, 0x43, 0x44, 0x45, 0x46, 0x47, 0x48,
+		0x49, 0x4a, 0x53, 0x54, 0x55, 0x56, 0x57, 0x58, 0x59, 0x5a, 0x63, 0x64, 0x65, 0x66, 0x67, 0x68,
+		0x69, 0x6a, 0x73, 0x74, 0x75, 0x76, 0x77, 0x78, 0x79, 0x7a, 0x82, 0x83, 0x84, 0x85, 0x86, 0x87,
+		0x88, 0x89, 0x8a, 0x92, 0x93, 0x94, 0x95, 0x96, 0x97, 0x98, 0x99, 0x9a, 0xa2, 0xa3, 0xa4, 0xa5,
+		0xa6, 0xa7, 0xa8, 0xa9, 0xaa, 0xb2, 0xb3, 0xb4, 0xb5, 0xb6, 0xb7, 0xb8, 0xb9, 0xba, 0xc2, 0xc3,
+		0xc4, 0xc5, 0xc6, 0xc7, 0xc8, 0xc9, 0xca, 0xd2, 0xd3, 0xd4, 0xd5, 0xd6, 0xd7, 0xd8, 0xd9, 0xda,
+		0xe2, 0xe3, 0xe4, 0xe5, 0xe6, 0xe7, 0xe8, 0xe9, 0xea, 0xf2, 0xf3, 0xf4, 0xf5, 0xf6, 0xf7, 0xf8,
+		0xf9, 0xfa,
+	},
+}
+
+// rtpJPEG is the subset of a baseline JPEG that RFC 2435 transmits. The
+// receiver rebuilds the headers (including the standard Huffman tables)
+// from these fields, so only the entropy-coded scan travels verbatim.
+type rtpJPEG struct {
+	typ             byte // 0 = 4:2:2, 1 = 4:2:0; +64 when restart markers are used
+	width, height   byte // in 8-pixel blocks
+	qtables         []byte
+	restartInterval uint16
+	scan            []byte
+}
+
+// parseRTPJPEG extracts the fields RFC 2435 needs from a baseline JPEG.
+func parseRTPJPEG(b []byte) (*rtpJPEG, error) {
+	if len(b) < 4 || b[0] != 0xFF || b[1] != 0xD8 {
+		return nil, fmt.Errorf("missing JPEG SOI marker")
+	}
+
+	var (
+		tables [4][]byte
+		order  []byte // quantization table IDs in component order
+		out    rtpJPEG
+		sof    bool
+	)
+
+	pos := 2
+	for pos+4 <= len(b) {
+		if b[pos] != 0xFF {
+			return nil, fmt.Errorf("expected JPEG marker at offset %d", pos)
+		}
+		marker := b[pos+1]
+		if marker == 0xFF { // fill byte
+			pos++
+			continue
+		}
+		length := int(binary.BigEndian.Uint16(b[pos+2:]))
+		seg := pos + 4
+		end := pos + 2 + length
+		if length < 2 || end > len(b) {
+			return nil, fmt.Errorf("truncated JPEG segment 0x%02X", marker)
+		}
+
+		switch marker {
+		case 0xDB: // DQT, possibly holding several tables
+			for p := seg; p < end; p += 65 {
+				if p+65 > end {
+					return nil, fmt.Errorf("truncated quantization table")
+				}
+				if b[p]>>4 != 0 {
+					return nil, fmt.Errorf("%w: 16-bit quantization tables", errUnsupportedJPEG)
+				}
+				tables[b[p]&0x03] = b[p+1 : p+65]
+			}
+
+		case 0xC4: // DHT, possibly holding several tables
+			for p := seg; p < end; {
+				if p+17 > end {
+					return nil, fmt.Errorf("truncated Huffman table")
+				}
+				n := 17
+				for _, c := range b[p+1 : p+17] {
+					n += int(c)
+				}
+				if p+n > end {
+					return nil, fmt.Errorf("truncated Huffman table")
+				}
+				if std, ok := standardHuffman[b[p]]; !ok || !bytes.Equal(b[p+1:p+n], std) {
+					return nil, fmt.Errorf("%w: non-standard Huffman table 0x%02X", errUnsupportedJPEG, b[p])
+				}
+				p += n
+			}
+
+		case 0xC0, 0xC1: // baseline / extended sequential SOF
+			if length != 17 || b[seg+5] != 3 {
+				return nil, fmt.Errorf("%w: need exactly three components", errUnsupportedJPEG)
+			}
+			h := int(binary.BigEndian.Uint16(b[seg+1:]))
+			w := int(binary.BigEndian.Uint16(b[seg+3:]))
+			if w > rtpMaxSize || h > rtpMaxSize {
+				return nil, fmt.Errorf("%w: %dx%d exceeds %dx%[4]d", errUnsupportedJPEG, w, h, rtpMaxSize)
+			}
+			out.width, out.height = byte((w+7)/8), byte((h+7)/8)
+
+			comps := b[seg+6 : end]
+			switch comps[1] {
+			case 0x21:
+				out.typ = 0
+			case 0x22:
+				out.typ = 1
+			default:
+				return nil, fmt.Errorf("%w: luma sampling 0x%02X", errUnsupportedJPEG, comps[1])
+			}
+			if comps[4] != 0x11 || comps[7] != 0x11 {
+				return nil, fmt.Errorf("%w: chroma must not be subsampled further", errUnsupportedJPEG)
+			}
+			for i := 0; i < 3; i++ {
+				id := comps[i*3+2] & 0x03
+				if bytes.IndexByte(order, id) < 0 {
+					order = append(order, id)
+				}
+			}
+			sof = true
+
+		case 0xC2, 0xC3, 0xC5, 0xC6, 0xC7, 0xC9, 0xCA, 0xCB, 0xCD, 0xCE, 0xCF:
+			return nil, fmt.Errorf("%w: non-baseline SOF 0x%02X", errUnsupportedJPEG, marker)
+
+		case 0xDD: // DRI
+			out.restartInterval = binary.BigEndian.Uint16(b[seg:])
+
+		case 0xDA: // SOS: the scan runs until the trailing EOI
+			if !sof {
+				return nil, fmt.Errorf("JPEG scan before frame header")
+			}
+			scanEnd := bytes.LastIndex(b, []byte{0xFF, 0xD9})
+			if scanEnd < end {
+				return nil, fmt.Errorf("missing JPEG EOI marker")
+			}
+			out.scan = b[end:scanEnd]
+
+			for _, id := range order {
+				if tables[id] == nil {
+					return nil, fmt.Errorf("missing quantization table %d", id)
+				}
+				out.qtables = append(out.qtables, tables[id]...)
+			}
+			if out.restartInterval > 0 {
+				out.typ += 64
+			}
+			return &out, nil
+		}
+		pos = end
+	}
+	return nil, fmt.Errorf("no JPEG scan found")
+}
+
+// normalizeRTPJPEG re-encodes frames that RFC 2435 can't carry as-is (for
+// example grayscale, progressive or Huffman-optimized JPEGs) into a
+// baseline 4:2:0 JPEG with the standard tables.
+// Frames too large for its header are scaled down to fit rtpMaxSize.
+func normalizeRTPJPEG(frame []byte) (*rtpJPEG, error) {
+	parsed, err := parseRTPJPEG(frame)
+	if err == nil || !errors.Is(err, errUnsupportedJPEG) {
+		return parsed, err
+	}
+
+	start := time.Now()
+	img, decErr := jpeg.Decode(bytes.NewReader(frame))
+	if decErr != nil {
+		return nil, err
+	}
+	metrics.DecodeSeconds.With("rtsp").ObserveSince(start)
+	if b := img.Bounds(); b.Dx() > rtpMaxSize || b.Dy() > rtpMaxSize {
+		img = pipeline.Resize{Width: rtpMaxSize, Height: rtpMaxSize, Fit: pipeline.FitContain}.Apply(img)
+	}
+	// Go's encoder always writes 4:2:0 for non-gray images.
+	rgba := image.NewRGBA(img.Bounds())
+	draw.Draw(rgba, rgba.Bounds(), img, img.Bounds().Min, draw.Src)
+
+	var buf bytes.Buffer
+	start = time.Now()
+	if err := jpeg.Encode(&buf, rgba, &jpeg.Options{Quality: 90}); err != nil {
+		return nil, fmt.Errorf("re-encoding frame for RTP: %w", err)
+	}
+	metrics.EncodeSeconds.With("rtsp").ObserveSince(start)
+	return parseRTPJPEG(buf.Bytes())
+}
+
+// rtpPacketizer splits JPEG frames into RTP packets for a single stream.
+type rtpPacketizer struct {
+	ssrc uint32
+	seq  uint16
+}
+
+// packetize returns the RTP packets carrying frame with the given RTP
+// timestamp. The marker bit is set on the last packet of the frame.
+func (p *rtpPacketizer) packetize(j *rtpJPEG, timestamp uint32) [][]byte {
+	var packets [][]byte
+
+	for offset := 0; offset < len(j.scan) || offset == 0; {
+		pkt := make([]byte, rtpHeaderLen, rtpMaxPacket)
+
+		// JPEG header: type-specific, 24-bit fragment offset, type, Q,
+		// width and height. Q=255 means the tables are sent in-band.
+		pkt = append(pkt, 0, byte(offset>>16), byte(offset>>8), byte(offset),
+			j.typ, 255, j.width, j.height)
+
+		if j.restartInterval > 0 {
+			// F=1, L=1, count=0x3FFF: restart intervals may span packets.
+			pkt = binary.BigEndian.AppendUint16(pkt, j.restartInterval)
+			pkt = append(pkt, 0xFF, 0xFF)
+		}
+		if offset == 0 {
+			pkt = append(pkt, 0, 0) // MBZ, precision (8-bit tables)
+			pkt = binary.BigEndian.AppendUint16(pkt, uint16(len(j.qtables)))
+			pkt = append(pkt, j.qtables...)
+		}
+
+		n := min(rtpMaxPacket-len(pkt), len(j.scan)-offset)
+		pkt = append(pkt, j.scan[offset:offset+n]...)
+		offset += n
+		last := offset >= len(j.scan)
+
+		pkt[0] = 0x80 // version 2, no padding, extension or CSRCs
+		pkt[1] = rtpPayloadJPEG
+		if last {
+			pkt[1] |= 0x80
+		}
+		binary.BigEndian.PutUint16(pkt[2:], p.seq)
+		binary.BigEndian.PutUint32(pkt[4:], timestamp)
+		binary.BigEndian.PutUint32(pkt[8:], p.ssrc)
+		p.seq++
+
+		packets = append(packets, pkt)
+		if last {
+			break
+		}
+	}
+	return packets
+}
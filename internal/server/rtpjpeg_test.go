@@ -0,0 +1,139 @@
+package server
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"math/rand"
+	"testing"
+)
+
+// noisyJPEG encodes a random image large enough to span many RTP packets.
+func noisyJPEG(t *testing.T, img image.Image) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 90}); err != nil {
+		t.Fatalf("encoding JPEG: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestPacketizeFragmentsScanContiguously(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	img := image.NewRGBA(image.Rect(0, 0, 320, 240))
+	for i := range img.Pix {
+		img.Pix[i] = byte(rng.Intn(256))
+	}
+
+	j, err := parseRTPJPEG(noisyJPEG(t, img))
+	if err != nil {
+		t.Fatalf("parseRTPJPEG: %v", err)
+	}
+	if j.typ != 1 || j.width != 40 || j.height != 30 || len(j.qtables) != 128 {
+		t.Fatalf("unexpected header fields: type=%d %dx%d qtables=%d", j.typ, j.width, j.height, len(j.qtables))
+	}
+
+	p := rtpPacketizer{ssrc: 1, seq: 65534}
+	packets := p.packetize(j, 1234)
+	if len(packets) < 2 {
+		t.Fatalf("expected several packets, got %d", len(packets))
+	}
+
+	var scan []byte
+	for i, pkt := range packets {
+		if len(pkt) > rtpMaxPacket {
+			t.Fatalf("packet %d is %d bytes, over the %d limit", i, len(pkt), rtpMaxPacket)
+		}
+		marker := pkt[1]&0x80 != 0
+		if marker != (i == len(packets)-1) {
+			t.Fatalf("packet %d: marker bit %v", i, marker)
+		}
+		if seq := binary.BigEndian.Uint16(pkt[2:]); seq != uint16(65534+i) {
+			t.Fatalf("packet %d: sequence %d", i, seq)
+		}
+
+		offset := int(pkt[13])<<16 | int(pkt[14])<<8 | int(pkt[15])
+		if offset != len(scan) {
+			t.Fatalf("packet %d: fragment offset %d, want %d", i, offset, len(scan))
+		}
+		payload := pkt[20:]
+		if i == 0 {
+			payload = payload[4+128:] // quantization table header + tables
+		}
+		scan = append(scan, payload...)
+	}
+	if !bytes.Equal(scan, j.scan) {
+		t.Fatal("reassembled scan does not match the original")
+	}
+}
+
+func TestNormalizeGrayscaleJPEG(t *testing.T) {
+	img := image.NewGray(image.Rect(0, 0, 16, 16))
+	img.Set(3, 3, color.White)
+	frame := noisyJPEG(t, img)
+
+	if _, err := parseRTPJPEG(frame); err == nil {
+		t.Fatal("expected grayscale JPEG to be rejected as-is")
+	}
+	if _, err := normalizeRTPJPEG(frame); err != nil {
+		t.Fatalf("normalizeRTPJPEG: %v", err)
+	}
+}
+
+func TestNormalizeDownscalesLargeJPEG(t *testing.T) {
+	frame := noisyJPEG(t, image.NewRGBA(image.Rect(0, 0, 4096, 1024)))
+
+	if _, err := parseRTPJPEG(frame); err == nil {
+		t.Fatal("expected a 4096px wide JPEG to be rejected as-is")
+	}
+	j, err := normalizeRTPJPEG(frame)
+	if err != nil {
+		t.Fatalf("normalizeRTPJPEG: %v", err)
+	}
+	if j.width != 255 || j.height != 64 {
+		t.Fatalf("expected 255x64 blocks, got %dx%d", j.width, j.height)
+	}
+}
+
+// withCustomHuffman rewrites the luma DC table of a JPEG from Go's encoder
+// the way an optimizing encoder might: one more, unused code at the end
+// leaves every existing code, and so the image, unchanged.
+func withCustomHuffman(t *testing.T, frame []byte) []byte {
+	t.Helper()
+	for pos := 2; pos+4 <= len(frame); {
+		length := int(binary.BigEndian.Uint16(frame[pos+2:]))
+		if frame[pos+1] == 0xC4 && frame[pos+4] == 0x00 {
+			out := append([]byte{}, frame[:pos+2]...)
+			out = binary.BigEndian.AppendUint16(out, uint16(length+1))
+			table := append([]byte{}, frame[pos+4:pos+4+17+12]...) // class/ID, 16 counts, 12 symbols
+			table[16]++                                            // one 16-bit code
+			out = append(append(out, table...), 0x0C)
+			return append(out, frame[pos+4+17+12:]...)
+		}
+		pos += 2 + length
+	}
+	t.Fatal("no luma DC table found")
+	return nil
+}
+
+func TestNormalizeNonStandardHuffmanJPEG(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	img := image.NewRGBA(image.Rect(0, 0, 64, 48))
+	for i := range img.Pix {
+		img.Pix[i] = byte(rng.Intn(256))
+	}
+	frame := withCustomHuffman(t, noisyJPEG(t, img))
+	if _, err := jpeg.Decode(bytes.NewReader(frame)); err != nil {
+		t.Fatalf("rewritten JPEG does not decode: %v", err)
+	}
+
+	if _, err := parseRTPJPEG(frame); !errors.Is(err, errUnsupportedJPEG) {
+		t.Fatalf("expected the custom Huffman table to be rejected as-is, got %v", err)
+	}
+	if _, err := normalizeRTPJPEG(frame); err != nil {
+		t.Fatalf("normalizeRTPJPEG: %v", err)
+	}
+}
@@ -0,0 +1,506 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// rtspSessionTimeout is advertised to clients; they send a keep-alive
+// (usually GET_PARAMETER or OPTIONS) well within this many seconds.
+const rtspSessionTimeout = 60
+
+// rtspServer exposes every channel at rtsp://host:port/<name> as an
+// RTP/JPEG (RFC 2435) stream, emulating a basic IP camera. Sessions live as
+// long as their RTSP control connection.
+type rtspServer struct {
+	srv      *Server
+	listener net.Listener
+}
+
+// listenRTSP binds the RTSP port. Serving starts with serve.
+func listenRTSP(s *Server, port int) (*rtspServer, error) {
+	ln, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+	if err != nil {
+		return nil, fmt.Errorf("listening for RTSP: %w", err)
+	}
+	return &rtspServer{srv: s, listener: ln}, nil
+}
+
+// serve accepts RTSP connections until ctx is cancelled.
+func (rs *rtspServer) serve(ctx context.Context) {
+	go func() {
+		<-ctx.Done()
+		rs.listener.Close()
+	}()
+
+	for {
+		conn, err := rs.listener.Accept()
+		if err != nil {
+			return
+		}
+		c := &rtspConn{srv: rs.srv, conn: conn, r: bufio.NewReader(conn)}
+		go c.serve(ctx)
+	}
+}
+
+// rtspConn is one RTSP control connection and the session it owns.
+type rtspConn struct {
+	srv  *Server
+	conn net.Conn
+	r    *bufio.Reader
+
+	wmu     sync.Mutex // serializes responses and interleaved RTP
+	session *rtspSession
+}
+
+// rtspRequest is a parsed RTSP request.
+type rtspRequest struct {
+	method string
+	url    *url.URL
+	header textproto.MIMEHeader
+}
+
+// rtspSession is the state created by SETUP and driven by PLAY/TEARDOWN.
+type rtspSession struct {
+	id  string
+	ch  *channel
+	pkt rtpPacketizer
+
+	// Exactly one of the two transports is set.
+	interleaved bool
+	rtpChannel  byte
+	udp         *net.UDPConn
+	rtcp        *net.UDPConn
+	clientAddr  *net.UDPAddr
+
+	stop context.CancelFunc // non-nil while playing
+	done chan struct{}      // closed when the sender goroutine exits
+}
+
+func (c *rtspConn) serve(ctx context.Context) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		<-ctx.Done()
+		c.conn.Close()
+	}()
+	defer c.teardown()
+
+	for {
+		req, err := c.readRequest()
+		if err != nil {
+			return
+		}
+		c.handle(req)
+	}
+}
+
+// readRequest reads the next RTSP request, discarding any interleaved RTCP
+// packets the client sends on the same connection.
+func (c *rtspConn) readRequest() (*rtspRequest, error) {
+	for {
+		b, err := c.r.Peek(1)
+		if err != nil {
+			return nil, err
+		}
+		if b[0] != '$' {
+			break
+		}
+		var hdr [4]byte
+		if _, err := io.ReadFull(c.r, hdr[:]); err != nil {
+			return nil, err
+		}
+		if _, err := c.r.Discard(int(binary.BigEndian.Uint16(hdr[2:]))); err != nil {
+			return nil, err
+		}
+	}
+
+	tp := textproto.NewReader(c.r)
+	line, err := tp.ReadLine()
+	if err != nil {
+		return nil, err
+	}
+	parts := strings.Fields(line)
+	if len(parts) != 3 || !strings.HasPrefix(parts[2], "RTSP/") {
+		return nil, fmt.Errorf("malformed RTSP request line %q", line)
+	}
+	u, err := url.Parse(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("malformed RTSP URL %q: %w", parts[1], err)
+	}
+	header, err := tp.ReadMIMEHeader()
+	if err != nil {
+		return nil, err
+	}
+	if n, _ := strconv.Atoi(header.Get("Content-Length")); n > 0 {
+		if _, err := c.r.Discard(n); err != nil {
+			return nil, err
+		}
+	}
+	return &rtspRequest{method: parts[0], url: u, header: header}, nil
+}
+
+func (c *rtspConn) handle(req *rtspRequest) {
+	switch req.method {
+	case "OPTIONS":
+		c.respond(req, 200, "OK", map[string]string{
+			"Public": "OPTIONS, DESCRIBE, SETUP, PLAY, PAUSE, TEARDOWN, GET_PARAMETER",
+		}, "")
+	case "DESCRIBE":
+		c.describe(req)
+	case "SETUP":
+		c.setup(req)
+	case "PLAY":
+		c.play(req)
+	case "PAUSE":
+		if !c.checkSession(req) {
+			return
+		}
+		c.stopPlaying()
+		c.respond(req, 200, "OK", c.sessionHeader(), "")
+	case "TEARDOWN":
+		if !c.checkSession(req) {
+			return
+		}
+		c.respond(req, 200, "OK", nil, "")
+		c.teardown()
+	case "GET_PARAMETER", "SET_PARAMETER":
+		c.respond(req, 200, "OK", c.sessionHeader(), "")
+	default:
+		c.respond(req, 501, "Not Implemented", nil, "")
+	}
+}
+
+// lookup resolves the stream named by the first path segment of u. An
+// empty path selects the default stream.
+func (c *rtspConn) lookup(u *url.URL) (*channel, bool) {
+	name, _, _ := strings.Cut(strings.TrimPrefix(u.Path, "/"), "/")
+	if name == "" {
+		return c.srv.channels[0], true
+	}
+	ch, ok := c.srv.byName[name]
+	return ch, ok
+}
+
+func (c *rtspConn) describe(req *rtspRequest) {
+	ch, ok := c.lookup(req.url)
+	if !ok {
+		c.respond(req, 404, "Not Found", nil, "")
+		return
+	}
+
+	host, _, _ := net.SplitHostPort(c.conn.LocalAddr().String())
+	sdp := strings.Join([]string{
+		"v=0",
+		fmt.Sprintf("o=- %d 1 IN IP4 %s", time.Now().Unix(), host),
+		"s=" + ch.cfg.Name,
+		"c=IN IP4 0.0.0.0",
+		"t=0 0",
+		"a=control:*",
+		fmt.Sprintf("m=video 0 RTP/AVP %d", rtpPayloadJPEG),
+		fmt.Sprintf("a=rtpmap:%d JPEG/%d", rtpPayloadJPEG, rtpClockRate),
+		fmt.Sprintf("a=framerate:%d", ch.cfg.FrameRate),
+		"a=control:trackID=0",
+		"",
+	}, "\r\n")
+
+	base := *req.url
+	if !strings.HasSuffix(base.Path, "/") {
+		base.Path += "/"
+	}
+	c.respond(req, 200, "OK", map[string]string{
+		"Content-Base": base.String(),
+		"Content-Type": "application/sdp",
+	}, sdp)
+}
+
+func (c *rtspConn) setup(req *rtspRequest) {
+	ch, ok := c.lookup(req.url)
+	if !ok {
+		c.respond(req, 404, "Not Found", nil, "")
+		return
+	}
+	if c.session != nil {
+		// One track per stream, so a second SETUP is aggregate-control only.
+		c.respond(req, 459, "Aggregate Operation Not Allowed", nil, "")
+		return
+	}
+
+	sess := &rtspSession{ch: ch}
+	var idBuf [8]byte
+	rand.Read(idBuf[:]) //nolint:errcheck
+	sess.id = strings.ToUpper(hex.EncodeToString(idBuf[:]))
+	sess.pkt.ssrc = binary.BigEndian.Uint32(idBuf[:4])
+	sess.pkt.seq = binary.BigEndian.Uint16(idBuf[4:6])
+
+	transport := req.header.Get("Transport")
+	params := parseTransport(transport)
+	var reply string
+
+	switch {
+	case strings.Contains(transport, "RTP/AVP/TCP"):
+		lo, hi := 0, 1
+		if v, ok := params["interleaved"]; ok {
+			if lo, hi, ok = parsePortRange(v); !ok || lo > 255 || hi > 255 {
+				c.respond(req, 461, "Unsupported Transport", nil, "")
+				return
+			}
+		}
+		sess.interleaved = true
+		sess.rtpChannel = byte(lo)
+		reply = fmt.Sprintf("RTP/AVP/TCP;unicast;interleaved=%d-%d;ssrc=%08X", lo, hi, sess.pkt.ssrc)
+
+	case params["client_port"] != "" && !params.has("multicast"):
+		rtpPort, rtcpPort, ok := parsePortRange(params["client_port"])
+		if !ok {
+			c.respond(req, 461, "Unsupported Transport", nil, "")
+			return
+		}
+		host, _, _ := net.SplitHostPort(c.conn.RemoteAddr().String())
+		ip := net.ParseIP(host)
+
+		var err error
+		if sess.udp, sess.rtcp, err = listenUDPPair(); err != nil {
+			c.respond(req, 500, "Internal Server Error", nil, "")
+			return
+		}
+		sess.clientAddr = &net.UDPAddr{IP: ip, Port: rtpPort}
+		reply = fmt.Sprintf("RTP/AVP;unicast;client_port=%d-%d;server_port=%d-%d;ssrc=%08X",
+			rtpPort, rtcpPort,
+			sess.udp.LocalAddr().(*net.UDPAddr).Port, sess.rtcp.LocalAddr().(*net.UDPAddr).Port,
+			sess.pkt.ssrc)
+
+	default:
+		c.respond(req, 461, "Unsupported Transport", nil, "")
+		return
+	}
+
+	c.session = sess
+	c.respond(req, 200, "OK", map[string]string{
+		"Transport": reply,
+		"Session":   fmt.Sprintf("%s;timeout=%d", sess.id, rtspSessionTimeout),
+	}, "")
+}
+
+func (c *rtspConn) play(req *rtspRequest) {
+	if !c.checkSession(req) {
+		return
+	}
+	sess := c.session
+
+	h := c.sessionHeader()
+	h["Range"] = "npt=0.000-"
+	if sess.stop != nil {
+		c.respond(req, 200, "OK", h, "") // already playing
+		return
+	}
+
+	// Reply before the first packet goes out so the client sees the
+	// response to PLAY ahead of any interleaved RTP.
+	h["RTP-Info"] = fmt.Sprintf("url=%s;seq=%d", req.url, sess.pkt.seq)
+	c.respond(req, 200, "OK", h, "")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	sess.stop = cancel
+	sess.done = make(chan struct{})
+	go c.send(ctx, sess)
+}
+
+// send streams the channel's frames to the client until ctx is cancelled
+// or the transport fails. Like MJPEG viewers, it reads from a drop-oldest
+// hub subscription so a slow RTSP client never stalls anyone else.
+func (c *rtspConn) send(ctx context.Context, sess *rtspSession) {
+	defer close(sess.done)
+
+	sub := sess.ch.hub.subscribe("rtsp:" + c.conn.RemoteAddr().String())
+	defer sess.ch.hub.unsubscribe(sub)
+
+	start := time.Now()
+	base := sess.pkt.ssrc // any value works as the initial timestamp
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case frame, ok := <-sub.frames:
+			if !ok {
+				if sub.reset.Load() {
+					c.conn.Close() // an injected connection reset
+				}
+				return
+			}
+			j, err := normalizeRTPJPEG(frame)
+			if err != nil {
+				continue
+			}
+			ts := base + uint32(time.Since(start).Seconds()*rtpClockRate)
+			n := 0
+			for _, pkt := range sess.pkt.packetize(j, ts) {
+				if err := c.writeRTP(sess, pkt); err != nil {
+					if ctx.Err() == nil {
+						c.conn.Close() // the client is gone
+					}
+					return
+				}
+				n += len(pkt)
+			}
+			sub.delivered(n)
+		}
+	}
+}
+
+// writeRTP sends one RTP packet over the session's transport.
+func (c *rtspConn) writeRTP(sess *rtspSession, pkt []byte) error {
+	if !sess.interleaved {
+		_, err := sess.udp.WriteToUDP(pkt, sess.clientAddr)
+		return err
+	}
+
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+
+	c.conn.SetWriteDeadline(time.Now().Add(clientWriteTimeout)) //nolint:errcheck
+	hdr := []byte{'$', sess.rtpChannel, 0, 0}
+	binary.BigEndian.PutUint16(hdr[2:], uint16(len(pkt)))
+	if _, err := c.conn.Write(hdr); err != nil {
+		return err
+	}
+	_, err := c.conn.Write(pkt)
+	return err
+}
+
+// checkSession verifies the request names the connection's session and
+// responds with an error if it doesn't.
+func (c *rtspConn) checkSession(req *rtspRequest) bool {
+	id, _, _ := strings.Cut(req.header.Get("Session"), ";")
+	if c.session == nil || strings.TrimSpace(id) != c.session.id {
+		c.respond(req, 454, "Session Not Found", nil, "")
+		return false
+	}
+	return true
+}
+
+func (c *rtspConn) sessionHeader() map[string]string {
+	h := map[string]string{}
+	if c.session != nil {
+		h["Session"] = c.session.id
+	}
+	return h
+}
+
+// stopPlaying stops the sender goroutine and waits for it to exit, so the
+// packetizer state is never shared between two senders.
+func (c *rtspConn) stopPlaying() {
+	if c.session != nil && c.session.stop != nil {
+		c.session.stop()
+		<-c.session.done
+		c.session.stop = nil
+	}
+}
+
+// teardown stops playback and releases the session's UDP sockets.
+func (c *rtspConn) teardown() {
+	c.stopPlaying()
+	if c.session != nil && c.session.udp != nil {
+		c.session.udp.Close()
+		c.session.rtcp.Close()
+	}
+	c.session = nil
+}
+
+// respond writes an RTSP response echoing the request's CSeq.
+func (c *rtspConn) respond(req *rtspRequest, code int, reason string, headers map[string]string, body string) {
+	var b strings.Builder
+	fmt.Fprintf(&b, "RTSP/1.0 %d %s\r\n", code, reason)
+	fmt.Fprintf(&b, "CSeq: %s\r\n", req.header.Get("CSeq"))
+	b.WriteString("Server: mediastream\r\n")
+	for k, v := range headers {
+		fmt.Fprintf(&b, "%s: %s\r\n", k, v)
+	}
+	if body != "" {
+		fmt.Fprintf(&b, "Content-Length: %d\r\n", len(body))
+	}
+	b.WriteString("\r\n")
+	b.WriteString(body)
+
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	c.conn.SetWriteDeadline(time.Now().Add(clientWriteTimeout)) //nolint:errcheck
+	io.WriteString(c.conn, b.String())                          //nolint:errcheck
+}
+
+// transportParams holds the ;-separated parameters of a Transport header.
+type transportParams map[string]string
+
+func (p transportParams) has(key string) bool {
+	_, ok := p[key]
+	return ok
+}
+
+// parseTransport parses the first transport spec of a Transport header.
+func parseTransport(h string) transportParams {
+	spec, _, _ := strings.Cut(h, ",")
+	params := transportParams{}
+	for _, part := range strings.Split(spec, ";") {
+		k, v, _ := strings.Cut(strings.TrimSpace(part), "=")
+		params[strings.ToLower(k)] = v
+	}
+	return params
+}
+
+// parsePortRange parses "a-b" (or just "a", implying a+1).
+func parsePortRange(v string) (lo, hi int, ok bool) {
+	a, b, found := strings.Cut(v, "-")
+	lo, err := strconv.Atoi(a)
+	if err != nil || lo < 0 || lo > 65535 {
+		return 0, 0, false
+	}
+	if !found {
+		return lo, lo + 1, true
+	}
+	hi, err = strconv.Atoi(b)
+	if err != nil || hi < 0 || hi > 65535 {
+		return 0, 0, false
+	}
+	return lo, hi, true
+}
+
+// listenUDPPair binds an RTP/RTCP socket pair, preferring an even port
+// followed by the next odd one as RFC 3550 recommends.
+func listenUDPPair() (rtp, rtcp *net.UDPConn, err error) {
+	for i := 0; i < 10; i++ {
+		rtp, err = net.ListenUDP("udp", &net.UDPAddr{})
+		if err != nil {
+			return nil, nil, err
+		}
+		port := rtp.LocalAddr().(*net.UDPAddr).Port
+		if port%2 == 0 {
+			if rtcp, err = net.ListenUDP("udp", &net.UDPAddr{Port: port + 1}); err == nil {
+				return rtp, rtcp, nil
+			}
+		}
+		rtp.Close()
+	}
+
+	// Fall back to any two ports.
+	if rtp, err = net.ListenUDP("udp", &net.UDPAddr{}); err != nil {
+		return nil, nil, err
+	}
+	if rtcp, err = net.ListenUDP("udp", &net.UDPAddr{}); err != nil {
+		rtp.Close()
+		return nil, nil, err
+	}
+	return rtp, rtcp, nil
+}
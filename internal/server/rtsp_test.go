@@ -0,0 +1,191 @@
+package server_test
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/idevakk/mediastream/internal/server"
+)
+
+// rtspClient is a minimal RTSP client for exercising the server.
+type rtspClient struct {
+	t    *testing.T
+	conn net.Conn
+	r    *bufio.Reader
+	cseq int
+}
+
+func dialRTSP(t *testing.T, port int) *rtspClient {
+	t.Helper()
+	conn, err := net.Dial("tcp", fmt.Sprintf("localhost:%d", port))
+	if err != nil {
+		t.Fatalf("dialing RTSP: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(5 * time.Second)) //nolint:errcheck
+	return &rtspClient{t: t, conn: conn, r: bufio.NewReader(conn)}
+}
+
+// do sends a request and returns the status code, headers and body.
+func (c *rtspClient) do(method, url string, headers ...string) (int, textproto.MIMEHeader, string) {
+	c.t.Helper()
+	c.cseq++
+	req := fmt.Sprintf("%s %s RTSP/1.0\r\nCSeq: %d\r\n", method, url, c.cseq)
+	for _, h := range headers {
+		req += h + "\r\n"
+	}
+	if _, err := io.WriteString(c.conn, req+"\r\n"); err != nil {
+		c.t.Fatalf("%s: %v", method, err)
+	}
+
+	tp := textproto.NewReader(c.r)
+	status, err := tp.ReadLine()
+	if err != nil {
+		c.t.Fatalf("%s: reading status: %v", method, err)
+	}
+	fields := strings.Fields(status)
+	if len(fields) < 2 {
+		c.t.Fatalf("%s: malformed status line %q", method, status)
+	}
+	code, _ := strconv.Atoi(fields[1])
+
+	hdr, err := tp.ReadMIMEHeader()
+	if err != nil {
+		c.t.Fatalf("%s: reading headers: %v", method, err)
+	}
+	if hdr.Get("CSeq") != strconv.Itoa(c.cseq) {
+		c.t.Fatalf("%s: CSeq mismatch: %q", method, hdr.Get("CSeq"))
+	}
+	body := make([]byte, 0)
+	if n, _ := strconv.Atoi(hdr.Get("Content-Length")); n > 0 {
+		body = make([]byte, n)
+		if _, err := io.ReadFull(c.r, body); err != nil {
+			c.t.Fatalf("%s: reading body: %v", method, err)
+		}
+	}
+	return code, hdr, string(body)
+}
+
+// checkRTPJPEG verifies pkt is an RTP packet carrying JPEG payload.
+func checkRTPJPEG(t *testing.T, pkt []byte) {
+	t.Helper()
+	if len(pkt) < 20 {
+		t.Fatalf("RTP packet too short: %d bytes", len(pkt))
+	}
+	if pkt[0]>>6 != 2 {
+		t.Fatalf("expected RTP version 2, got %d", pkt[0]>>6)
+	}
+	if pt := pkt[1] & 0x7F; pt != 26 {
+		t.Fatalf("expected payload type 26 (JPEG), got %d", pt)
+	}
+	// JPEG header follows the 12-byte RTP header; 1x1 px is one 8x8 block.
+	if w, h := pkt[18], pkt[19]; w != 1 || h != 1 {
+		t.Fatalf("expected 1x1 blocks, got %dx%d", w, h)
+	}
+}
+
+func startRTSPServer(t *testing.T, httpPort, rtspPort int) {
+	t.Helper()
+	cfg := server.Config{
+		Port:     httpPort,
+		RTSPPort: rtspPort,
+		Streams:  []server.StreamConfig{{Name: "lobby", FilePath: writeTestJPEG(t)}},
+	}
+	srv, err := server.New(cfg)
+	if err != nil {
+		t.Fatalf("server.New: %v", err)
+	}
+	go srv.Start() //nolint:errcheck
+	time.Sleep(80 * time.Millisecond)
+	t.Cleanup(func() { srv.Stop() }) //nolint:errcheck
+}
+
+func TestRTSPInterleaved(t *testing.T) {
+	startRTSPServer(t, 19880, 19881)
+	c := dialRTSP(t, 19881)
+	url := "rtsp://localhost:19881/lobby"
+
+	code, hdr, sdp := c.do("DESCRIBE", url, "Accept: application/sdp")
+	if code != 200 || hdr.Get("Content-Type") != "application/sdp" {
+		t.Fatalf("DESCRIBE: got %d %q", code, hdr.Get("Content-Type"))
+	}
+	if !strings.Contains(sdp, "m=video 0 RTP/AVP 26") {
+		t.Fatalf("SDP missing JPEG media line:\n%s", sdp)
+	}
+
+	code, hdr, _ = c.do("SETUP", url+"/trackID=0", "Transport: RTP/AVP/TCP;unicast;interleaved=0-1")
+	if code != 200 {
+		t.Fatalf("SETUP: got %d", code)
+	}
+	session, _, _ := strings.Cut(hdr.Get("Session"), ";")
+
+	if code, _, _ = c.do("PLAY", url, "Session: "+session); code != 200 {
+		t.Fatalf("PLAY: got %d", code)
+	}
+
+	var frameHdr [4]byte
+	if _, err := io.ReadFull(c.r, frameHdr[:]); err != nil {
+		t.Fatalf("reading interleaved header: %v", err)
+	}
+	if frameHdr[0] != '$' || frameHdr[1] != 0 {
+		t.Fatalf("unexpected interleaved header % X", frameHdr)
+	}
+	pkt := make([]byte, binary.BigEndian.Uint16(frameHdr[2:]))
+	if _, err := io.ReadFull(c.r, pkt); err != nil {
+		t.Fatalf("reading RTP packet: %v", err)
+	}
+	checkRTPJPEG(t, pkt)
+}
+
+func TestRTSPUDP(t *testing.T) {
+	startRTSPServer(t, 19882, 19883)
+
+	rtp, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("listening UDP: %v", err)
+	}
+	defer rtp.Close()
+	port := rtp.LocalAddr().(*net.UDPAddr).Port
+
+	c := dialRTSP(t, 19883)
+	url := "rtsp://127.0.0.1:19883/lobby"
+
+	code, hdr, _ := c.do("SETUP", url, fmt.Sprintf("Transport: RTP/AVP;unicast;client_port=%d-%d", port, port+1))
+	if code != 200 || !strings.Contains(hdr.Get("Transport"), "server_port=") {
+		t.Fatalf("SETUP: got %d %q", code, hdr.Get("Transport"))
+	}
+	session, _, _ := strings.Cut(hdr.Get("Session"), ";")
+
+	if code, _, _ = c.do("PLAY", url, "Session: "+session); code != 200 {
+		t.Fatalf("PLAY: got %d", code)
+	}
+
+	rtp.SetReadDeadline(time.Now().Add(5 * time.Second)) //nolint:errcheck
+	buf := make([]byte, 2048)
+	n, err := rtp.Read(buf)
+	if err != nil {
+		t.Fatalf("reading RTP over UDP: %v", err)
+	}
+	checkRTPJPEG(t, buf[:n])
+
+	if code, _, _ = c.do("TEARDOWN", url, "Session: "+session); code != 200 {
+		t.Fatalf("TEARDOWN: got %d", code)
+	}
+}
+
+func TestRTSPUnknownStream(t *testing.T) {
+	startRTSPServer(t, 19884, 19885)
+	c := dialRTSP(t, 19885)
+
+	if code, _, _ := c.do("DESCRIBE", "rtsp://localhost:19885/missing"); code != 404 {
+		t.Fatalf("expected 404 for unknown stream, got %d", code)
+	}
+}
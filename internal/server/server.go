@@ -3,50 +3,127 @@ package server
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"net"
 	"net/http"
+	"strings"
 	"sync"
 	"time"
-
-	"github.com/idevakk/mediastream/internal/media"
 )
 
-// Config holds all configuration needed to start a stream.
+// Config holds all configuration needed to start a server.
 type Config struct {
-	FilePath string
-	Port     int
-	// FrameRate is the target frames-per-second for the stream.
+	// FilePath is shorthand for a single stream named DefaultStreamName.
+	// It is ignored when Streams is non-empty.
+	FilePath string `json:"file,omitempty"`
+	// Files, if set instead of FilePath, plays several files in turn on
+	// the default stream, like a playlist.
+	Files []string `json:"files,omitempty"`
+	Port  int      `json:"port"`
+	// FrameRate is the default frames-per-second for every stream.
 	// Defaults to 30 if zero.
-	FrameRate int
+	FrameRate int `json:"fps,omitempty"`
+	// ClientQueue is how many frames are buffered per client before the
+	// oldest is dropped. Defaults to 2 if zero.
+	ClientQueue int `json:"client_queue,omitempty"`
+	// Start, End and Speed set the playback of the FilePath stream; see
+	// StreamConfig for their meaning.
+	Start float64 `json:"start,omitempty"`
+	End   float64 `json:"end,omitempty"`
+	Speed float64 `json:"speed,omitempty"`
+	// Matte is the default "#RRGGBB" color for transparent GIF pixels;
+	// see StreamConfig.
+	Matte string `json:"matte,omitempty"`
+	// SequenceCacheMB caps the memory each image-sequence stream uses to
+	// cache encoded frames. Defaults to 256 if zero.
+	SequenceCacheMB int `json:"sequence_cache_mb,omitempty"`
+	// Playlist holds the default playlist settings for every stream.
+	Playlist PlaylistConfig `json:"playlist"`
+	// Faults, if set, injects camera faults into every stream that
+	// doesn't configure its own; see FaultConfig.
+	Faults *FaultConfig `json:"faults,omitempty"`
+	// Transform, if set, re-renders every stream that doesn't configure
+	// its own; see TransformConfig.
+	Transform *TransformConfig `json:"transform,omitempty"`
+	// Watermark, if set, composites a logo onto every stream that
+	// doesn't configure its own; see WatermarkConfig.
+	Watermark *WatermarkConfig `json:"watermark,omitempty"`
+	// Overlays are drawn over every stream that doesn't configure its
+	// own; see OverlayConfig.
+	Overlays []OverlayConfig `json:"overlays,omitempty"`
+	// Streams lists the named streams to host. The first one is also
+	// served at /stream and /snapshot.jpg.
+	Streams []StreamConfig `json:"streams,omitempty"`
+	// HLS enables HLS output for every stream alongside MJPEG.
+	HLS HLSConfig `json:"hls"`
+	// RTSPPort, if non-zero, also serves every stream over RTSP at
+	// rtsp://host:RTSPPort/<name> as RTP/JPEG.
+	RTSPPort int `json:"rtsp_port,omitempty"`
+	// SourceRoot, if set, lets POST /api/source switch streams to files
+	// inside this directory. The API is unauthenticated, so it is off
+	// by default; the GUI calls SetSource directly and doesn't need it.
+	SourceRoot string `json:"source_root,omitempty"`
 }
 
-// Server manages the HTTP server and the active media source.
+// clientWriteTimeout bounds how long a single frame write may block before
+// the client is considered dead and disconnected.
+const clientWriteTimeout = 10 * time.Second
+
+// Server manages the HTTP server and the media sources of every stream.
 type Server struct {
-	cfg     Config
-	source  media.Source
-	httpSrv *http.Server
-	mu      sync.RWMutex
-	started bool
-	cancel  context.CancelFunc
+	cfg      Config
+	channels []*channel // in configuration order; channels[0] is the default
+	byName   map[string]*channel
+	httpSrv  *http.Server
+	mu       sync.RWMutex
+	started  bool
+	cancel   context.CancelFunc
 }
 
 // New creates and validates a new Server from the given Config.
-// It detects the media type from the file path and prepares the source.
+// It detects the media type of every stream and prepares its source.
 func New(cfg Config) (*Server, error) {
+	if err := cfg.validateRates(); err != nil {
+		return nil, err
+	}
 	if cfg.FrameRate == 0 {
 		cfg.FrameRate = 30
 	}
+	if cfg.ClientQueue == 0 {
+		cfg.ClientQueue = 2
+	}
 
-	src, err := media.Open(cfg.FilePath, cfg.FrameRate)
+	streams, err := cfg.streams()
 	if err != nil {
-		return nil, fmt.Errorf("opening media: %w", err)
+		return nil, err
+	}
+	if cfg.HLS.Enabled {
+		if cfg.HLS, err = cfg.HLS.withDefaults(); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.SourceRoot != "" {
+		if cfg.SourceRoot, err = resolveSourceRoot(cfg.SourceRoot); err != nil {
+			return nil, err
+		}
 	}
 
-	return &Server{cfg: cfg, source: src}, nil
+	s := &Server{cfg: cfg, byName: make(map[string]*channel, len(streams))}
+	for _, sc := range streams {
+		ch, err := openChannel(sc, cfg.HLS)
+		if err != nil {
+			s.closeSources() //nolint:errcheck
+			return nil, err
+		}
+		s.channels = append(s.channels, ch)
+		s.byName[sc.Name] = ch
+	}
+	return s, nil
 }
 
-// Start begins serving the MJPEG stream. It blocks until the server
+// Start begins serving every stream. It blocks until the server
 // is stopped via Stop or the context is cancelled.
 func (s *Server) Start() error {
 	s.mu.Lock()
@@ -56,12 +133,43 @@ func (s *Server) Start() error {
 	}
 	s.started = true
 
+	var rtsp *rtspServer
+	if s.cfg.RTSPPort != 0 {
+		var err error
+		if rtsp, err = listenRTSP(s, s.cfg.RTSPPort); err != nil {
+			s.started = false
+			s.mu.Unlock()
+			return err
+		}
+	}
+
 	ctx, cancel := context.WithCancel(context.Background())
 	s.cancel = cancel
 
+	if rtsp != nil {
+		go rtsp.serve(ctx)
+	}
+
+	// One producer per stream feeds every client of that stream.
+	for _, ch := range s.channels {
+		go ch.hub.run(ctx)
+		if ch.hls != nil {
+			go ch.hls.run(ctx)
+		}
+	}
+
+	def := s.channels[0]
 	mux := http.NewServeMux()
-	mux.HandleFunc("/stream", s.handleStream)
+	mux.HandleFunc("/stream", def.serveStream)
+	mux.HandleFunc("/snapshot", def.serveSnapshot)
+	mux.HandleFunc("/snapshot.jpg", def.serveSnapshot)
+	mux.HandleFunc("/hls/", func(w http.ResponseWriter, r *http.Request) {
+		def.serveHLS(w, r, strings.TrimPrefix(r.URL.Path, "/hls/"))
+	})
+	mux.HandleFunc("/streams/", s.handleStreams)
+	mux.HandleFunc("/api/", s.handleAPI)
 	mux.HandleFunc("/health", s.handleHealth)
+	mux.HandleFunc("/metrics", s.handleMetrics)
 
 	s.httpSrv = &http.Server{
 		Addr:    fmt.Sprintf(":%d", s.cfg.Port),
@@ -75,7 +183,7 @@ func (s *Server) Start() error {
 	return s.httpSrv.ListenAndServe()
 }
 
-// Stop gracefully shuts down the HTTP server and closes the media source.
+// Stop gracefully shuts down the HTTP server and closes every media source.
 func (s *Server) Stop() error {
 	s.mu.Lock()
 
@@ -95,12 +203,23 @@ func (s *Server) Stop() error {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	if err := s.source.Close(); err != nil {
-		return fmt.Errorf("closing media source: %w", err)
+	if err := s.closeSources(); err != nil {
+		return err
 	}
 	return httpSrv.Shutdown(ctx)
 }
 
+// closeSources closes the media source of every opened stream.
+func (s *Server) closeSources() error {
+	var errs []error
+	for _, ch := range s.channels {
+		if err := ch.close(); err != nil {
+			errs = append(errs, fmt.Errorf("closing media source for stream %q: %w", ch.cfg.Name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
 // IsRunning reports whether the server is currently active.
 func (s *Server) IsRunning() bool {
 	s.mu.RLock()
@@ -108,49 +227,86 @@ func (s *Server) IsRunning() bool {
 	return s.started
 }
 
-// StreamURL returns the full URL of the MJPEG stream endpoint.
+// StreamURL returns the full URL of the default MJPEG stream endpoint.
 func (s *Server) StreamURL() string {
 	return fmt.Sprintf("http://localhost:%d/stream", s.cfg.Port)
 }
 
-// handleStream is the HTTP handler that outputs an MJPEG stream.
-func (s *Server) handleStream(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "multipart/x-mixed-replace; boundary=mjpegframe")
-	w.Header().Set("Cache-Control", "no-cache, no-store, must-revalidate")
-	w.Header().Set("Connection", "keep-alive")
+// RTSPURL returns the RTSP URL of the named stream, or "" if RTSP output
+// is disabled.
+func (s *Server) RTSPURL(stream string) string {
+	if s.cfg.RTSPPort == 0 {
+		return ""
+	}
+	return fmt.Sprintf("rtsp://localhost:%d/%s", s.cfg.RTSPPort, stream)
+}
+
+// StreamNames returns the names of all hosted streams in configuration order.
+func (s *Server) StreamNames() []string {
+	names := make([]string, len(s.channels))
+	for i, ch := range s.channels {
+		names[i] = ch.cfg.Name
+	}
+	return names
+}
 
-	flusher, ok := w.(http.Flusher)
+// Clients returns per-client delivery stats for every viewer connected to
+// the named stream, or nil if there is no such stream.
+func (s *Server) Clients(stream string) []ClientStats {
+	ch, ok := s.byName[stream]
 	if !ok {
-		http.Error(w, "streaming not supported by this client", http.StatusInternalServerError)
+		return nil
+	}
+	return ch.clients()
+}
+
+// handleStreams routes /streams/<name>, /streams/<name>/snapshot.jpg and
+// /streams/<name>/hls/<file> to the matching channel.
+func (s *Server) handleStreams(w http.ResponseWriter, r *http.Request) {
+	name, rest, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/streams/"), "/")
+
+	ch, ok := s.byName[name]
+	if !ok {
+		http.NotFound(w, r)
 		return
 	}
 
-	interval := time.Duration(float64(time.Second) / float64(s.cfg.FrameRate))
-	ticker := time.NewTicker(interval)
-	defer ticker.Stop()
-
-	for {
-		select {
-		case <-r.Context().Done():
-			return
-		case <-ticker.C:
-			frame, err := s.source.NextFrame()
-			if err != nil {
-				return
-			}
-
-			fmt.Fprintf(w, "--mjpegframe\r\n")
-			fmt.Fprintf(w, "Content-Type: image/jpeg\r\n")
-			fmt.Fprintf(w, "Content-Length: %d\r\n\r\n", len(frame))
-			w.Write(frame) //nolint:errcheck
-			fmt.Fprintf(w, "\r\n")
-			flusher.Flush()
-		}
+	if file, ok := strings.CutPrefix(rest, "hls/"); ok {
+		ch.serveHLS(w, r, file)
+		return
+	}
+
+	switch rest {
+	case "":
+		ch.serveStream(w, r)
+	case "snapshot", "snapshot.jpg":
+		ch.serveSnapshot(w, r)
+	default:
+		http.NotFound(w, r)
 	}
 }
 
-// handleHealth returns a simple 200 OK for health checks.
+// healthResponse is the JSON body returned by /health.
+type healthResponse struct {
+	Status  string         `json:"status"`
+	Port    int            `json:"port"`
+	Streams []streamHealth `json:"streams"`
+}
+
+// handleHealth returns a 200 OK describing every stream and its clients,
+// so slow consumers show up as a growing frames_dropped count. Status is
+// "degraded" while any source is recovering from a failure.
 func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
+	resp := healthResponse{Status: "ok", Port: s.cfg.Port}
+	for _, ch := range s.channels {
+		sh := ch.health()
+		if sh.Outage || sh.Source != nil && !sh.Source.Running {
+			resp.Status = "degraded"
+		}
+		resp.Streams = append(resp.Streams, sh)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
-	fmt.Fprintf(w, `{"status":"ok","port":%d}`, s.cfg.Port)
+	json.NewEncoder(w).Encode(resp) //nolint:errcheck
 }
@@ -1,14 +1,22 @@
 package server_test
 
 import (
+	"encoding/json"
 	"fmt"
 	"image"
 	"image/color"
 	"image/jpeg"
+	"image/png"
 	"io"
+	"math"
+	"mime"
+	"mime/multipart"
 	"net/http"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"runtime"
+	"strings"
 	"testing"
 	"time"
 
@@ -106,3 +114,856 @@ func TestStreamEndpointHeaders(t *testing.T) {
 		t.Fatal("expected Content-Type header")
 	}
 }
+
+func TestSnapshotEndpoint(t *testing.T) {
+	jpg := writeTestJPEG(t)
+	cfg := server.Config{FilePath: jpg, Port: 19873, FrameRate: 5}
+
+	srv, err := server.New(cfg)
+	if err != nil {
+		t.Fatalf("server.New: %v", err)
+	}
+	go srv.Start() //nolint:errcheck
+	time.Sleep(80 * time.Millisecond)
+	defer srv.Stop() //nolint:errcheck
+
+	url := fmt.Sprintf("http://localhost:%d/snapshot.jpg", cfg.Port)
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatalf("GET /snapshot.jpg: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d", resp.StatusCode)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "image/jpeg" {
+		t.Fatalf("expected image/jpeg, got %q", ct)
+	}
+	if resp.ContentLength != int64(len(body)) || len(body) < 2 || body[0] != 0xFF || body[1] != 0xD8 {
+		t.Fatalf("expected a complete JPEG body, got %d bytes (Content-Length %d)", len(body), resp.ContentLength)
+	}
+
+	etag := resp.Header.Get("ETag")
+	if etag == "" {
+		t.Fatal("expected ETag for a static image")
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, url, nil)
+	req.Header.Set("If-None-Match", etag)
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("conditional GET: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotModified {
+		t.Fatalf("expected 304 for matching ETag, got %d", resp.StatusCode)
+	}
+}
+
+func TestMultipleNamedStreams(t *testing.T) {
+	jpg := writeTestJPEG(t)
+	cfg := server.Config{
+		Port: 19874,
+		Streams: []server.StreamConfig{
+			{Name: "lobby", FilePath: jpg},
+			{Name: "door", FilePath: jpg, FrameRate: 5},
+		},
+	}
+
+	srv, err := server.New(cfg)
+	if err != nil {
+		t.Fatalf("server.New: %v", err)
+	}
+	go srv.Start() //nolint:errcheck
+	time.Sleep(80 * time.Millisecond)
+	defer srv.Stop() //nolint:errcheck
+
+	for _, path := range []string{"/streams/lobby/snapshot.jpg", "/streams/door/snapshot.jpg", "/snapshot.jpg"} {
+		resp, err := http.Get(fmt.Sprintf("http://localhost:%d%s", cfg.Port, path))
+		if err != nil {
+			t.Fatalf("GET %s: %v", path, err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("GET %s: expected 200, got %d", path, resp.StatusCode)
+		}
+	}
+
+	resp, err := http.Get(fmt.Sprintf("http://localhost:%d/streams/missing", cfg.Port))
+	if err != nil {
+		t.Fatalf("GET /streams/missing: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("expected 404 for unknown stream, got %d", resp.StatusCode)
+	}
+
+	resp, err = http.Get(fmt.Sprintf("http://localhost:%d/health", cfg.Port))
+	if err != nil {
+		t.Fatalf("GET /health: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var health struct {
+		Streams []struct {
+			Name      string `json:"name"`
+			FrameRate int    `json:"fps"`
+		} `json:"streams"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&health); err != nil {
+		t.Fatalf("decoding /health: %v", err)
+	}
+	if len(health.Streams) != 2 || health.Streams[0].Name != "lobby" || health.Streams[1].FrameRate != 5 {
+		t.Fatalf("unexpected streams in /health: %+v", health.Streams)
+	}
+}
+
+func TestNewRejectsDuplicateStreamNames(t *testing.T) {
+	jpg := writeTestJPEG(t)
+	_, err := server.New(server.Config{Streams: []server.StreamConfig{
+		{Name: "cam", FilePath: jpg},
+		{Name: "cam", FilePath: jpg},
+	}})
+	if err == nil {
+		t.Fatal("expected error for duplicate stream names")
+	}
+}
+
+func TestNewRejectsInvalidMatte(t *testing.T) {
+	_, err := server.New(server.Config{FilePath: writeTestJPEG(t), Matte: "#12345"})
+	if err == nil {
+		t.Fatal("expected error for invalid matte color")
+	}
+}
+
+func TestParseStreamSpec(t *testing.T) {
+	sc, err := server.ParseStreamSpec("door@5=/media/door.jpg")
+	if err != nil {
+		t.Fatalf("ParseStreamSpec: %v", err)
+	}
+	if sc.Name != "door" || sc.FrameRate != 5 || sc.FilePath != "/media/door.jpg" {
+		t.Fatalf("unexpected stream config: %+v", sc)
+	}
+
+	for _, bad := range []string{"lobby", "lobby=", "door@x=door.jpg"} {
+		if _, err := server.ParseStreamSpec(bad); err == nil {
+			t.Fatalf("expected error for %q", bad)
+		}
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "streams.json")
+	data := `{"port": 9000, "streams": [{"name": "lobby", "file": "lobby.mp4", "fps": 15}]}`
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatalf("writing config: %v", err)
+	}
+
+	cfg, err := server.LoadConfig(path)
+	if err != nil {
+		t.Fatalf("LoadConfig: %v", err)
+	}
+	if cfg.Port != 9000 || len(cfg.Streams) != 1 || cfg.Streams[0].FrameRate != 15 {
+		t.Fatalf("unexpected config: %+v", cfg)
+	}
+}
+
+func TestRejectsNegativeRates(t *testing.T) {
+	jpg := writeTestJPEG(t)
+	for _, cfg := range []server.Config{
+		{FilePath: jpg, FrameRate: -1},
+		{FilePath: jpg, ClientQueue: -1},
+		{Streams: []server.StreamConfig{{Name: "cam", FilePath: jpg, FrameRate: -5}}},
+		{Streams: []server.StreamConfig{{Name: "cam", FilePath: jpg, ClientQueue: -2}}},
+	} {
+		if _, err := server.New(cfg); err == nil {
+			t.Errorf("New(%+v): expected an error", cfg)
+		}
+	}
+
+	path := filepath.Join(t.TempDir(), "streams.json")
+	data := `{"streams": [{"name": "lobby", "file": "lobby.mp4", "fps": -1}]}`
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatalf("writing config: %v", err)
+	}
+	if _, err := server.LoadConfig(path); err == nil {
+		t.Error("LoadConfig: expected an error for a negative fps")
+	}
+}
+
+func TestHLSDisabledByDefault(t *testing.T) {
+	jpg := writeTestJPEG(t)
+	cfg := server.Config{FilePath: jpg, Port: 19875}
+
+	srv, err := server.New(cfg)
+	if err != nil {
+		t.Fatalf("server.New: %v", err)
+	}
+	go srv.Start() //nolint:errcheck
+	time.Sleep(80 * time.Millisecond)
+	defer srv.Stop() //nolint:errcheck
+
+	resp, err := http.Get(fmt.Sprintf("http://localhost:%d/hls/index.m3u8", cfg.Port))
+	if err != nil {
+		t.Fatalf("GET /hls/index.m3u8: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("expected 404 with HLS disabled, got %d", resp.StatusCode)
+	}
+}
+
+func TestHLSPlaylist(t *testing.T) {
+	if _, err := exec.LookPath("ffmpeg"); err != nil {
+		t.Skip("ffmpeg not installed")
+	}
+
+	jpg := writeTestJPEG(t)
+	cfg := server.Config{
+		FilePath:  jpg,
+		Port:      19876,
+		FrameRate: 10,
+		HLS:       server.HLSConfig{Enabled: true, SegmentSeconds: 1},
+	}
+
+	srv, err := server.New(cfg)
+	if err != nil {
+		t.Fatalf("server.New: %v", err)
+	}
+	go srv.Start()   //nolint:errcheck
+	defer srv.Stop() //nolint:errcheck
+
+	url := fmt.Sprintf("http://localhost:%d/hls/index.m3u8", cfg.Port)
+	deadline := time.Now().Add(15 * time.Second)
+	for time.Now().Before(deadline) {
+		time.Sleep(250 * time.Millisecond)
+
+		resp, err := http.Get(url)
+		if err != nil {
+			continue
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+
+		if resp.StatusCode == http.StatusOK {
+			if !strings.HasPrefix(string(body), "#EXTM3U") {
+				t.Fatalf("unexpected playlist: %q", body)
+			}
+			return
+		}
+	}
+	t.Fatal("HLS playlist never became available")
+}
+
+func TestHLSFeedIsNotAViewer(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("fake ffmpeg is a shell script")
+	}
+	// The fake FFmpeg swallows the frames piped into it.
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "ffmpeg"), []byte("#!/bin/sh\nexec cat > /dev/null\n"), 0o755); err != nil {
+		t.Fatalf("writing fake ffmpeg: %v", err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	cfg := server.Config{
+		FilePath:  writeTestJPEG(t),
+		Port:      19900,
+		FrameRate: 20,
+		HLS:       server.HLSConfig{Enabled: true},
+	}
+	srv, err := server.New(cfg)
+	if err != nil {
+		t.Fatalf("server.New: %v", err)
+	}
+	go srv.Start() //nolint:errcheck
+	time.Sleep(300 * time.Millisecond)
+	defer srv.Stop() //nolint:errcheck
+
+	resp, err := http.Get(fmt.Sprintf("http://localhost:%d/health", cfg.Port))
+	if err != nil {
+		t.Fatalf("GET /health: %v", err)
+	}
+	var health struct {
+		Streams []struct {
+			Clients []server.ClientStats `json:"clients"`
+		} `json:"streams"`
+	}
+	json.NewDecoder(resp.Body).Decode(&health) //nolint:errcheck
+	resp.Body.Close()
+	if len(health.Streams) != 1 || len(health.Streams[0].Clients) != 0 {
+		t.Errorf("expected no clients in /health, got %+v", health.Streams)
+	}
+
+	resp, err = http.Get(fmt.Sprintf("http://localhost:%d/metrics", cfg.Port))
+	if err != nil {
+		t.Fatalf("GET /metrics: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	for _, want := range []string{
+		`mediastream_clients{stream="default"} 0` + "\n",
+		`mediastream_frames_sent_total{stream="default"} 0` + "\n",
+		`mediastream_bytes_sent_total{stream="default"} 0` + "\n",
+	} {
+		if !strings.Contains(string(body), want) {
+			t.Errorf("metrics missing %q:\n%s", want, body)
+		}
+	}
+}
+
+// writeColorJPEG creates a 1x1 JPEG of the given color.
+func writeColorJPEG(t *testing.T, c color.Color) string {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, 1, 1))
+	img.Set(0, 0, c)
+
+	path := filepath.Join(t.TempDir(), "color.jpg")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("writing test JPEG: %v", err)
+	}
+	defer f.Close()
+
+	if err := jpeg.Encode(f, img, nil); err != nil {
+		t.Fatalf("encoding test JPEG: %v", err)
+	}
+	return path
+}
+
+func TestControlAPI(t *testing.T) {
+	jpg := writeTestJPEG(t)
+	white := writeColorJPEG(t, color.White)
+	cfg := server.Config{FilePath: jpg, Port: 19877, FrameRate: 20, SourceRoot: filepath.Dir(white)}
+
+	srv, err := server.New(cfg)
+	if err != nil {
+		t.Fatalf("server.New: %v", err)
+	}
+	go srv.Start() //nolint:errcheck
+	time.Sleep(80 * time.Millisecond)
+	defer srv.Stop() //nolint:errcheck
+
+	base := fmt.Sprintf("http://localhost:%d", cfg.Port)
+	snapshot := func() string {
+		resp, err := http.Get(base + "/snapshot.jpg")
+		if err != nil {
+			t.Fatalf("GET /snapshot.jpg: %v", err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return string(body)
+	}
+	post := func(path, body string) (int, server.StreamStatus) {
+		resp, err := http.Post(base+path, "application/json", strings.NewReader(body))
+		if err != nil {
+			t.Fatalf("POST %s: %v", path, err)
+		}
+		defer resp.Body.Close()
+		var st server.StreamStatus
+		json.NewDecoder(resp.Body).Decode(&st) //nolint:errcheck
+		return resp.StatusCode, st
+	}
+
+	// A viewer connected across the swap must stay connected.
+	stream, err := http.Get(base + "/stream")
+	if err != nil {
+		t.Fatalf("GET /stream: %v", err)
+	}
+	defer stream.Body.Close()
+
+	if code, st := post("/api/pause", ""); code != http.StatusOK || !st.Paused {
+		t.Fatalf("pause: got %d %+v", code, st)
+	}
+	if code, _ := post("/api/seek?t=1.5", ""); code != http.StatusBadRequest {
+		t.Fatalf("seek on a still image: expected 400, got %d", code)
+	}
+	if code, _ := post("/api/speed?x=2", ""); code != http.StatusBadRequest {
+		t.Fatalf("speed on a still image: expected 400, got %d", code)
+	}
+	for _, path := range []string{"/api/seek?t=NaN", "/api/seek?t=Inf", "/api/clip?start=NaN", "/api/clip?end=infinity"} {
+		resp, err := http.Post(base+path, "application/json", nil)
+		if err != nil {
+			t.Fatalf("POST %s: %v", path, err)
+		}
+		var body struct {
+			Error string `json:"error"`
+		}
+		json.NewDecoder(resp.Body).Decode(&body) //nolint:errcheck
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusBadRequest || !strings.Contains(body.Error, "number") {
+			t.Fatalf("%s: expected a 400 about the number, got %d %q", path, resp.StatusCode, body.Error)
+		}
+	}
+
+	before := snapshot()
+	if code, st := post("/api/source", fmt.Sprintf(`{"file": %q}`, white)); code != http.StatusOK || st.FilePath != white {
+		t.Fatalf("source: got %d %+v", code, st)
+	}
+	if snapshot() == before {
+		t.Fatal("snapshot did not change after swapping the source")
+	}
+
+	if code, st := post("/api/resume", ""); code != http.StatusOK || st.Paused {
+		t.Fatalf("resume: got %d %+v", code, st)
+	}
+	if code, _ := post("/api/pause?stream=missing", ""); code != http.StatusNotFound {
+		t.Fatalf("unknown stream: expected 404, got %d", code)
+	}
+
+	buf := make([]byte, 1)
+	if _, err := stream.Body.Read(buf); err != nil {
+		t.Fatalf("stream was disconnected by the source swap: %v", err)
+	}
+}
+
+func TestSourceAPIRestrictedToRoot(t *testing.T) {
+	jpg := writeTestJPEG(t)
+	root := filepath.Dir(writeColorJPEG(t, color.White))
+	outside := writeColorJPEG(t, color.Black)
+	if err := os.WriteFile(filepath.Join(root, "notes.bin"), []byte("\x00\x01 not media"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(outside, filepath.Join(root, "link.jpg")); err != nil {
+		t.Fatal(err)
+	}
+
+	for i, tc := range []struct {
+		root  string
+		file  string
+		code  int
+		leaks string // must not appear in the response
+	}{
+		{"", "color.jpg", http.StatusForbidden, ""},
+		{root, "color.jpg", http.StatusOK, ""},
+		{root, outside, http.StatusBadRequest, outside},
+		{root, "../" + filepath.Base(filepath.Dir(outside)) + "/color.jpg", http.StatusBadRequest, outside},
+		{root, "link.jpg", http.StatusBadRequest, outside},
+		{root, "missing.jpg", http.StatusBadRequest, root},
+		{root, "notes.bin", http.StatusUnsupportedMediaType, root},
+	} {
+		cfg := server.Config{FilePath: jpg, Port: 19892 + i, FrameRate: 20, SourceRoot: tc.root}
+		srv, err := server.New(cfg)
+		if err != nil {
+			t.Fatalf("server.New: %v", err)
+		}
+		go srv.Start() //nolint:errcheck
+		time.Sleep(80 * time.Millisecond)
+
+		resp, err := http.Post(fmt.Sprintf("http://localhost:%d/api/source", cfg.Port),
+			"application/json", strings.NewReader(fmt.Sprintf(`{"file": %q}`, tc.file)))
+		if err != nil {
+			t.Fatalf("POST /api/source: %v", err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		srv.Stop() //nolint:errcheck
+
+		if resp.StatusCode != tc.code {
+			t.Errorf("%q (root %q): expected %d, got %d %s", tc.file, tc.root, tc.code, resp.StatusCode, body)
+		}
+		if tc.leaks != "" && strings.Contains(string(body), tc.leaks) {
+			t.Errorf("%q: response names a path: %s", tc.file, body)
+		}
+	}
+}
+
+func TestFaultInjectionResetsViewers(t *testing.T) {
+	jpg := writeTestJPEG(t)
+	cfg := server.Config{
+		FilePath:  jpg,
+		Port:      19886,
+		FrameRate: 20,
+		Faults:    &server.FaultConfig{Reset: server.FaultSpec{Every: 0.3}},
+	}
+
+	srv, err := server.New(cfg)
+	if err != nil {
+		t.Fatalf("server.New: %v", err)
+	}
+	go srv.Start() //nolint:errcheck
+	time.Sleep(80 * time.Millisecond)
+	defer srv.Stop() //nolint:errcheck
+
+	resp, err := http.Get(fmt.Sprintf("http://localhost:%d/stream", cfg.Port))
+	if err != nil {
+		t.Fatalf("GET /stream: %v", err)
+	}
+	defer resp.Body.Close()
+
+	// The reset must cut the stream off mid-body, not end it cleanly.
+	done := make(chan error, 1)
+	go func() {
+		_, err := io.Copy(io.Discard, resp.Body)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Fatal("expected the stream to be aborted, got a clean end")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("stream was not reset")
+	}
+}
+
+func TestFaultInjectionOutage(t *testing.T) {
+	jpg := writeTestJPEG(t)
+	cfg := server.Config{
+		FilePath:  jpg,
+		Port:      19887,
+		FrameRate: 20,
+		Faults:    &server.FaultConfig{Outage: server.FaultSpec{Every: 0.2, For: 10}},
+	}
+
+	srv, err := server.New(cfg)
+	if err != nil {
+		t.Fatalf("server.New: %v", err)
+	}
+	go srv.Start() //nolint:errcheck
+	time.Sleep(400 * time.Millisecond)
+	defer srv.Stop() //nolint:errcheck
+
+	base := fmt.Sprintf("http://localhost:%d", cfg.Port)
+	for _, path := range []string{"/stream", "/snapshot.jpg"} {
+		resp, err := http.Get(base + path)
+		if err != nil {
+			t.Fatalf("GET %s: %v", path, err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusServiceUnavailable {
+			t.Fatalf("GET %s during outage: expected 503, got %d", path, resp.StatusCode)
+		}
+	}
+
+	resp, err := http.Get(base + "/health")
+	if err != nil {
+		t.Fatalf("GET /health: %v", err)
+	}
+	defer resp.Body.Close()
+	var health struct {
+		Status  string `json:"status"`
+		Streams []struct {
+			Outage bool `json:"outage"`
+		} `json:"streams"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&health); err != nil {
+		t.Fatalf("decoding /health: %v", err)
+	}
+	if health.Status != "degraded" || len(health.Streams) != 1 || !health.Streams[0].Outage {
+		t.Fatalf("expected a degraded stream in outage, got %+v", health)
+	}
+}
+
+func TestParseFaults(t *testing.T) {
+	fc, err := server.ParseFaults("seed=42,drop.chance=0.05,outage.every=60,outage.for=10")
+	if err != nil {
+		t.Fatalf("ParseFaults: %v", err)
+	}
+	if fc.Seed != 42 || fc.Drop.Chance != 0.05 || fc.Outage.Every != 60 || fc.Outage.For != 10 {
+		t.Fatalf("unexpected fault config: %+v", fc)
+	}
+
+	for _, bad := range []string{"drop", "smoke.chance=1", "drop.often=1", "drop.chance=2", "seed=x",
+		"drop.chance=NaN", "outage.every=Inf", "freeze.for=NaN", "latency.every=-Inf"} {
+		if _, err := server.ParseFaults(bad); err == nil {
+			t.Fatalf("expected error for %q", bad)
+		}
+	}
+}
+
+// firstPart reads the first JPEG of an MJPEG stream response.
+func firstPart(t *testing.T, resp *http.Response) image.Image {
+	t.Helper()
+	_, params, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if err != nil {
+		t.Fatalf("parsing Content-Type: %v", err)
+	}
+	part, err := multipart.NewReader(resp.Body, params["boundary"]).NextPart()
+	if err != nil {
+		t.Fatalf("reading frame: %v", err)
+	}
+	img, err := jpeg.Decode(part)
+	if err != nil {
+		t.Fatalf("decoding frame: %v", err)
+	}
+	return img
+}
+
+func TestStreamVariants(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 64, 48))
+	path := filepath.Join(t.TempDir(), "frame.jpg")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := jpeg.Encode(f, img, nil); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	cfg := server.Config{FilePath: path, Port: 19888, FrameRate: 10}
+	srv, err := server.New(cfg)
+	if err != nil {
+		t.Fatalf("server.New: %v", err)
+	}
+	go srv.Start() //nolint:errcheck
+	time.Sleep(80 * time.Millisecond)
+	defer srv.Stop() //nolint:errcheck
+
+	url := fmt.Sprintf("http://localhost:%d/stream?w=32&q=50&fps=5", cfg.Port)
+	for i := 0; i < 2; i++ {
+		resp, err := http.Get(url)
+		if err != nil {
+			t.Fatalf("GET /stream: %v", err)
+		}
+		defer resp.Body.Close()
+		if got := firstPart(t, resp).Bounds().Size(); got != image.Pt(32, 24) {
+			t.Errorf("client %d: frame size = %v, want (32,24)", i, got)
+		}
+	}
+
+	plain, err := http.Get(fmt.Sprintf("http://localhost:%d/stream", cfg.Port))
+	if err != nil {
+		t.Fatalf("GET /stream: %v", err)
+	}
+	defer plain.Body.Close()
+	firstPart(t, plain)
+
+	clients := srv.Clients(server.DefaultStreamName)
+	if len(clients) != 3 {
+		t.Fatalf("expected 3 clients, got %d", len(clients))
+	}
+	if clients[0].Variant != "" || clients[1].Variant == "" || clients[1].Variant != clients[2].Variant {
+		t.Errorf("expected one plain client and two on one variant, got %+v", clients)
+	}
+	if ids := map[uint64]bool{clients[0].ID: true, clients[1].ID: true, clients[2].ID: true}; len(ids) != 3 {
+		t.Errorf("expected distinct client IDs, got %+v", clients)
+	}
+
+	for _, bad := range []string{"w=0", "h=abc", "q=101", "fps=-1", "fit=squash"} {
+		resp, err := http.Get(fmt.Sprintf("http://localhost:%d/stream?%s", cfg.Port, bad))
+		if err != nil {
+			t.Fatalf("GET /stream?%s: %v", bad, err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", bad, resp.StatusCode)
+		}
+	}
+}
+
+func TestStreamTransform(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 64, 48))
+	path := filepath.Join(t.TempDir(), "frame.jpg")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := jpeg.Encode(f, img, nil); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	cfg := server.Config{
+		FilePath:  path,
+		Port:      19889,
+		FrameRate: 10,
+		Transform: &server.TransformConfig{Crop: "48x48+8+0", Rotate: 90, Size: "30x20", Fit: "letterbox", Grayscale: true},
+	}
+	srv, err := server.New(cfg)
+	if err != nil {
+		t.Fatalf("server.New: %v", err)
+	}
+	go srv.Start() //nolint:errcheck
+	time.Sleep(80 * time.Millisecond)
+	defer srv.Stop() //nolint:errcheck
+
+	resp, err := http.Get(fmt.Sprintf("http://localhost:%d/snapshot.jpg", cfg.Port))
+	if err != nil {
+		t.Fatalf("GET /snapshot.jpg: %v", err)
+	}
+	defer resp.Body.Close()
+	out, err := jpeg.DecodeConfig(resp.Body)
+	if err != nil {
+		t.Fatalf("decoding snapshot: %v", err)
+	}
+	if out.Width != 30 || out.Height != 20 {
+		t.Errorf("snapshot is %dx%d, want 30x20", out.Width, out.Height)
+	}
+}
+
+func TestNewRejectsInvalidTransform(t *testing.T) {
+	jpg := writeTestJPEG(t)
+	for _, tc := range []server.TransformConfig{
+		{Size: "640"},
+		{Size: "x"},
+		{Size: "0x480"},
+		{Size: "99999x480"},
+		{Crop: "100x100"},
+		{Crop: "0x10+0+0"},
+		{Rotate: 45},
+		{Flip: "diagonal"},
+		{Fit: "squash"},
+		{Quality: 101},
+	} {
+		tc := tc
+		if _, err := server.New(server.Config{FilePath: jpg, Port: 19899, Transform: &tc}); err == nil {
+			t.Errorf("expected an error for %+v", tc)
+		}
+	}
+}
+
+func TestStreamOverlays(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "black.jpg")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := jpeg.Encode(f, image.NewRGBA(image.Rect(0, 0, 320, 240)), nil); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	cfg := server.Config{
+		FilePath:  path,
+		Port:      19890,
+		FrameRate: 10,
+		Overlays: []server.OverlayConfig{
+			{Type: "timestamp", TimeZone: "UTC", Box: true},
+			{Type: "label", Text: "CAM 01"},
+		},
+	}
+	srv, err := server.New(cfg)
+	if err != nil {
+		t.Fatalf("server.New: %v", err)
+	}
+	go srv.Start() //nolint:errcheck
+	time.Sleep(80 * time.Millisecond)
+	defer srv.Stop() //nolint:errcheck
+
+	resp, err := http.Get(fmt.Sprintf("http://localhost:%d/snapshot.jpg", cfg.Port))
+	if err != nil {
+		t.Fatalf("GET /snapshot.jpg: %v", err)
+	}
+	defer resp.Body.Close()
+	img, err := jpeg.Decode(resp.Body)
+	if err != nil {
+		t.Fatalf("decoding snapshot: %v", err)
+	}
+
+	// The timestamp is drawn top-left and the label bottom-left.
+	for _, r := range []image.Rectangle{image.Rect(0, 0, 160, 40), image.Rect(0, 200, 160, 240)} {
+		bright := false
+		for y := r.Min.Y; y < r.Max.Y && !bright; y++ {
+			for x := r.Min.X; x < r.Max.X; x++ {
+				if c := color.GrayModel.Convert(img.At(x, y)).(color.Gray); c.Y > 0x80 {
+					bright = true
+					break
+				}
+			}
+		}
+		if !bright {
+			t.Errorf("expected overlay text in %v", r)
+		}
+	}
+}
+
+func TestNewRejectsInvalidWatermark(t *testing.T) {
+	jpg := writeTestJPEG(t)
+	opacity, nan := 1.5, math.NaN()
+	for _, wc := range []server.WatermarkConfig{
+		{},
+		{File: filepath.Join(t.TempDir(), "missing.png")},
+		{File: jpg}, // not a PNG
+	} {
+		wc := wc
+		if _, err := server.New(server.Config{FilePath: jpg, Port: 19899, Watermark: &wc}); err == nil {
+			t.Errorf("expected an error for %+v", wc)
+		}
+	}
+
+	path := filepath.Join(t.TempDir(), "logo.png")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := png.Encode(f, image.NewNRGBA(image.Rect(0, 0, 4, 4))); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	for _, wc := range []server.WatermarkConfig{
+		{File: path, Opacity: &opacity},
+		{File: path, Opacity: &nan},
+		{File: path, Scale: 2},
+		{File: path, Scale: math.NaN()},
+		{File: path, Margin: -1},
+		{File: path, Position: "outside"},
+	} {
+		wc := wc
+		if _, err := server.New(server.Config{FilePath: jpg, Port: 19899, Watermark: &wc}); err == nil {
+			t.Errorf("expected an error for %+v", wc)
+		}
+	}
+	if _, err := server.New(server.Config{FilePath: jpg, Port: 19899, Watermark: &server.WatermarkConfig{File: path}}); err != nil {
+		t.Errorf("valid watermark: %v", err)
+	}
+}
+
+func TestMetricsEndpoint(t *testing.T) {
+	jpg := writeTestJPEG(t)
+	cfg := server.Config{FilePath: jpg, Port: 19891, FrameRate: 20}
+
+	srv, err := server.New(cfg)
+	if err != nil {
+		t.Fatalf("server.New: %v", err)
+	}
+	go srv.Start() //nolint:errcheck
+	time.Sleep(80 * time.Millisecond)
+	defer srv.Stop() //nolint:errcheck
+
+	stream, err := http.Get(fmt.Sprintf("http://localhost:%d/stream", cfg.Port))
+	if err != nil {
+		t.Fatalf("GET /stream: %v", err)
+	}
+	defer stream.Body.Close()
+	firstPart(t, stream)
+	time.Sleep(150 * time.Millisecond)
+
+	resp, err := http.Get(fmt.Sprintf("http://localhost:%d/metrics", cfg.Port))
+	if err != nil {
+		t.Fatalf("GET /metrics: %v", err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	text := string(body)
+
+	for _, want := range []string{
+		"# TYPE mediastream_clients gauge\n",
+		`mediastream_clients{stream="default"} 1` + "\n",
+		`mediastream_source_errors_total{stream="default"} 0` + "\n",
+		`mediastream_client_frames_sent_total{stream="default",client="1",`,
+		`mediastream_encode_seconds_count{stage="image"}`,
+	} {
+		if !strings.Contains(text, want) {
+			t.Errorf("metrics missing %q:\n%s", want, text)
+		}
+	}
+	for _, counter := range []string{"mediastream_frames_produced_total", "mediastream_frames_sent_total", "mediastream_bytes_sent_total"} {
+		prefix := counter + `{stream="default"} `
+		i := strings.Index(text, prefix)
+		if i < 0 {
+			t.Errorf("metrics missing %s", counter)
+			continue
+		}
+		line := text[i+len(prefix):]
+		if strings.HasPrefix(line, "0\n") {
+			t.Errorf("expected %s to have counted frames", counter)
+		}
+	}
+}
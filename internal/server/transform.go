@@ -0,0 +1,107 @@
+package server
+
+import (
+	"fmt"
+	"image"
+	"regexp"
+	"strconv"
+
+	"github.com/idevakk/mediastream/internal/pipeline"
+)
+
+// TransformConfig re-renders every frame of a stream, for consumers that
+// expect a fixed resolution or orientation the source doesn't have. The
+// transforms run in the order crop, rotate, flip, resize, grayscale.
+type TransformConfig struct {
+	// Size is the output resolution as WIDTHxHEIGHT, e.g. "640x480".
+	// Leaving out one side, as in "640x", keeps the aspect ratio.
+	Size string `json:"size,omitempty"`
+	// Fit is how frames map onto Size: contain (default), cover, fill or
+	// letterbox.
+	Fit string `json:"fit,omitempty"`
+	// Crop cuts the source frame down to WIDTHxHEIGHT+X+Y before anything
+	// else, e.g. "1280x720+320+180".
+	Crop string `json:"crop,omitempty"`
+	// Rotate turns frames clockwise: 90, 180 or 270 degrees.
+	Rotate int `json:"rotate,omitempty"`
+	// Flip mirrors frames: horizontal, vertical or both.
+	Flip      string `json:"flip,omitempty"`
+	Grayscale bool   `json:"grayscale,omitempty"`
+	// Quality is the output JPEG quality, 1 to 100. Defaults to
+	// pipeline.DefaultQuality if zero.
+	Quality int `json:"quality,omitempty"`
+}
+
+var (
+	sizePattern = regexp.MustCompile(`^(\d{0,5})x(\d{0,5})$`)
+	cropPattern = regexp.MustCompile(`^(\d{1,5})x(\d{1,5})\+(\d{1,5})\+(\d{1,5})$`)
+)
+
+// pipeline validates the config and builds the pipeline it describes,
+// without overlays.
+func (tc TransformConfig) pipeline() (*pipeline.Pipeline, error) {
+	var ts []pipeline.Transform
+
+	if tc.Crop != "" {
+		m := cropPattern.FindStringSubmatch(tc.Crop)
+		if m == nil {
+			return nil, fmt.Errorf("invalid crop %q: expected WIDTHxHEIGHT+X+Y", tc.Crop)
+		}
+		w, h, x, y := atoi(m[1]), atoi(m[2]), atoi(m[3]), atoi(m[4])
+		if w == 0 || h == 0 {
+			return nil, fmt.Errorf("invalid crop %q: width and height must not be zero", tc.Crop)
+		}
+		ts = append(ts, pipeline.Crop{Rect: image.Rect(x, y, x+w, y+h)})
+	}
+
+	switch tc.Rotate {
+	case 0:
+	case 90, 180, 270:
+		ts = append(ts, pipeline.Rotate{Degrees: tc.Rotate})
+	default:
+		return nil, fmt.Errorf("invalid rotation %d: use 90, 180 or 270", tc.Rotate)
+	}
+
+	switch tc.Flip {
+	case "":
+	case "horizontal":
+		ts = append(ts, pipeline.Flip{Horizontal: true})
+	case "vertical":
+		ts = append(ts, pipeline.Flip{Vertical: true})
+	case "both":
+		ts = append(ts, pipeline.Flip{Horizontal: true, Vertical: true})
+	default:
+		return nil, fmt.Errorf("invalid flip %q: use horizontal, vertical or both", tc.Flip)
+	}
+
+	fit, err := pipeline.ParseFit(tc.Fit)
+	if err != nil {
+		return nil, err
+	}
+	if tc.Size != "" {
+		m := sizePattern.FindStringSubmatch(tc.Size)
+		if m == nil || m[1] == "" && m[2] == "" {
+			return nil, fmt.Errorf("invalid size %q: expected WIDTHxHEIGHT, e.g. 640x480", tc.Size)
+		}
+		r := pipeline.Resize{Width: atoi(m[1]), Height: atoi(m[2]), Fit: fit}
+		if r.Width > pipeline.MaxSize || r.Height > pipeline.MaxSize ||
+			m[1] != "" && r.Width == 0 || m[2] != "" && r.Height == 0 {
+			return nil, fmt.Errorf("invalid size %q: sides must be 1 to %d", tc.Size, pipeline.MaxSize)
+		}
+		ts = append(ts, r)
+	}
+
+	if tc.Grayscale {
+		ts = append(ts, pipeline.Grayscale{})
+	}
+	if tc.Quality < 0 || tc.Quality > 100 {
+		return nil, fmt.Errorf("invalid quality %d: must be 1 to 100", tc.Quality)
+	}
+	return &pipeline.Pipeline{Transforms: ts, Quality: tc.Quality}, nil
+}
+
+// atoi converts digits matched by sizePattern or cropPattern; "" is 0.
+func atoi(s string) int {
+	n, _ := strconv.Atoi(s)
+	return n
+}
@@ -0,0 +1,197 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/idevakk/mediastream/internal/media"
+	"github.com/idevakk/mediastream/internal/pipeline"
+)
+
+// variantSpec describes a re-rendered form of a stream that a client asks
+// for with query parameters, such as /stream?w=640&h=360&fit=cover&q=60.
+type variantSpec struct {
+	resize  pipeline.Resize
+	quality int // 0 keeps the source's JPEG encoding when not resizing
+	fps     int
+}
+
+// parseVariant reads the w, h, fit, q and fps parameters of a stream
+// request. ok is false if none is set, so the client gets the stream as is.
+// fps is capped at the stream's own frame rate.
+func parseVariant(q url.Values, frameRate int) (spec variantSpec, ok bool, err error) {
+	intParam := func(name string, lo, hi int) (int, error) {
+		v := q.Get(name)
+		if v == "" {
+			return 0, nil
+		}
+		ok = true
+		n, err := strconv.Atoi(v)
+		if err != nil || n < lo || n > hi {
+			return 0, fmt.Errorf("invalid %s=%q: must be %d to %d", name, v, lo, hi)
+		}
+		return n, nil
+	}
+	if spec.resize.Width, err = intParam("w", 1, pipeline.MaxSize); err != nil {
+		return spec, false, err
+	}
+	if spec.resize.Height, err = intParam("h", 1, pipeline.MaxSize); err != nil {
+		return spec, false, err
+	}
+	if spec.quality, err = intParam("q", 1, 100); err != nil {
+		return spec, false, err
+	}
+	if spec.fps, err = intParam("fps", 1, 240); err != nil {
+		return spec, false, err
+	}
+	if q.Has("fit") {
+		ok = true
+	}
+	if spec.resize.Fit, err = pipeline.ParseFit(q.Get("fit")); err != nil {
+		return spec, false, err
+	}
+	if spec.fps == 0 || spec.fps > frameRate {
+		spec.fps = frameRate
+	}
+	return spec, ok, nil
+}
+
+// key identifies the variant; clients whose requests have the same key
+// share one.
+func (v variantSpec) key() string {
+	return fmt.Sprintf("w=%d&h=%d&fit=%s&q=%d&fps=%d",
+		v.resize.Width, v.resize.Height, v.resize.Fit, v.quality, v.fps)
+}
+
+// pipeline returns the re-rendering the variant needs, or nil if frames
+// pass through unchanged at a lower frame rate.
+func (v variantSpec) pipeline() *pipeline.Pipeline {
+	if v.resize.Width == 0 && v.resize.Height == 0 && v.quality == 0 {
+		return nil
+	}
+	return &pipeline.Pipeline{Transforms: []pipeline.Transform{v.resize}, Quality: v.quality}
+}
+
+// variant is a re-rendered form of a channel's stream with its own hub,
+// so each frame is transcoded once however many clients watch it.
+type variant struct {
+	key    string
+	hub    *hub
+	cancel context.CancelFunc
+	refs   int // clients using the variant; guarded by channel.mu
+}
+
+// acquireVariant returns the channel's variant for spec, starting it if
+// no client is watching it yet. Every call must be paired with
+// releaseVariant.
+func (c *channel) acquireVariant(spec variantSpec) *variant {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	key := spec.key()
+	v, ok := c.variants[key]
+	if !ok {
+		var src media.Source = newVariantSource(c.hub)
+		if pipe := spec.pipeline(); pipe != nil {
+			src = pipeline.Wrap(src, pipe)
+		}
+		h := newHub(src, spec.fps, c.cfg.ClientQueue)
+		h.variant, h.traffic, h.ids = key, c.hub.traffic, c.hub.ids
+		ctx, cancel := context.WithCancel(context.Background())
+		v = &variant{key: key, hub: h, cancel: cancel}
+		if c.variants == nil {
+			c.variants = make(map[string]*variant)
+		}
+		c.variants[key] = v
+		go func() {
+			h.run(ctx)
+			src.Close() //nolint:errcheck // only unsubscribes from the stream
+		}()
+	}
+	v.refs++
+	return v
+}
+
+// releaseVariant drops a client's use of v, stopping it after the last.
+func (c *channel) releaseVariant(v *variant) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	v.refs--
+	if v.refs == 0 {
+		v.cancel()
+		delete(c.variants, v.key)
+	}
+}
+
+// errNoFrame is returned by a variant before its stream has a frame.
+var errNoFrame = errors.New("no frame available yet")
+
+// variantSource feeds a variant's hub with the frames the channel's hub
+// publishes, passing on injected faults that disconnect viewers.
+type variantSource struct {
+	parent *hub
+	sub    *subscriber
+
+	mu     sync.Mutex
+	resets uint64 // parent resets already passed on
+}
+
+func newVariantSource(parent *hub) *variantSource {
+	return &variantSource{
+		parent: parent,
+		sub:    parent.subscribeFeed("variant"),
+		resets: parent.resetCount(),
+	}
+}
+
+// NextFrame returns the newest frame the channel has published since the
+// last call, waiting up to one of its frame intervals for the next one.
+// A variant at the channel's frame rate thus passes on every frame once;
+// a slower one skips to the newest.
+func (s *variantSource) NextFrame() ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.parent.inOutage() {
+		return nil, media.ErrOutage
+	}
+	if n := s.parent.resetCount(); n != s.resets {
+		s.resets = n
+		return nil, media.ErrConnectionReset
+	}
+
+	var frame []byte
+	select {
+	case frame = <-s.sub.frames:
+	case <-time.After(s.parent.interval):
+	}
+	// Skip to the newest frame if more have queued up.
+	for drained := false; !drained; {
+		select {
+		case f, ok := <-s.sub.frames:
+			if ok {
+				frame = f
+			} else {
+				drained = true
+			}
+		default:
+			drained = true
+		}
+	}
+	if frame == nil {
+		return nil, errNoFrame
+	}
+	return frame, nil
+}
+
+// Close stops the relay of frames from the channel's hub.
+func (s *variantSource) Close() error {
+	s.parent.unsubscribe(s.sub)
+	return nil
+}
@@ -0,0 +1,65 @@
+package server
+
+import (
+	"fmt"
+	"image/png"
+	"math"
+	"os"
+
+	"github.com/idevakk/mediastream/internal/pipeline"
+)
+
+// WatermarkConfig composites a PNG logo onto every frame of a stream.
+type WatermarkConfig struct {
+	// File is the PNG to draw; its alpha channel is kept.
+	File string `json:"file"`
+	// Position is top-left, top, top-right, left, center, right,
+	// bottom-left, bottom or bottom-right. Defaults to bottom-right.
+	Position string `json:"position,omitempty"`
+	// Scale is the logo's width as a fraction of the frame's width, e.g.
+	// 0.2. Zero draws the logo at its own size.
+	Scale float64 `json:"scale,omitempty"`
+	// Margin is the gap between the logo and the frame's edges, in
+	// pixels.
+	Margin int `json:"margin,omitempty"`
+	// Opacity fades the logo, from 0 (invisible) to 1. Defaults to 1 if
+	// nil.
+	Opacity *float64 `json:"opacity,omitempty"`
+}
+
+// watermark validates the config, loads the logo and builds the transform
+// that draws it.
+func (wc WatermarkConfig) watermark() (*pipeline.Watermark, error) {
+	if wc.File == "" {
+		return nil, fmt.Errorf("watermark needs a file")
+	}
+	f, err := os.Open(wc.File)
+	if err != nil {
+		return nil, fmt.Errorf("opening watermark: %w", err)
+	}
+	defer f.Close()
+	logo, err := png.Decode(f)
+	if err != nil {
+		return nil, fmt.Errorf("decoding watermark %q: %w", wc.File, err)
+	}
+
+	wm := &pipeline.Watermark{Image: logo, Position: pipeline.BottomRight, Scale: wc.Scale, Margin: wc.Margin, Opacity: 1}
+	if wc.Position != "" {
+		if wm.Position, err = pipeline.ParsePosition(wc.Position); err != nil {
+			return nil, err
+		}
+	}
+	if math.IsNaN(wc.Scale) || wc.Scale < 0 || wc.Scale > 1 {
+		return nil, fmt.Errorf("invalid watermark scale %g: must be between 0 and 1", wc.Scale)
+	}
+	if wc.Margin < 0 {
+		return nil, fmt.Errorf("invalid watermark margin %d: must not be negative", wc.Margin)
+	}
+	if wc.Opacity != nil {
+		if math.IsNaN(*wc.Opacity) || *wc.Opacity < 0 || *wc.Opacity > 1 {
+			return nil, fmt.Errorf("invalid watermark opacity %g: must be between 0 and 1", *wc.Opacity)
+		}
+		wm.Opacity = *wc.Opacity
+	}
+	return wm, nil
+}